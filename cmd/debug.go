@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"solidity-vm-go/internal/compiler"
+	"solidity-vm-go/internal/debugger"
+	"solidity-vm-go/internal/sourcemap"
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// debugContract runs the debug subcommand: it compiles a contract and
+// steps through its deployment, or through a call to it, at an interactive
+// prompt
+func debugContract(args []string) {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	deploy := flags.Bool("deploy", false, "debug the deployment instead of a call")
+	input := flags.String("input", common.Bytes2Hex(compiler.GetValueSelector), "calldata of the call, in hex")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: solidity-vm-go debug [-deploy] [-input hex] <solidity_file_path>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	source, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Printf("Error reading file: %v\n", err)
+		os.Exit(1)
+	}
+	result := compiler.Compile(string(source))
+	if result.Error != nil {
+		fmt.Printf("Compilation error: %v\n", result.Error)
+		os.Exit(1)
+	}
+	calldata := common.FromHex(*input)
+
+	d := debugger.New()
+	sources := []sourcemap.Source{{Name: flags.Arg(0), Content: string(source)}}
+	for _, code := range []struct {
+		bytecode  []byte
+		sourceMap string
+	}{
+		{result.Contract.Bytecode, result.SourceMap},
+		{result.RuntimeBytecode, result.RuntimeSourceMap},
+	} {
+		m, err := sourcemap.Parse(code.sourceMap)
+		if err != nil {
+			fmt.Printf("Invalid source map: %v\n", err)
+			os.Exit(1)
+		}
+		d.AddSource(sourcemap.NewMapper(code.bytecode, m, sources))
+	}
+
+	evm := vm.NewEVM(vm.DefaultContext(), vm.NewMemoryStateDB())
+	var run func(tracer vm.Tracer) vm.ExecutionResult
+	if *deploy {
+		run = func(tracer vm.Tracer) vm.ExecutionResult {
+			evm.Tracer = tracer
+			_, result := evm.Deploy(senderAddress, result.Contract.Bytecode, vm.DefaultGasLimit, nil)
+			return result
+		}
+	} else {
+		// Deploy without stopping, then debug the call
+		address, deployResult := evm.Deploy(senderAddress, result.Contract.Bytecode, vm.DefaultGasLimit, nil)
+		if !deployResult.Success {
+			fmt.Printf("Deployment failed: %v\n", deployResult.Error)
+			os.Exit(1)
+		}
+		run = func(tracer vm.Tracer) vm.ExecutionResult {
+			evm.Tracer = tracer
+			return evm.Call(senderAddress, address, calldata, vm.DefaultGasLimit, nil)
+		}
+	}
+
+	fmt.Println("Type help for the list of commands.")
+	if err := debugger.NewPrompt(d, os.Stdin, os.Stdout).Run(d.Start(run)); err != nil {
+		fmt.Printf("Error reading commands: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -1,46 +1,70 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"os"
 
 	"solidity-vm-go/internal/compiler"
 	"solidity-vm-go/internal/parser"
 	"solidity-vm-go/internal/vm"
 	"solidity-vm-go/pkg/utils"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
+// senderAddress sends the transactions of the demonstration
+var senderAddress = common.HexToAddress("0x1000000000000000000000000000000000000001")
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "debug" {
+		debugContract(os.Args[2:])
+		return
+	}
+
+	traceName := flag.String("trace", "", "write a trace of every transaction to stderr: json, call or prestate")
+	traceConfig := flag.String("trace-config", "", "JSON configuration of the tracer, such as {\"diffMode\":true}")
+	flag.Parse()
+	tracer, err := newTracer(*traceName, *traceConfig, os.Stderr)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
+
 	// Check if file path is provided
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: solidity-vm-go <solidity_file_path>")
+	if flag.NArg() < 1 {
+		fmt.Println("Usage: solidity-vm-go [--trace json|call|prestate [--trace-config json]] <solidity_file_path>")
+		fmt.Println("       solidity-vm-go debug [-deploy] [-input hex] <solidity_file_path>")
 		fmt.Println("Using default example contract...")
 
 		// Use the example contract
-		examplePath := "/home/admin03/Desktop/vm-poc/solidity-vm-go/examples/simple_contract.sol"
+		examplePath := "examples/simple_contract.sol"
 		source, err := ioutil.ReadFile(examplePath)
 		if err != nil {
 			fmt.Printf("Error reading example file: %v\n", err)
 			os.Exit(1)
 		}
 
-		runContract(string(source))
+		runContract(string(source), tracer)
 		return
 	}
 
 	// Read the provided Solidity file
-	filePath := os.Args[1]
+	filePath := flag.Arg(0)
 	source, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		fmt.Printf("Error reading file: %v\n", err)
 		os.Exit(1)
 	}
 
-	runContract(string(source))
+	runContract(string(source), tracer)
 }
 
-func runContract(source string) {
+// runContract compiles the contract, deploys it and calls it, reporting
+// every transaction to tracer if it is not nil
+func runContract(source string, tracer vm.Tracer) {
 	fmt.Println("Solidity VM PoC")
 	fmt.Println("==============")
 
@@ -64,7 +88,7 @@ func runContract(source string) {
 	bytecodeDisplayLength := 32
 	if len(result.Contract.Bytecode) < bytecodeDisplayLength {
 		bytecodeDisplayLength = len(result.Contract.Bytecode)
-		fmt.Printf("Bytecodeleng %s\n", bytecodeDisplayLength)
+		fmt.Printf("Bytecodeleng %d\n", bytecodeDisplayLength)
 	}
 	fmt.Printf("Bytecode: %s\n", utils.FormatBytecode(result.Contract.Bytecode[:bytecodeDisplayLength])+"...")
 
@@ -76,53 +100,44 @@ func runContract(source string) {
 	// Display the bytecode
 	fmt.Printf("Bytecode size: %d bytes\n", len(result.Contract.Bytecode))
 
-	// Initialize contract in VM
+	// The contract lives in a world state shared by all the calls below
+	evm := vm.NewEVM(vm.DefaultContext(), vm.NewMemoryStateDB())
+	evm.Tracer = tracer
+
+	// Run the creation code, which stores the runtime code it returns
 	fmt.Println("\nDeploying contract to VM...")
-	executionResult := vm.Execute(result.Contract, nil)
+	contractAddress, executionResult := evm.Deploy(senderAddress, result.Contract.Bytecode, vm.DefaultGasLimit, nil)
 
 	if !executionResult.Success {
 		fmt.Printf("Deployment failed: %v\n", executionResult.Error)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Contract deployed successfully\n")
+	fmt.Printf("Contract deployed successfully at %s\n", contractAddress.Hex())
 	fmt.Printf("Gas used: %d\n", executionResult.GasUsed)
 
-	// Execute setValue (function at position 6 in our simplified bytecode)
+	// Functions are selected by the first 4 bytes of calldata, the contract
+	// dispatches on them itself
 	fmt.Println("\nExecuting setValue(42)...")
 
-	// Create a new contract with the bytecode starting at the setValue function
-	setValueContract := vm.Contract{
-		Bytecode: result.Contract.Bytecode[6:12],
-		ABI:      result.Contract.ABI,
-	}
-
-	setValueResult := vm.Execute(setValueContract, nil)
+	setValueInput := append(append([]byte{}, compiler.SetValueSelector...), utils.BigIntToBytes(big.NewInt(42), 32)...)
+	setValueResult := evm.Call(senderAddress, contractAddress, setValueInput, vm.DefaultGasLimit, nil)
 	if !setValueResult.Success {
 		fmt.Printf("setValue execution failed: %v\n", setValueResult.Error)
 	} else {
 		fmt.Printf("setValue executed successfully\n")
 		fmt.Printf("Gas used: %d\n", setValueResult.GasUsed)
+		printLogs(setValueResult.Logs)
 	}
 
-	// Execute getValue (function at position 12 in our simplified bytecode)
 	fmt.Println("\nExecuting getValue()...")
 
-	// Create a new contract with the bytecode starting at the getValue function
-	getValueContract := vm.Contract{
-		Bytecode: result.Contract.Bytecode[12:],
-		ABI:      result.Contract.ABI,
-	}
-
-	getValueResult := vm.Execute(getValueContract, nil)
+	getValueResult := evm.Call(senderAddress, contractAddress, compiler.GetValueSelector, vm.DefaultGasLimit, nil)
 	if !getValueResult.Success {
 		fmt.Printf("getValue execution failed: %v\n", getValueResult.Error)
 	} else {
-		// Convert return data to uint64
-		var value uint64
-		if len(getValueResult.ReturnData) > 0 {
-			value = utils.BytesToUint64(getValueResult.ReturnData)
-		}
+		// Return data is a single 256-bit word
+		value := utils.BytesToBigInt(getValueResult.ReturnData)
 
 		fmt.Printf("getValue executed successfully\n")
 		fmt.Printf("Return value: %d\n", value)
@@ -131,3 +146,15 @@ func runContract(source string) {
 
 	fmt.Println("\nSolidity VM demonstration complete.")
 }
+
+// printLogs prints the events emitted by a transaction
+func printLogs(logs []*vm.Log) {
+	fmt.Printf("Logs: %d\n", len(logs))
+	for i, log := range logs {
+		fmt.Printf("  [%d] address: %s\n", i, log.Address.Hex())
+		for j, topic := range log.Topics {
+			fmt.Printf("      topic %d: %s\n", j, topic.Hex())
+		}
+		fmt.Printf("      data: %s\n", utils.FormatBytecode(log.Data))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"solidity-vm-go/internal/tracers"
+	"solidity-vm-go/internal/vm"
+)
+
+// newTracer creates the tracer selected by the --trace flag, writing to w.
+// config is the JSON configuration of the tracer, overriding its defaults
+// field by field, or empty. An empty name selects no tracer.
+func newTracer(name, config string, w io.Writer) (vm.Tracer, error) {
+	decode := func(cfg interface{}) error {
+		if config == "" {
+			return nil
+		}
+		if err := json.Unmarshal([]byte(config), cfg); err != nil {
+			return fmt.Errorf("invalid config of tracer %s: %v", name, err)
+		}
+		return nil
+	}
+	switch name {
+	case "":
+		return nil, nil
+	case "json":
+		var cfg tracers.JSONConfig
+		if err := decode(&cfg); err != nil {
+			return nil, err
+		}
+		return tracers.NewJSONTracer(w, cfg), nil
+	case "call":
+		cfg := tracers.CallConfig{WithLog: true}
+		if err := decode(&cfg); err != nil {
+			return nil, err
+		}
+		return resultWriter{tracers.NewCallTracer(cfg), w}, nil
+	case "prestate":
+		var cfg tracers.PrestateConfig
+		if err := decode(&cfg); err != nil {
+			return nil, err
+		}
+		return resultWriter{tracers.NewPrestateTracer(cfg), w}, nil
+	}
+	return nil, fmt.Errorf("unknown tracer %q, want json, call or prestate", name)
+}
+
+// resultTracer is a tracer building a JSON result for each transaction
+type resultTracer interface {
+	vm.Tracer
+	Result() (json.RawMessage, error)
+}
+
+// resultWriter writes the result of its tracer to w, one line per
+// transaction, when the transaction ends
+type resultWriter struct {
+	resultTracer
+	w io.Writer
+}
+
+func (r resultWriter) OnTxEnd(result vm.ExecutionResult) {
+	r.resultTracer.OnTxEnd(result)
+	res, err := r.Result()
+	if err != nil {
+		fmt.Fprintf(r.w, "Tracer error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(r.w, "%s\n", res)
+}
@@ -4,7 +4,11 @@ go 1.23.0
 
 toolchain go1.23.2
 
-require github.com/ethereum/go-ethereum v1.15.9
+require (
+	github.com/ethereum/go-ethereum v1.15.9
+	github.com/holiman/uint256 v1.3.2
+	golang.org/x/crypto v0.35.0
+)
 
 require (
 	github.com/Microsoft/go-winio v0.6.2 // indirect
@@ -22,13 +26,11 @@ require (
 	github.com/go-ole/go-ole v1.3.0 // indirect
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
-	github.com/holiman/uint256 v1.3.2 // indirect
 	github.com/mmcloughlin/addchain v0.4.0 // indirect
 	github.com/shirou/gopsutil v3.21.4-0.20210419000835-c7a38de76ee5+incompatible // indirect
 	github.com/supranational/blst v0.3.14 // indirect
 	github.com/tklauser/go-sysconf v0.3.12 // indirect
 	github.com/tklauser/numcpus v0.6.1 // indirect
-	golang.org/x/crypto v0.35.0 // indirect
 	golang.org/x/sync v0.11.0 // indirect
 	golang.org/x/sys v0.30.0 // indirect
 	rsc.io/tmplfunc v0.0.3 // indirect
@@ -1,7 +1,11 @@
 package compiler
 
 import (
+	"solidity-vm-go/internal/sourcemap"
 	"solidity-vm-go/internal/vm"
+	"solidity-vm-go/pkg/utils"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
 // Compiler struct to hold compiler state
@@ -32,16 +36,132 @@ func (c *Compiler) Compile(input interface{}) ([]byte, error) {
 // CompileResult represents the result of compiling Solidity code
 type CompileResult struct {
 	Contract vm.Contract
-	Error    error
+	// Runtime code deployed by the contract's creation code
+	RuntimeBytecode []byte
+	// Source maps of the creation and runtime code in solc's compressed
+	// format, with the compiled source as file 0
+	SourceMap        string
+	RuntimeSourceMap string
+	Error            error
 }
 
+// Selectors of the functions dispatched by the generated bytecode. Calldata
+// starts with one of them, followed by the ABI-encoded arguments.
+var (
+	SetValueSelector = utils.FunctionSelector("setValue(uint256)")
+	GetValueSelector = utils.FunctionSelector("getValue()")
+)
+
+// ValueChangedTopic is the first topic of the event logged by setValue. The
+// old value, the new value and the caller follow as data.
+var ValueChangedTopic = common.Hash(utils.EventTopic("ValueChanged(uint256,uint256,address)"))
+
 // Compile converts Solidity source code to bytecode
 // This is a simplified implementation that doesn't actually parse Solidity
-// but demonstrates the architecture
+// but demonstrates the architecture. The bytecode is creation code: it runs
+// the constructor and returns the runtime code to deploy.
+//
+// Each instruction is mapped to the part of source it implements when the
+// source has it: the contract, the constructor, setValue, getValue, an
+// internal _setValue doing the work of setValue, and the statement emitting
+// ValueChanged. Otherwise it is mapped to the closest enclosing part found,
+// or to the whole source.
 func Compile(source string) CompileResult {
-	// Create a larger, more complex bytecode to test
-	bytecode := []byte{
-		// Constructor with more operations
+	// Offsets of the jump targets in the runtime code
+	const (
+		setValueDest     = 0x1e
+		setValueReturn   = 0x27
+		setValueInternal = 0x29
+		getValueDest     = 0x62
+	)
+
+	contract := findDefinition(source, wholeSource(source), "contract ")
+	setValue := findDefinition(source, contract, "function setValue(")
+	setValueBody := findDefinition(source, contract, "function _setValue(")
+	if setValueBody == contract {
+		setValueBody = setValue
+	}
+	emit := findStatement(source, setValueBody, "emit ValueChanged(")
+
+	var runtime assembler
+	// Dispatcher: load the selector from the first 4 bytes of calldata and
+	// jump to the matching function
+	runtime.emit(contract,
+		byte(vm.PUSH1), 0x00,
+		byte(vm.CALLDATALOAD),
+		byte(vm.PUSH1), 0xe0,
+		byte(vm.SHR),
+		byte(vm.DUP1),
+		byte(vm.PUSH4), SetValueSelector[0], SetValueSelector[1], SetValueSelector[2], SetValueSelector[3],
+		byte(vm.EQ),
+		byte(vm.PUSH1), setValueDest,
+		byte(vm.JUMPI),
+		byte(vm.DUP1),
+		byte(vm.PUSH4), GetValueSelector[0], GetValueSelector[1], GetValueSelector[2], GetValueSelector[3],
+		byte(vm.EQ),
+		byte(vm.PUSH1), getValueDest,
+		byte(vm.JUMPI),
+
+		// Unknown selector: revert without data
+		byte(vm.PUSH1), 0x00,
+		byte(vm.DUP1),
+		byte(vm.REVERT),
+	)
+
+	// setValue(uint256): decode the argument and call the internal
+	// function with it, which returns to the STOP
+	runtime.emit(setValue,
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), setValueReturn,
+		byte(vm.PUSH1), 0x04,
+		byte(vm.CALLDATALOAD),
+		byte(vm.PUSH1), setValueInternal,
+	)
+	runtime.emit(withJump(setValue, sourcemap.JumpIn), byte(vm.JUMP))
+	runtime.emit(setValue,
+		byte(vm.JUMPDEST),
+		byte(vm.STOP),
+	)
+
+	// Internal setValue, called with the return address and the new value
+	// on the stack: store the new value in slot 0 and log
+	// ValueChanged(old, new, msg.sender)
+	runtime.emit(setValueBody,
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 0x00,
+		byte(vm.SLOAD),
+		byte(vm.PUSH1), 0x00,
+		byte(vm.MSTORE),
+		byte(vm.DUP1),
+		byte(vm.PUSH1), 0x20,
+		byte(vm.MSTORE),
+		byte(vm.CALLER),
+		byte(vm.PUSH1), 0x40,
+		byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x00,
+		byte(vm.SSTORE),
+	)
+	runtime.emit(emit, append(append([]byte{byte(vm.PUSH32)}, ValueChangedTopic[:]...),
+		byte(vm.PUSH1), 0x60,
+		byte(vm.PUSH1), 0x00,
+		byte(vm.LOG1),
+	)...)
+	runtime.emit(withJump(setValueBody, sourcemap.JumpOut), byte(vm.JUMP))
+
+	// getValue(): return slot 0 as a single word
+	runtime.emit(findDefinition(source, contract, "function getValue("),
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 0x00,
+		byte(vm.SLOAD),
+		byte(vm.PUSH1), 0x00,
+		byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20,
+		byte(vm.PUSH1), 0x00,
+		byte(vm.RETURN),
+	)
+
+	var creation assembler
+	creation.emit(findDefinition(source, contract, "constructor("),
 		byte(vm.PUSH1), 0x00,
 		byte(vm.PUSH1), 0x00,
 		byte(vm.SSTORE),
@@ -54,47 +174,35 @@ func Compile(source string) CompileResult {
 		byte(vm.PUSH1), 0x03,
 		byte(vm.PUSH1), 0x03,
 		byte(vm.SSTORE),
-		byte(vm.STOP),
+	)
+	creation.emit(contract, returnRuntime(len(creation.code), len(runtime.code))...)
 
-		// setValue function with more operations
-		byte(vm.PUSH1), 0x00,
-		byte(vm.PUSH1), 0x01,
-		byte(vm.ADD),
-		byte(vm.SSTORE),
-		byte(vm.PUSH1), 0x01,
-		byte(vm.PUSH1), 0x02,
-		byte(vm.SSTORE),
-		byte(vm.STOP),
-
-		// getValue function with more operations
-		byte(vm.PUSH1), 0x00,
-		byte(vm.SLOAD),
-		byte(vm.PUSH1), 0x01,
-		byte(vm.SLOAD),
-		byte(vm.ADD),
-		byte(vm.PUSH1), 0x02,
-		byte(vm.SLOAD),
-		byte(vm.ADD),
-		byte(vm.STOP),
-
-		// Additional dummy function to increase size
-		byte(vm.PUSH1), 0xFF,
-		byte(vm.PUSH1), 0xFF,
-		byte(vm.PUSH1), 0xFF,
-		byte(vm.PUSH1), 0xFF,
-		byte(vm.ADD),
-		byte(vm.ADD),
-		byte(vm.ADD),
-		byte(vm.STOP),
-	}
-
-	contract := vm.Contract{
-		Bytecode: bytecode,
-		ABI:      nil,
+	return CompileResult{
+		Contract: vm.Contract{
+			// The runtime code follows the creation code as data
+			Bytecode: append(creation.code, runtime.code...),
+			ABI:      nil,
+		},
+		RuntimeBytecode:  runtime.code,
+		SourceMap:        creation.locations.String(),
+		RuntimeSourceMap: runtime.locations.String(),
+		Error:            nil,
 	}
+}
 
-	return CompileResult{
-		Contract: contract,
-		Error:    nil,
+// returnRuntime returns code that, placed at offset in the creation code,
+// copies the size bytes of runtime code appended to it into memory and
+// returns them
+func returnRuntime(offset, size int) []byte {
+	// The copying code itself is 11 bytes long
+	start := offset + 11
+	return []byte{
+		byte(vm.PUSH1), byte(size),
+		byte(vm.DUP1),
+		byte(vm.PUSH1), byte(start),
+		byte(vm.PUSH1), 0x00,
+		byte(vm.CODECOPY),
+		byte(vm.PUSH1), 0x00,
+		byte(vm.RETURN),
 	}
 }
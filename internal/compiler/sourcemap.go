@@ -0,0 +1,135 @@
+package compiler
+
+import (
+	"strings"
+
+	"solidity-vm-go/internal/sourcemap"
+	"solidity-vm-go/internal/vm"
+)
+
+// assembler builds code one group of instructions at a time, recording the
+// source location of every instruction
+type assembler struct {
+	code      []byte
+	locations sourcemap.SourceMap
+}
+
+// emit appends code, which must consist of whole instructions, all
+// generated from the source at loc
+func (a *assembler) emit(loc sourcemap.Location, code ...byte) {
+	for pc := 0; pc < len(code); pc++ {
+		a.locations = append(a.locations, loc)
+		if op := vm.OpCode(code[pc]); op.IsPush() {
+			pc += int(op - vm.PUSH1 + 1)
+		}
+	}
+	a.code = append(a.code, code...)
+}
+
+// withJump returns loc marked as a jump into or out of a function
+func withJump(loc sourcemap.Location, jump sourcemap.Jump) sourcemap.Location {
+	loc.Jump = jump
+	return loc
+}
+
+// wholeSource returns the location of all of source, as file 0
+func wholeSource(source string) sourcemap.Location {
+	return sourcemap.Location{Start: 0, Length: len(source), File: 0, Jump: sourcemap.JumpNone}
+}
+
+// findDefinition returns the location of the first definition within the
+// given location that starts with prefix, such as "function f(", up to
+// the brace closing its body. It returns within itself if there is none.
+func findDefinition(source string, within sourcemap.Location, prefix string) sourcemap.Location {
+	code := codeOnly(source)
+	start, ok := indexWord(code, within, prefix)
+	if !ok {
+		return within
+	}
+	depth := 0
+	for i := start; i < within.Start+within.Length; i++ {
+		switch code[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return sourcemap.Location{Start: start, Length: i + 1 - start, File: 0, Jump: sourcemap.JumpNone}
+			}
+		}
+	}
+	return within
+}
+
+// findStatement returns the location of the first statement within the
+// given location that starts with prefix, up to its semicolon. It returns
+// within itself if there is none.
+func findStatement(source string, within sourcemap.Location, prefix string) sourcemap.Location {
+	code := codeOnly(source)
+	start, ok := indexWord(code, within, prefix)
+	if !ok {
+		return within
+	}
+	end := strings.IndexByte(code[start:within.Start+within.Length], ';')
+	if end < 0 {
+		return within
+	}
+	return sourcemap.Location{Start: start, Length: end + 1, File: 0, Jump: sourcemap.JumpNone}
+}
+
+// indexWord returns the offset of the first occurrence of prefix within
+// the given location of code that does not continue an identifier
+func indexWord(code string, within sourcemap.Location, prefix string) (int, bool) {
+	for from := within.Start; ; {
+		i := strings.Index(code[from:within.Start+within.Length], prefix)
+		if i < 0 {
+			return 0, false
+		}
+		i += from
+		if i == 0 || !isIdentifierByte(code[i-1]) {
+			return i, true
+		}
+		from = i + 1
+	}
+}
+
+// isIdentifierByte reports whether c can be part of an identifier
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// codeOnly returns source with comments and string literals blanked out,
+// so that searching it only finds code. Offsets are unchanged.
+func codeOnly(source string) string {
+	code := []byte(source)
+	for i := 0; i < len(code); i++ {
+		switch {
+		case strings.HasPrefix(source[i:], "//"):
+			for ; i < len(code) && code[i] != '\n'; i++ {
+				code[i] = ' '
+			}
+		case strings.HasPrefix(source[i:], "/*"):
+			end := strings.Index(source[i+2:], "*/")
+			if end < 0 {
+				end = len(code)
+			} else {
+				end += i + 4
+			}
+			for ; i < end; i++ {
+				if code[i] != '\n' {
+					code[i] = ' '
+				}
+			}
+			i--
+		case code[i] == '"' || code[i] == '\'':
+			quote := code[i]
+			for i++; i < len(code) && code[i] != quote && code[i] != '\n'; i++ {
+				if code[i] == '\\' && i+1 < len(code) {
+					code[i] = ' '
+					i++
+				}
+				code[i] = ' '
+			}
+		}
+	}
+	return string(code)
+}
@@ -0,0 +1,399 @@
+// Package debugger runs an execution one instruction at a time under the
+// control of its caller. Execution stops between steps, at breakpoints and
+// at faults, and while it is stopped the stack, memory, storage and return
+// data of the paused frame can be inspected.
+//
+// The debugger is a vm.Tracer: the execution runs in its own goroutine and
+// blocks in the tracer hooks whenever it has to stop, until the caller
+// resumes it with Step, Next, Out or Continue.
+package debugger
+
+import (
+	"bytes"
+	"fmt"
+
+	"solidity-vm-go/internal/sourcemap"
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// Reason tells why execution stopped
+type Reason int
+
+const (
+	// ReasonStep means a Step, Next or Out command completed
+	ReasonStep Reason = iota
+	// ReasonBreakpoint means a breakpoint was hit
+	ReasonBreakpoint
+	// ReasonFault means an instruction halted its frame exceptionally
+	ReasonFault
+	// ReasonFinished means the execution ended
+	ReasonFinished
+)
+
+// Stop describes where execution stopped. Unless it finished, the
+// instruction at PC is about to run, or has just faulted.
+type Stop struct {
+	Reason Reason
+	// Breakpoint hit, for ReasonBreakpoint
+	Breakpoint *Breakpoint
+
+	PC    uint64
+	Op    vm.OpCode
+	Gas   uint64 // gas left before the instruction
+	Cost  uint64 // gas charged for the instruction
+	Depth int    // call depth of the paused frame
+
+	// Error the instruction failed with, for ReasonFault
+	Err error
+	// Result of the execution, for ReasonFinished
+	Result *vm.ExecutionResult
+}
+
+// BreakpointKind selects what a breakpoint matches
+type BreakpointKind int
+
+const (
+	// BreakPC stops before the instruction at PC, in any frame
+	BreakPC BreakpointKind = iota
+	// BreakOpcode stops before every Op instruction
+	BreakOpcode
+	// BreakStorage stops before an SSTORE writing Slot, in any account
+	BreakStorage
+	// BreakDepth stops at the first instruction of frames at Depth
+	BreakDepth
+)
+
+// Breakpoint is a condition that stops execution before an instruction
+type Breakpoint struct {
+	ID    int
+	Kind  BreakpointKind
+	PC    uint64
+	Op    vm.OpCode
+	Slot  common.Hash
+	Depth int
+}
+
+// String describes the condition of the breakpoint
+func (b Breakpoint) String() string {
+	switch b.Kind {
+	case BreakPC:
+		return fmt.Sprintf("pc 0x%x", b.PC)
+	case BreakOpcode:
+		return "op " + b.Op.String()
+	case BreakStorage:
+		return "sstore " + b.Slot.Hex()
+	case BreakDepth:
+		return fmt.Sprintf("depth %d", b.Depth)
+	}
+	return "unknown breakpoint"
+}
+
+// Frame is a call frame on the call stack of the execution
+type Frame struct {
+	Type  vm.OpCode // CALL, CALLCODE, DELEGATECALL, STATICCALL, CREATE or CREATE2
+	From  common.Address
+	To    common.Address
+	Gas   uint64
+	Depth int
+}
+
+// mode is how far a command lets execution run before it stops again,
+// breakpoints and faults aside
+type mode int
+
+const (
+	modeStep     mode = iota // to the next instruction, in any frame
+	modeNext                 // to the next instruction not in a deeper frame
+	modeOut                  // to the next instruction in a shallower frame
+	modeContinue             // to the end
+)
+
+// Debugger controls an execution. The zero value is not usable; create one
+// with New.
+type Debugger struct {
+	vm.NoopTracer
+
+	breakpoints []Breakpoint
+	nextID      int
+	sources     []*sourcemap.Mapper
+
+	// Set by the controlling goroutine while execution is stopped
+	mode     mode
+	depth    int // depth of the frame the last command was given in
+	detached bool
+
+	// Set by the executing goroutine before it stops
+	scope   *vm.VM
+	frames  []Frame
+	entered bool // a frame was entered and has not run an instruction yet
+
+	stops   chan Stop
+	resume  chan struct{}
+	running bool
+	last    Stop
+}
+
+// New creates a debugger with no breakpoints
+func New() *Debugger {
+	return &Debugger{nextID: 1}
+}
+
+// Start runs the execution started by run, with the debugger as its
+// tracer, in a new goroutine. It stops before the first instruction and
+// returns where; an execution that runs no code finishes right away.
+func (d *Debugger) Start(run func(tracer vm.Tracer) vm.ExecutionResult) Stop {
+	d.stops = make(chan Stop)
+	d.resume = make(chan struct{})
+	d.mode = modeStep
+	d.detached = false
+	d.frames = nil
+	d.running = true
+	go func() {
+		result := run(d)
+		d.scope = nil
+		d.stops <- Stop{Reason: ReasonFinished, Result: &result}
+	}()
+	return d.wait()
+}
+
+// Step runs the next instruction, entering the frames it calls
+func (d *Debugger) Step() Stop {
+	return d.run(modeStep)
+}
+
+// Next runs the next instruction, including the whole of any frame it
+// calls
+func (d *Debugger) Next() Stop {
+	return d.run(modeNext)
+}
+
+// Out runs until the current frame returns to its caller
+func (d *Debugger) Out() Stop {
+	return d.run(modeOut)
+}
+
+// Continue runs until a breakpoint, a fault or the end of the execution
+func (d *Debugger) Continue() Stop {
+	return d.run(modeContinue)
+}
+
+// Close runs the execution to its end without stopping again and returns
+// its result. It is a no-op if the execution has already finished.
+func (d *Debugger) Close() *vm.ExecutionResult {
+	if d.running {
+		d.detached = true
+		d.resume <- struct{}{}
+		d.wait()
+	}
+	return d.last.Result
+}
+
+// Running reports whether an execution is stopped and can be resumed
+func (d *Debugger) Running() bool {
+	return d.running
+}
+
+// run resumes the execution in mode m and waits for it to stop
+func (d *Debugger) run(m mode) Stop {
+	if !d.running {
+		return d.last
+	}
+	d.mode = m
+	d.depth = d.last.Depth
+	d.resume <- struct{}{}
+	return d.wait()
+}
+
+// wait blocks until the execution stops
+func (d *Debugger) wait() Stop {
+	d.last = <-d.stops
+	if d.last.Reason == ReasonFinished {
+		d.running = false
+	}
+	return d.last
+}
+
+// pause stops the execution at stop in scope until it is resumed. It runs
+// on the executing goroutine.
+func (d *Debugger) pause(stop Stop, scope *vm.VM) {
+	d.scope = scope
+	d.stops <- stop
+	<-d.resume
+}
+
+// AddBreakpoint adds b and returns it with its ID set
+func (d *Debugger) AddBreakpoint(b Breakpoint) Breakpoint {
+	b.ID = d.nextID
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, b)
+	return b
+}
+
+// RemoveBreakpoint removes the breakpoint with the given ID and reports
+// whether there was one
+func (d *Debugger) RemoveBreakpoint(id int) bool {
+	for i, b := range d.breakpoints {
+		if b.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints returns the breakpoints in the order they were added
+func (d *Debugger) Breakpoints() []Breakpoint {
+	return append([]Breakpoint(nil), d.breakpoints...)
+}
+
+// hit returns the first breakpoint matching the instruction about to run
+func (d *Debugger) hit(pc uint64, op vm.OpCode, scope *vm.VM, entered bool) *Breakpoint {
+	for _, b := range d.breakpoints {
+		var match bool
+		switch b.Kind {
+		case BreakPC:
+			match = pc == b.PC
+		case BreakOpcode:
+			match = op == b.Op
+		case BreakStorage:
+			// The slot written is on top of the stack
+			match = op == vm.SSTORE && len(scope.Stack) > 0 && scope.Stack[len(scope.Stack)-1].Bytes32() == b.Slot
+		case BreakDepth:
+			match = entered && scope.Depth == b.Depth
+		}
+		if match {
+			return &b
+		}
+	}
+	return nil
+}
+
+// OnEnter pushes a frame onto the call stack
+func (d *Debugger) OnEnter(depth int, typ vm.OpCode, from, to common.Address, _ []byte, gas uint64, _ *uint256.Int) {
+	d.frames = append(d.frames, Frame{Type: typ, From: from, To: to, Gas: gas, Depth: depth})
+	d.entered = true
+}
+
+// OnExit pops the frame that ended
+func (d *Debugger) OnExit(int, []byte, uint64, error) {
+	d.frames = d.frames[:len(d.frames)-1]
+	d.entered = false
+}
+
+// OnOpcode stops before the instruction if a breakpoint matches it or the
+// command being run has reached it
+func (d *Debugger) OnOpcode(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.VM) {
+	entered := d.entered
+	d.entered = false
+	if d.detached {
+		return
+	}
+	stop := Stop{PC: pc, Op: op, Gas: gas, Cost: cost, Depth: scope.Depth}
+	if b := d.hit(pc, op, scope, entered); b != nil {
+		stop.Reason = ReasonBreakpoint
+		stop.Breakpoint = b
+		d.pause(stop, scope)
+		return
+	}
+	if d.mode == modeStep ||
+		(d.mode == modeNext && scope.Depth <= d.depth) ||
+		(d.mode == modeOut && scope.Depth < d.depth) {
+		stop.Reason = ReasonStep
+		d.pause(stop, scope)
+	}
+}
+
+// OnFault stops at every fault, so the failing frame can be inspected
+// before it is unwound
+func (d *Debugger) OnFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.VM, err error) {
+	if d.detached {
+		return
+	}
+	d.pause(Stop{Reason: ReasonFault, PC: pc, Op: op, Gas: gas, Cost: cost, Depth: scope.Depth, Err: err}, scope)
+}
+
+// AddSource registers the source map of some code, so that stops in frames
+// running that code can be mapped back to the source
+func (d *Debugger) AddSource(m *sourcemap.Mapper) {
+	d.sources = append(d.sources, m)
+}
+
+// Position returns the source position of the instruction execution is
+// stopped at, and the mapper it was found with, if the code of the paused
+// frame has a source map
+func (d *Debugger) Position() (sourcemap.Position, *sourcemap.Mapper, bool) {
+	if d.scope == nil || !d.running {
+		return sourcemap.Position{}, nil, false
+	}
+	for _, m := range d.sources {
+		if bytes.Equal(m.Code(), d.scope.Code) {
+			pos, ok := m.Lookup(d.last.PC)
+			return pos, m, ok
+		}
+	}
+	return sourcemap.Position{}, nil, false
+}
+
+// Frames returns the call stack, outermost frame first
+func (d *Debugger) Frames() []Frame {
+	return append([]Frame(nil), d.frames...)
+}
+
+// Address returns the account whose storage the paused frame uses
+func (d *Debugger) Address() common.Address {
+	if d.scope == nil {
+		return common.Address{}
+	}
+	return d.scope.Address
+}
+
+// Code returns the code the paused frame runs
+func (d *Debugger) Code() []byte {
+	if d.scope == nil {
+		return nil
+	}
+	return d.scope.Code
+}
+
+// Stack returns a copy of the stack of the paused frame, bottom first
+func (d *Debugger) Stack() []uint256.Int {
+	if d.scope == nil {
+		return nil
+	}
+	return append([]uint256.Int(nil), d.scope.Stack...)
+}
+
+// Memory returns a copy of the memory of the paused frame
+func (d *Debugger) Memory() []byte {
+	if d.scope == nil {
+		return nil
+	}
+	return append([]byte(nil), d.scope.Memory.Data()...)
+}
+
+// Storage returns the current value of a storage slot of the account the
+// paused frame runs on
+func (d *Debugger) Storage(key common.Hash) common.Hash {
+	if d.scope == nil {
+		return common.Hash{}
+	}
+	return d.scope.StateDB.GetState(d.scope.Address, key)
+}
+
+// ReturnData returns the return data of the last call made by the paused
+// frame or, once the execution has finished, its output
+func (d *Debugger) ReturnData() []byte {
+	if !d.running {
+		if d.last.Result == nil {
+			return nil
+		}
+		return d.last.Result.ReturnData
+	}
+	if d.scope == nil {
+		return nil
+	}
+	return d.scope.ReturnData
+}
@@ -0,0 +1,303 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"solidity-vm-go/internal/sourcemap"
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// promptHelp lists the commands understood by Prompt
+const promptHelp = `Commands:
+  s, step              run one instruction, entering calls
+  n, next              run one instruction, stepping over calls
+  o, out               run until the current frame returns
+  c, continue          run until a breakpoint, a fault or the end
+  b, break pc <n>      stop before the instruction at pc n
+  b, break op <name>   stop before every <name> instruction
+  b, break sstore <n>  stop before writes to storage slot n
+  b, break depth <n>   stop on entering a frame at call depth n
+  d, delete <id>       remove a breakpoint
+  breakpoints          list breakpoints
+  stack                show the stack, top first
+  memory [off [size]]  show memory
+  storage <slot>       show a storage slot of the current account
+  returndata           show the return data
+  where                show the call stack
+  q, quit              run to the end and exit
+An empty line repeats the last step, next, out or continue. Numbers are
+decimal or 0x-prefixed hex.`
+
+// Prompt drives a debugger from line-oriented commands, as typed at a
+// terminal
+type Prompt struct {
+	d   *Debugger
+	in  *bufio.Scanner
+	out io.Writer
+	// Last command that moved execution, repeated by an empty line
+	repeat string
+}
+
+// NewPrompt creates a prompt for d reading commands from in and writing to
+// out
+func NewPrompt(d *Debugger, in io.Reader, out io.Writer) *Prompt {
+	return &Prompt{d: d, in: bufio.NewScanner(in), out: out}
+}
+
+// Run shows where the execution stopped first, then reads and runs
+// commands until the input ends or quit is entered. The execution is run
+// to its end before Run returns.
+func (p *Prompt) Run(stop Stop) error {
+	defer p.d.Close()
+	p.showStop(stop)
+	for {
+		fmt.Fprint(p.out, "(debug) ")
+		if !p.in.Scan() {
+			fmt.Fprintln(p.out)
+			return p.in.Err()
+		}
+		args := strings.Fields(p.in.Text())
+		if len(args) == 0 {
+			args = strings.Fields(p.repeat)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if repeatable[args[0]] {
+			p.repeat = strings.Join(args, " ")
+		}
+		if quit := p.exec(args); quit {
+			return nil
+		}
+	}
+}
+
+// repeatable holds the commands an empty line repeats
+var repeatable = map[string]bool{
+	"s": true, "step": true,
+	"n": true, "next": true,
+	"o": true, "out": true,
+	"c": true, "continue": true,
+}
+
+// exec runs one command and reports whether it was quit
+func (p *Prompt) exec(args []string) bool {
+	switch cmd := args[0]; cmd {
+	case "s", "step":
+		p.showStop(p.d.Step())
+	case "n", "next":
+		p.showStop(p.d.Next())
+	case "o", "out":
+		p.showStop(p.d.Out())
+	case "c", "continue":
+		p.showStop(p.d.Continue())
+	case "b", "break":
+		b, err := parseBreakpoint(args[1:])
+		if err != nil {
+			fmt.Fprintf(p.out, "error: %v\n", err)
+			break
+		}
+		b = p.d.AddBreakpoint(b)
+		fmt.Fprintf(p.out, "breakpoint %d: %s\n", b.ID, b)
+	case "d", "delete":
+		id, err := parseID(args[1:])
+		if err == nil && !p.d.RemoveBreakpoint(id) {
+			err = fmt.Errorf("no breakpoint %d", id)
+		}
+		if err != nil {
+			fmt.Fprintf(p.out, "error: %v\n", err)
+		}
+	case "breakpoints":
+		for _, b := range p.d.Breakpoints() {
+			fmt.Fprintf(p.out, "%d: %s\n", b.ID, b)
+		}
+	case "stack":
+		stack := p.d.Stack()
+		for i := len(stack) - 1; i >= 0; i-- {
+			fmt.Fprintf(p.out, "%2d: %s\n", len(stack)-1-i, stack[i].Hex())
+		}
+	case "memory":
+		p.showMemory(args[1:])
+	case "storage":
+		if len(args) != 2 {
+			fmt.Fprintln(p.out, "error: usage: storage <slot>")
+			break
+		}
+		slot, err := parseNumber(args[1])
+		if err != nil {
+			fmt.Fprintf(p.out, "error: %v\n", err)
+			break
+		}
+		fmt.Fprintf(p.out, "%s[%s] = %s\n", p.d.Address().Hex(), slot.Hex(), p.d.Storage(slot.Bytes32()).Hex())
+	case "returndata":
+		fmt.Fprintf(p.out, "0x%x\n", p.d.ReturnData())
+	case "where":
+		for i, f := range p.d.Frames() {
+			fmt.Fprintf(p.out, "#%d %s %s -> %s gas=%d\n", i, f.Type, f.From.Hex(), f.To.Hex(), f.Gas)
+		}
+	case "q", "quit":
+		return true
+	case "h", "help":
+		fmt.Fprintln(p.out, promptHelp)
+	default:
+		fmt.Fprintf(p.out, "unknown command %q, try help\n", cmd)
+	}
+	return false
+}
+
+// showStop prints where execution stopped
+func (p *Prompt) showStop(stop Stop) {
+	switch stop.Reason {
+	case ReasonFinished:
+		p.showResult(stop.Result)
+		return
+	case ReasonBreakpoint:
+		fmt.Fprintf(p.out, "breakpoint %d (%s)\n", stop.Breakpoint.ID, stop.Breakpoint)
+	case ReasonFault:
+		fmt.Fprintf(p.out, "fault: %v\n", stop.Err)
+	}
+	fmt.Fprintf(p.out, "[%d] %s  gas=%d cost=%d\n", stop.Depth, disassemble(p.d.Code(), stop.PC), stop.Gas, stop.Cost)
+	if pos, m, ok := p.d.Position(); ok {
+		var jump string
+		switch pos.Location.Jump {
+		case sourcemap.JumpIn:
+			jump = " (jump in)"
+		case sourcemap.JumpOut:
+			jump = " (jump out)"
+		}
+		fmt.Fprintf(p.out, "    at %s%s: %s\n", pos, jump, strings.TrimSpace(m.LineText(pos)))
+	}
+}
+
+// showResult prints how the execution ended
+func (p *Prompt) showResult(result *vm.ExecutionResult) {
+	switch {
+	case result.Success:
+		fmt.Fprintf(p.out, "finished: success, gas used %d, output 0x%x\n", result.GasUsed, result.ReturnData)
+	case result.Reverted:
+		reason, ok := result.RevertReason()
+		if !ok {
+			reason = fmt.Sprintf("0x%x", result.ReturnData)
+		}
+		fmt.Fprintf(p.out, "finished: reverted, gas used %d, reason %s\n", result.GasUsed, reason)
+	default:
+		fmt.Fprintf(p.out, "finished: failed, gas used %d: %v\n", result.GasUsed, result.Error)
+	}
+}
+
+// showMemory prints memory in rows of 32 bytes, optionally only size bytes
+// from offset
+func (p *Prompt) showMemory(args []string) {
+	mem := p.d.Memory()
+	offset, size := uint64(0), uint64(len(mem))
+	for i, arg := range args[:min(len(args), 2)] {
+		n, err := parseNumber(arg)
+		if err != nil || !n.IsUint64() {
+			fmt.Fprintf(p.out, "error: invalid memory range %q\n", arg)
+			return
+		}
+		if i == 0 {
+			offset, size = n.Uint64(), 32
+		} else {
+			size = n.Uint64()
+		}
+	}
+	// Memory past its current size reads as zero
+	data := make([]byte, size)
+	if offset < uint64(len(mem)) {
+		copy(data, mem[offset:])
+	}
+	for row := uint64(0); row < size; row += 32 {
+		fmt.Fprintf(p.out, "0x%04x: %x\n", offset+row, data[row:min(row+32, size)])
+	}
+}
+
+// disassemble formats the instruction at pc with its push data
+func disassemble(code []byte, pc uint64) string {
+	if pc >= uint64(len(code)) {
+		return fmt.Sprintf("0x%04x: STOP", pc)
+	}
+	op := vm.OpCode(code[pc])
+	if !op.IsPush() {
+		return fmt.Sprintf("0x%04x: %s", pc, op)
+	}
+	data := make([]byte, op-vm.PUSH1+1)
+	copy(data, code[min(pc+1, uint64(len(code))):])
+	return fmt.Sprintf("0x%04x: %s 0x%x", pc, op, data)
+}
+
+// parseBreakpoint parses the arguments of the break command
+func parseBreakpoint(args []string) (Breakpoint, error) {
+	if len(args) != 2 {
+		return Breakpoint{}, errors.New("usage: break pc|op|sstore|depth <value>")
+	}
+	switch args[0] {
+	case "pc":
+		n, err := parseNumber(args[1])
+		if err != nil || !n.IsUint64() {
+			return Breakpoint{}, fmt.Errorf("invalid pc %q", args[1])
+		}
+		return Breakpoint{Kind: BreakPC, PC: n.Uint64()}, nil
+	case "op":
+		op, ok := vm.StringToOpCode(strings.ToUpper(args[1]))
+		if !ok {
+			return Breakpoint{}, fmt.Errorf("unknown opcode %q", args[1])
+		}
+		return Breakpoint{Kind: BreakOpcode, Op: op}, nil
+	case "sstore":
+		n, err := parseNumber(args[1])
+		if err != nil {
+			return Breakpoint{}, err
+		}
+		return Breakpoint{Kind: BreakStorage, Slot: common.Hash(n.Bytes32())}, nil
+	case "depth":
+		depth, err := strconv.Atoi(args[1])
+		if err != nil || depth < 0 {
+			return Breakpoint{}, fmt.Errorf("invalid depth %q", args[1])
+		}
+		return Breakpoint{Kind: BreakDepth, Depth: depth}, nil
+	}
+	return Breakpoint{}, fmt.Errorf("unknown breakpoint kind %q", args[0])
+}
+
+// parseID parses the breakpoint ID argument of the delete command
+func parseID(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("usage: delete <id>")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid breakpoint %q", args[0])
+	}
+	return id, nil
+}
+
+// parseNumber parses a 256-bit number, in hex if prefixed with 0x and in
+// decimal otherwise
+func parseNumber(s string) (*uint256.Int, error) {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		// uint256 rejects leading zeros in hex, which slots often have
+		trimmed := strings.TrimLeft(s[2:], "0")
+		if trimmed == "" {
+			return new(uint256.Int), nil
+		}
+		n, err := uint256.FromHex("0x" + trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", s)
+		}
+		return n, nil
+	}
+	n, err := uint256.FromDecimal(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,221 @@
+// Package sourcemap reads and writes source maps in the compressed format
+// of solc, and maps the program counters of compiled code back to the
+// file, line and column of the source it was compiled from.
+//
+// A source map has one entry per instruction, in code order, separated by
+// semicolons. Each entry is s:l:f:j, the start offset and length in bytes
+// of the source range the instruction was generated from, the index of the
+// source file, and whether the instruction jumps into (i) or out of (o) a
+// function or is a plain instruction (-). An empty field repeats the value
+// of the previous entry, and trailing empty fields are left out.
+package sourcemap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"solidity-vm-go/internal/vm"
+)
+
+// Jump tells whether an instruction enters or leaves a function
+type Jump byte
+
+const (
+	JumpNone Jump = '-' // a regular instruction
+	JumpIn   Jump = 'i' // a jump into a function
+	JumpOut  Jump = 'o' // a jump returning from a function
+)
+
+// Location is the source range an instruction was generated from. File is
+// -1, and Start and Length too, for code with no source of its own.
+type Location struct {
+	Start  int
+	Length int
+	File   int
+	Jump   Jump
+}
+
+// NoSource is the location of code generated without a source range
+var NoSource = Location{Start: -1, Length: -1, File: -1, Jump: JumpNone}
+
+// SourceMap holds the location of each instruction of some code, in code
+// order
+type SourceMap []Location
+
+// String encodes the source map in solc's compressed format
+func (m SourceMap) String() string {
+	var b strings.Builder
+	for i, loc := range m {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		fields := [4]string{strconv.Itoa(loc.Start), strconv.Itoa(loc.Length), strconv.Itoa(loc.File), string(loc.Jump)}
+		if i == 0 {
+			b.WriteString(strings.Join(fields[:], ":"))
+			continue
+		}
+		// Fields equal to the previous entry's are left empty, and
+		// dropped when nothing changes after them
+		prev := m[i-1]
+		same := [4]bool{loc.Start == prev.Start, loc.Length == prev.Length, loc.File == prev.File, loc.Jump == prev.Jump}
+		last := -1
+		for j := range fields {
+			if !same[j] {
+				last = j
+			}
+		}
+		for j := 0; j <= last; j++ {
+			if j > 0 {
+				b.WriteByte(':')
+			}
+			if !same[j] {
+				b.WriteString(fields[j])
+			}
+		}
+	}
+	return b.String()
+}
+
+// Parse decodes a source map in solc's compressed format. Fields after
+// the jump, such as the modifier depth of newer solc versions, are
+// ignored.
+func Parse(s string) (SourceMap, error) {
+	if s == "" {
+		return nil, nil
+	}
+	entries := strings.Split(s, ";")
+	m := make(SourceMap, len(entries))
+	prev := NoSource
+	for i, entry := range entries {
+		loc := prev
+		fields := strings.Split(entry, ":")
+		for j, field := range fields[:min(len(fields), 4)] {
+			if field == "" {
+				continue
+			}
+			if j == 3 {
+				if field != "-" && field != "i" && field != "o" {
+					return nil, fmt.Errorf("source map entry %d: invalid jump %q", i, field)
+				}
+				loc.Jump = Jump(field[0])
+				continue
+			}
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("source map entry %d: invalid number %q", i, field)
+			}
+			switch j {
+			case 0:
+				loc.Start = n
+			case 1:
+				loc.Length = n
+			case 2:
+				loc.File = n
+			}
+		}
+		m[i] = loc
+		prev = loc
+	}
+	return m, nil
+}
+
+// Source is a source file of a compilation. Its index in the list of
+// sources given to a Mapper is the file index of source map entries.
+type Source struct {
+	Name    string
+	Content string
+}
+
+// Position is a source location resolved to a file, line and column. Line
+// and Column count from 1, the column in bytes.
+type Position struct {
+	File     string // name of the source file
+	Line     int
+	Column   int
+	Location Location
+}
+
+// String formats the position as file:line:column
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Mapper maps the program counters of some code to source positions
+type Mapper struct {
+	code    []byte
+	m       SourceMap
+	sources []Source
+	// index of the instruction starting at each program counter, -1 within
+	// push data
+	instructions []int
+}
+
+// NewMapper creates a mapper for code, whose source map is m and whose
+// source files are sources
+func NewMapper(code []byte, m SourceMap, sources []Source) *Mapper {
+	instructions := make([]int, len(code))
+	n := 0
+	for pc := 0; pc < len(code); n++ {
+		instructions[pc] = n
+		op := vm.OpCode(code[pc])
+		pc++
+		if op.IsPush() {
+			for end := min(pc+int(op-vm.PUSH1+1), len(code)); pc < end; pc++ {
+				instructions[pc] = -1
+			}
+		}
+	}
+	return &Mapper{code: code, m: m, sources: sources, instructions: instructions}
+}
+
+// Code returns the code the mapper was created for
+func (mp *Mapper) Code() []byte {
+	return mp.code
+}
+
+// Location returns the source map entry of the instruction at pc, and
+// whether there is one
+func (mp *Mapper) Location(pc uint64) (Location, bool) {
+	if pc >= uint64(len(mp.instructions)) {
+		return Location{}, false
+	}
+	i := mp.instructions[pc]
+	if i < 0 || i >= len(mp.m) {
+		return Location{}, false
+	}
+	return mp.m[i], true
+}
+
+// Lookup returns the source position of the instruction at pc, and
+// whether it has one
+func (mp *Mapper) Lookup(pc uint64) (Position, bool) {
+	loc, ok := mp.Location(pc)
+	if !ok || loc.File < 0 || loc.File >= len(mp.sources) || loc.Start < 0 {
+		return Position{}, false
+	}
+	source := mp.sources[loc.File]
+	if loc.Start > len(source.Content) {
+		return Position{}, false
+	}
+	before := source.Content[:loc.Start]
+	lineStart := strings.LastIndexByte(before, '\n') + 1
+	return Position{
+		File:     source.Name,
+		Line:     strings.Count(before, "\n") + 1,
+		Column:   loc.Start - lineStart + 1,
+		Location: loc,
+	}, true
+}
+
+// LineText returns the text of the source line pos is on, without its line
+// break
+func (mp *Mapper) LineText(pos Position) string {
+	content := mp.sources[pos.Location.File].Content
+	start := pos.Location.Start - (pos.Column - 1)
+	end := strings.IndexByte(content[start:], '\n')
+	if end < 0 {
+		return content[start:]
+	}
+	return strings.TrimSuffix(content[start:start+end], "\r")
+}
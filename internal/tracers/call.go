@@ -0,0 +1,194 @@
+package tracers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/holiman/uint256"
+)
+
+// CallConfig configures a CallTracer, like the config of geth's callTracer
+type CallConfig struct {
+	OnlyTopCall bool `json:"onlyTopCall"` // record the transaction's frame only
+	WithLog     bool `json:"withLog"`     // record the logs emitted by each frame
+}
+
+// callLog is a log emitted by a frame. Position is the number of calls the
+// frame had made when it was emitted, placing it among them.
+type callLog struct {
+	Address  common.Address `json:"address"`
+	Topics   []common.Hash  `json:"topics"`
+	Data     hexutil.Bytes  `json:"data"`
+	Position hexutil.Uint   `json:"position"`
+}
+
+// callFrame is a frame of the call tree. The fields are in the order geth
+// writes them.
+type callFrame struct {
+	From         common.Address  `json:"from"`
+	Gas          hexutil.Uint64  `json:"gas"`
+	GasUsed      hexutil.Uint64  `json:"gasUsed"`
+	To           *common.Address `json:"to,omitempty"`
+	Input        hexutil.Bytes   `json:"input"`
+	Output       hexutil.Bytes   `json:"output,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	RevertReason string          `json:"revertReason,omitempty"`
+	Calls        []callFrame     `json:"calls,omitempty"`
+	Logs         []callLog       `json:"logs,omitempty"`
+	Value        *hexutil.Big    `json:"value,omitempty"`
+	Type         string          `json:"type"`
+
+	typ    vm.OpCode
+	failed bool
+}
+
+// setOutput records how the frame ended
+func (f *callFrame) setOutput(output []byte, err error) {
+	output = common.CopyBytes(output)
+	if err == nil {
+		f.Output = output
+		return
+	}
+	f.Error = frameError(err).Error()
+	f.failed = true
+	if f.typ == vm.CREATE || f.typ == vm.CREATE2 {
+		// No contract was created
+		f.To = nil
+	}
+	if !errors.Is(err, vm.ErrExecutionReverted) || len(output) == 0 {
+		return
+	}
+	f.Output = output
+	if reason, err := abi.UnpackRevert(output); err == nil {
+		f.RevertReason = reason
+	}
+}
+
+// CallTracer records the call tree of a transaction in the nested JSON
+// shape of geth's callTracer: the type, accounts, value, gas, input and
+// output of each frame, how it failed, the frames it called and, with
+// WithLog, the logs it emitted. The logs of failed frames are dropped, as
+// they are from the state.
+//
+// The gas and gas used of the transaction's frame are those of the whole
+// transaction, intrinsic gas included and the refund paid back.
+type CallTracer struct {
+	vm.NoopTracer
+
+	cfg       CallConfig
+	callstack []callFrame
+	gasLimit  uint64
+	depth     int
+}
+
+// NewCallTracer creates a call tracer
+func NewCallTracer(cfg CallConfig) *CallTracer {
+	return &CallTracer{cfg: cfg}
+}
+
+// OnTxStart starts a new call tree, so that a tracer can be used for one
+// transaction after another
+func (t *CallTracer) OnTxStart(_ *vm.EVM, _, _ common.Address, _ bool, _ []byte, gas uint64, _ *uint256.Int) {
+	t.callstack = t.callstack[:0]
+	t.gasLimit = gas
+	t.depth = 0
+}
+
+// OnTxEnd records the gas used by the transaction, net of its refund, and
+// drops the logs of the frames that failed
+func (t *CallTracer) OnTxEnd(result vm.ExecutionResult) {
+	if len(t.callstack) != 1 {
+		return
+	}
+	t.callstack[0].GasUsed = hexutil.Uint64(result.GasUsed - result.GasRefund)
+	if t.cfg.WithLog {
+		clearFailedLogs(&t.callstack[0], false)
+	}
+}
+
+// OnEnter pushes a new frame
+func (t *CallTracer) OnEnter(depth int, typ vm.OpCode, from, to common.Address, input []byte, gas uint64, value *uint256.Int) {
+	t.depth = depth
+	if t.cfg.OnlyTopCall && depth > 0 {
+		return
+	}
+	frame := callFrame{
+		From:  from,
+		Gas:   hexutil.Uint64(gas),
+		To:    &to,
+		Input: common.CopyBytes(input),
+		Type:  typ.String(),
+		typ:   typ,
+	}
+	// A static call transfers no value, not even zero
+	if value != nil && typ != vm.STATICCALL {
+		frame.Value = (*hexutil.Big)(value.ToBig())
+	}
+	if depth == 0 {
+		frame.Gas = hexutil.Uint64(t.gasLimit)
+	}
+	t.callstack = append(t.callstack, frame)
+}
+
+// OnExit pops the frame that ended into the calls of its caller
+func (t *CallTracer) OnExit(depth int, output []byte, gasUsed uint64, err error) {
+	if depth == 0 {
+		if len(t.callstack) == 1 {
+			t.callstack[0].setOutput(output, err)
+		}
+		return
+	}
+	t.depth = depth - 1
+	if t.cfg.OnlyTopCall {
+		return
+	}
+	size := len(t.callstack)
+	if size <= 1 {
+		return
+	}
+	frame := t.callstack[size-1]
+	t.callstack = t.callstack[:size-1]
+	frame.GasUsed = hexutil.Uint64(gasUsed)
+	frame.setOutput(output, err)
+	parent := &t.callstack[size-2]
+	parent.Calls = append(parent.Calls, frame)
+}
+
+// OnLog records a log in the frame emitting it
+func (t *CallTracer) OnLog(log *vm.Log) {
+	if !t.cfg.WithLog || (t.cfg.OnlyTopCall && t.depth > 0) || len(t.callstack) == 0 {
+		return
+	}
+	frame := &t.callstack[len(t.callstack)-1]
+	frame.Logs = append(frame.Logs, callLog{
+		Address:  log.Address,
+		Topics:   append([]common.Hash(nil), log.Topics...),
+		Data:     common.CopyBytes(log.Data),
+		Position: hexutil.Uint(len(frame.Calls)),
+	})
+}
+
+// Result returns the call tree of the last transaction as JSON
+func (t *CallTracer) Result() (json.RawMessage, error) {
+	if len(t.callstack) != 1 {
+		return nil, errors.New("incorrect number of top-level calls")
+	}
+	return json.Marshal(t.callstack[0])
+}
+
+// clearFailedLogs drops the logs of frame and of the frames it called if
+// it or its caller failed
+func clearFailedLogs(frame *callFrame, parentFailed bool) {
+	failed := frame.failed || parentFailed
+	if failed {
+		frame.Logs = nil
+	}
+	for i := range frame.Calls {
+		clearFailedLogs(&frame.Calls[i], failed)
+	}
+}
@@ -0,0 +1,127 @@
+package tracers
+
+import (
+	"encoding/json"
+	"io"
+
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// JSONConfig selects the optional fields of the steps a JSONTracer writes
+type JSONConfig struct {
+	EnableMemory     bool `json:"enableMemory"`     // write the memory of the frame
+	DisableStack     bool `json:"disableStack"`     // leave out the stack of the frame
+	EnableReturnData bool `json:"enableReturnData"` // write the return data of the last call
+}
+
+// jsonStep is a step of an EIP-3155 trace. The fields are in the order
+// geth writes them.
+type jsonStep struct {
+	PC         uint64         `json:"pc"`
+	Op         vm.OpCode      `json:"op"`
+	Gas        hexutil.Uint64 `json:"gas"`
+	GasCost    hexutil.Uint64 `json:"gasCost"`
+	Memory     hexutil.Bytes  `json:"memory,omitempty"`
+	MemorySize int            `json:"memSize"`
+	Stack      []hexutil.U256 `json:"stack"`
+	ReturnData hexutil.Bytes  `json:"returnData,omitempty"`
+	Depth      int            `json:"depth"`
+	Refund     uint64         `json:"refund"`
+	OpName     string         `json:"opName"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// jsonSummary ends the trace of a transaction
+type jsonSummary struct {
+	Output  string         `json:"output"`
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// JSONTracer writes an execution as an EIP-3155 trace: one JSON object per
+// line for every instruction, in every frame, and a single summary line
+// with the output, gas used and error of the transaction once its frame
+// ends. This is the output of geth's `evm run --json` and `evm t8n
+// --trace`, so the two can be diffed directly.
+//
+// A fault writes its instruction again with the error set, unless the
+// instruction failed before it could be traced, like geth does.
+type JSONTracer struct {
+	vm.NoopTracer
+
+	encoder *json.Encoder
+	cfg     JSONConfig
+	err     error
+}
+
+// NewJSONTracer creates a tracer writing to w
+func NewJSONTracer(w io.Writer, cfg JSONConfig) *JSONTracer {
+	return &JSONTracer{encoder: json.NewEncoder(w), cfg: cfg}
+}
+
+// Err returns the first error writing the trace failed with
+func (t *JSONTracer) Err() error {
+	return t.err
+}
+
+// OnOpcode writes the step about to run
+func (t *JSONTracer) OnOpcode(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.VM) {
+	t.writeStep(pc, op, gas, cost, scope, nil)
+}
+
+// OnFault writes the step that failed, with its error
+func (t *JSONTracer) OnFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.VM, err error) {
+	t.writeStep(pc, op, gas, cost, scope, err)
+}
+
+// OnExit writes the summary once the transaction's frame ends
+func (t *JSONTracer) OnExit(depth int, output []byte, gasUsed uint64, err error) {
+	if depth > 0 {
+		return
+	}
+	summary := jsonSummary{Output: common.Bytes2Hex(output), GasUsed: hexutil.Uint64(gasUsed)}
+	if err != nil {
+		summary.Error = frameError(err).Error()
+	}
+	t.write(summary)
+}
+
+func (t *JSONTracer) writeStep(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.VM, err error) {
+	memory := scope.Memory.Data()
+	step := jsonStep{
+		PC:         pc,
+		Op:         op,
+		Gas:        hexutil.Uint64(gas),
+		GasCost:    hexutil.Uint64(cost),
+		MemorySize: len(memory),
+		Depth:      scope.Depth + 1, // EIP-3155 counts depth from 1
+		Refund:     scope.StateDB.GetRefund(),
+		OpName:     op.String(),
+	}
+	if t.cfg.EnableMemory {
+		step.Memory = memory
+	}
+	if !t.cfg.DisableStack {
+		step.Stack = make([]hexutil.U256, len(scope.Stack))
+		for i, value := range scope.Stack {
+			step.Stack[i] = hexutil.U256(value)
+		}
+	}
+	if t.cfg.EnableReturnData {
+		step.ReturnData = scope.ReturnData
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	t.write(step)
+}
+
+func (t *JSONTracer) write(v interface{}) {
+	if t.err != nil {
+		return
+	}
+	t.err = t.encoder.Encode(v)
+}
@@ -0,0 +1,223 @@
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/holiman/uint256"
+)
+
+// PrestateConfig configures a PrestateTracer, like the config of geth's
+// prestateTracer
+type PrestateConfig struct {
+	DiffMode       bool `json:"diffMode"`       // record the changes made, along with the state they changed
+	DisableCode    bool `json:"disableCode"`    // leave out the code of accounts
+	DisableStorage bool `json:"disableStorage"` // leave out storage
+}
+
+// account is the state of an account. The fields are in the order geth
+// writes them.
+type account struct {
+	Balance *hexutil.Big                `json:"balance,omitempty"`
+	Code    hexutil.Bytes               `json:"code,omitempty"`
+	Nonce   uint64                      `json:"nonce,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+
+	empty bool // the account did not exist
+}
+
+// stateMap is the state of the accounts a transaction touched
+type stateMap map[common.Address]*account
+
+// PrestateTracer records the state a transaction needs, in the JSON shape
+// of geth's prestateTracer: the balance, nonce and code of every account it
+// touched and the storage slots it read or wrote, as they were before it
+// ran. That is enough state to replay the transaction.
+//
+// In diff mode it records what the transaction changed instead: the new
+// values of the fields and slots it modified as the post state, and their
+// old values as the pre state. Accounts it did not modify are left out of
+// both, and so are self-destructed accounts from the post state.
+type PrestateTracer struct {
+	vm.NoopTracer
+
+	cfg     PrestateConfig
+	state   vm.StateDB
+	pre     stateMap
+	post    stateMap
+	created map[common.Address]bool
+	deleted map[common.Address]bool
+}
+
+// NewPrestateTracer creates a prestate tracer
+func NewPrestateTracer(cfg PrestateConfig) *PrestateTracer {
+	return &PrestateTracer{cfg: cfg}
+}
+
+// OnTxStart records the accounts every transaction touches: the sender,
+// the recipient or contract created, and the coinbase
+func (t *PrestateTracer) OnTxStart(evm *vm.EVM, from, to common.Address, create bool, _ []byte, _ uint64, _ *uint256.Int) {
+	t.state = evm.StateDB
+	t.pre = stateMap{}
+	t.post = stateMap{}
+	t.created = make(map[common.Address]bool)
+	t.deleted = make(map[common.Address]bool)
+	if create {
+		t.created[to] = true
+	}
+	t.lookupAccount(from)
+	t.lookupAccount(to)
+	t.lookupAccount(evm.Context.Coinbase)
+}
+
+// OnTxEnd computes the changes in diff mode, and drops the contracts the
+// transaction created, which had no state before it
+func (t *PrestateTracer) OnTxEnd(vm.ExecutionResult) {
+	if t.cfg.DiffMode {
+		t.diff()
+	}
+	for addr := range t.created {
+		if acc := t.pre[addr]; acc != nil && acc.empty {
+			delete(t.pre, addr)
+		}
+	}
+}
+
+// OnOpcode records the accounts and slots the instruction accesses
+func (t *PrestateTracer) OnOpcode(_ uint64, op vm.OpCode, _, _ uint64, scope *vm.VM) {
+	stack := scope.Stack
+	size := len(stack)
+	switch {
+	case size >= 1 && (op == vm.SLOAD || op == vm.SSTORE):
+		t.lookupStorage(scope.Address, common.Hash(stack[size-1].Bytes32()))
+	case size >= 1 && (op == vm.EXTCODECOPY || op == vm.EXTCODEHASH || op == vm.EXTCODESIZE || op == vm.BALANCE || op == vm.SELFDESTRUCT):
+		t.lookupAccount(common.Address(stack[size-1].Bytes20()))
+		if op == vm.SELFDESTRUCT {
+			t.deleted[scope.Address] = true
+		}
+	case size >= 5 && (op == vm.CALL || op == vm.CALLCODE || op == vm.DELEGATECALL || op == vm.STATICCALL):
+		t.lookupAccount(common.Address(stack[size-2].Bytes20()))
+	case op == vm.CREATE:
+		addr := vm.CreateAddress(scope.Address, t.state.GetNonce(scope.Address))
+		t.lookupAccount(addr)
+		t.created[addr] = true
+	case size >= 4 && op == vm.CREATE2:
+		// Memory has not been expanded for the instruction yet
+		offset, length := stack[size-2], stack[size-3]
+		if !offset.IsUint64() || !length.IsUint64() {
+			return
+		}
+		initCode := paddedSlice(scope.Memory.Data(), offset.Uint64(), length.Uint64())
+		addr := vm.CreateAddress2(scope.Address, stack[size-4].Bytes32(), initCode)
+		t.lookupAccount(addr)
+		t.created[addr] = true
+	}
+}
+
+// Result returns the state recorded for the last transaction as JSON
+func (t *PrestateTracer) Result() (json.RawMessage, error) {
+	if t.cfg.DiffMode {
+		return json.Marshal(struct {
+			Post stateMap `json:"post"`
+			Pre  stateMap `json:"pre"`
+		}{t.post, t.pre})
+	}
+	return json.Marshal(t.pre)
+}
+
+// lookupAccount records the state of addr, unless it already is
+func (t *PrestateTracer) lookupAccount(addr common.Address) {
+	if _, ok := t.pre[addr]; ok {
+		return
+	}
+	balance := t.state.GetBalance(addr)
+	acc := &account{
+		Balance: (*hexutil.Big)(balance.ToBig()),
+		Nonce:   t.state.GetNonce(addr),
+		Code:    t.state.GetCode(addr),
+	}
+	acc.empty = acc.Nonce == 0 && len(acc.Code) == 0 && balance.IsZero()
+	if t.cfg.DisableCode {
+		acc.Code = nil
+	}
+	if !t.cfg.DisableStorage {
+		acc.Storage = make(map[common.Hash]common.Hash)
+	}
+	t.pre[addr] = acc
+}
+
+// lookupStorage records the value of a slot of addr, unless it already is
+func (t *PrestateTracer) lookupStorage(addr common.Address, key common.Hash) {
+	if t.cfg.DisableStorage {
+		return
+	}
+	t.lookupAccount(addr)
+	if _, ok := t.pre[addr].Storage[key]; ok {
+		return
+	}
+	t.pre[addr].Storage[key] = t.state.GetState(addr, key)
+}
+
+// diff records the post state of the accounts the transaction modified,
+// and trims their pre state to what was modified
+func (t *PrestateTracer) diff() {
+	for addr, acc := range t.pre {
+		// A self-destructed account has no post state
+		if t.deleted[addr] {
+			continue
+		}
+		modified := false
+		post := &account{Storage: make(map[common.Hash]common.Hash)}
+		if balance := t.state.GetBalance(addr).ToBig(); balance.Cmp((*big.Int)(acc.Balance)) != 0 {
+			modified = true
+			post.Balance = (*hexutil.Big)(balance)
+		}
+		if nonce := t.state.GetNonce(addr); nonce != acc.Nonce {
+			modified = true
+			post.Nonce = nonce
+		}
+		if !t.cfg.DisableCode {
+			if code := t.state.GetCode(addr); !bytes.Equal(code, acc.Code) {
+				modified = true
+				post.Code = code
+			}
+		}
+		if !t.cfg.DisableStorage {
+			for key, value := range acc.Storage {
+				current := t.state.GetState(addr, key)
+				// Slots that were empty or did not change are left out of
+				// the pre state, and slots that were cleared of the post
+				// state
+				if value == (common.Hash{}) || value == current {
+					delete(acc.Storage, key)
+				}
+				if value != current {
+					modified = true
+					if current != (common.Hash{}) {
+						post.Storage[key] = current
+					}
+				}
+			}
+		}
+		if modified {
+			t.post[addr] = post
+		} else {
+			delete(t.pre, addr)
+		}
+	}
+}
+
+// paddedSlice returns size bytes of data at offset, padded with zeros past
+// its end
+func paddedSlice(data []byte, offset, size uint64) []byte {
+	out := make([]byte, size)
+	if offset < uint64(len(data)) {
+		copy(out, data[offset:])
+	}
+	return out
+}
@@ -0,0 +1,20 @@
+// Package tracers implements vm.Tracer with the output formats of geth's
+// tracers, so that executions of this VM can be compared with executions
+// of geth.
+package tracers
+
+import (
+	"errors"
+
+	"solidity-vm-go/internal/vm"
+)
+
+// frameError returns the error a frame ended with as geth reports it: the
+// error of the instruction that failed, without where it happened
+func frameError(err error) error {
+	var execErr *vm.ExecutionError
+	if errors.As(err, &execErr) {
+		return execErr.Err
+	}
+	return err
+}
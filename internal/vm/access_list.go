@@ -0,0 +1,63 @@
+package vm
+
+import "github.com/ethereum/go-ethereum/common"
+
+// AccessList records the accounts and storage slots touched during a
+// transaction. Since Berlin (EIP-2929) the first, "cold", access to each
+// of them costs more than later, "warm", accesses.
+type AccessList struct {
+	addresses map[common.Address]map[common.Hash]struct{}
+}
+
+// NewAccessList creates an empty access list
+func NewAccessList() *AccessList {
+	return &AccessList{addresses: make(map[common.Address]map[common.Hash]struct{})}
+}
+
+// ContainsAddress reports whether addr is warm
+func (al *AccessList) ContainsAddress(addr common.Address) bool {
+	_, ok := al.addresses[addr]
+	return ok
+}
+
+// Contains reports whether addr and the slot within it are warm
+func (al *AccessList) Contains(addr common.Address, slot common.Hash) (addressOk, slotOk bool) {
+	slots, addressOk := al.addresses[addr]
+	if !addressOk {
+		return false, false
+	}
+	_, slotOk = slots[slot]
+	return addressOk, slotOk
+}
+
+// AddAddress warms addr and reports whether it was cold before
+func (al *AccessList) AddAddress(addr common.Address) bool {
+	if _, ok := al.addresses[addr]; ok {
+		return false
+	}
+	al.addresses[addr] = make(map[common.Hash]struct{})
+	return true
+}
+
+// AddSlot warms a slot, and its account if needed, and reports which of
+// the two were cold before
+func (al *AccessList) AddSlot(addr common.Address, slot common.Hash) (addressAdded, slotAdded bool) {
+	addressAdded = al.AddAddress(addr)
+	slots := al.addresses[addr]
+	if _, ok := slots[slot]; ok {
+		return addressAdded, false
+	}
+	slots[slot] = struct{}{}
+	return addressAdded, true
+}
+
+// deleteAddress makes addr cold again. Its slots must have been removed
+// first.
+func (al *AccessList) deleteAddress(addr common.Address) {
+	delete(al.addresses, addr)
+}
+
+// deleteSlot makes a slot cold again, leaving its account warm
+func (al *AccessList) deleteSlot(addr common.Address, slot common.Hash) {
+	delete(al.addresses[addr], slot)
+}
@@ -0,0 +1,60 @@
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+// jumpdestBitmap has one bit per code position, set when that position holds
+// a JUMPDEST instruction rather than push data
+type jumpdestBitmap []byte
+
+// has reports whether pos is a valid jump destination
+func (b jumpdestBitmap) has(pos uint64) bool {
+	if pos/8 >= uint64(len(b)) {
+		return false
+	}
+	return b[pos/8]&(1<<(pos%8)) != 0
+}
+
+// analyzeJumpdests walks the code once, skipping push data, and marks every
+// JUMPDEST it encounters
+func analyzeJumpdests(code []byte) jumpdestBitmap {
+	bitmap := make(jumpdestBitmap, len(code)/8+1)
+	for pc := 0; pc < len(code); pc++ {
+		op := OpCode(code[pc])
+		switch {
+		case op == JUMPDEST:
+			bitmap[pc/8] |= 1 << (pc % 8)
+		case op.IsPush():
+			pc += int(op - PUSH1 + 1)
+		}
+	}
+	return bitmap
+}
+
+// jumpdestsFor returns the jumpdest bitmap for code. Analyses are cached by
+// code hash for the rest of the transaction, so that code run repeatedly
+// is only analyzed once.
+func (evm *EVM) jumpdestsFor(code []byte) jumpdestBitmap {
+	hash := crypto.Keccak256Hash(code)
+	if bitmap, ok := evm.jumpdests[hash]; ok {
+		return bitmap
+	}
+	if evm.jumpdests == nil {
+		evm.jumpdests = make(map[common.Hash]jumpdestBitmap)
+	}
+	bitmap := analyzeJumpdests(code)
+	evm.jumpdests[hash] = bitmap
+	return bitmap
+}
+
+// validJumpdest reports whether dest points at a JUMPDEST instruction in
+// the code running on vm
+func (vm *VM) validJumpdest(dest *uint256.Int) bool {
+	if !dest.IsUint64() {
+		return false
+	}
+	return vm.jumpdests.has(dest.Uint64())
+}
@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// DefaultGasLimit is the gas given to executions that do not set a limit
+const DefaultGasLimit uint64 = 30_000_000
+
+// ExecutionContext describes the transaction, block and call an execution
+// runs in. It backs the environment opcodes such as CALLER, NUMBER and
+// CHAINID. Nil numeric fields read as zero.
+type ExecutionContext struct {
+	// Hardfork whose instruction set and gas schedule apply
+	Fork Fork
+
+	// Transaction
+	Origin   common.Address // sender of the transaction (ORIGIN)
+	GasPrice *uint256.Int   // effective gas price (GASPRICE)
+
+	// Call
+	Caller   common.Address // immediate caller (CALLER)
+	Address  common.Address // account whose code runs (ADDRESS)
+	Value    *uint256.Int   // wei sent with the call (CALLVALUE)
+	GasLimit uint64         // gas available to the execution
+
+	// Block
+	Coinbase      common.Address // block beneficiary (COINBASE)
+	BlockNumber   uint64         // NUMBER
+	Timestamp     uint64         // TIMESTAMP
+	BlockGasLimit uint64         // GASLIMIT
+	ChainID       *uint256.Int   // CHAINID
+	BaseFee       *uint256.Int   // BASEFEE
+	BlobBaseFee   *uint256.Int   // BLOBBASEFEE
+	PrevRandao    common.Hash    // PREVRANDAO, or DIFFICULTY before the Merge
+}
+
+// DefaultContext returns a mainnet-like context on the latest fork, with
+// DefaultGasLimit gas and all addresses and amounts zero
+func DefaultContext() ExecutionContext {
+	return ExecutionContext{
+		Fork:          LatestFork,
+		GasLimit:      DefaultGasLimit,
+		BlockGasLimit: DefaultGasLimit,
+		ChainID:       uint256.NewInt(1),
+	}
+}
+
+// ExecuteWithContext runs the contract in the given context on an empty
+// world state, with input as its calldata
+func ExecuteWithContext(ctx ExecutionContext, contract Contract, input []byte) ExecutionResult {
+	return NewVMWithContext(ctx).Run(contract, input)
+}
+
+// orZero returns x, or zero if x is nil
+func orZero(x *uint256.Int) *uint256.Int {
+	if x == nil {
+		return new(uint256.Int)
+	}
+	return x
+}
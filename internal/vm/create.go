@@ -0,0 +1,157 @@
+package vm
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+// CreateAddress returns the address of the contract deployed by deployer,
+// with CREATE or a creation transaction, when its nonce is nonce
+func CreateAddress(deployer common.Address, nonce uint64) common.Address {
+	return crypto.CreateAddress(deployer, nonce)
+}
+
+// CreateAddress2 returns the address of the contract deployed by deployer
+// with CREATE2 from initCode and salt (EIP-1014). It does not depend on the
+// deployer's nonce, so it can be known before deployment.
+func CreateAddress2(deployer common.Address, salt [32]byte, initCode []byte) common.Address {
+	return crypto.CreateAddress2(deployer, salt, crypto.Keccak256(initCode))
+}
+
+// Deploy sends a contract creation transaction from caller. It runs initCode
+// with gas as the gas limit and stores the code it returns as a new
+// contract, whose address is returned along with the result of the
+// execution. The state is committed afterwards.
+func (evm *EVM) Deploy(caller common.Address, initCode []byte, gas uint64, value *uint256.Int) (common.Address, ExecutionResult) {
+	value = orZero(value)
+	fork := evm.GasTable.Fork
+	if fork >= Shanghai && len(initCode) > maxInitCodeSize {
+		return common.Address{}, ExecutionResult{Error: ErrMaxInitCodeSizeExceeded}
+	}
+	intrinsicGas, err := IntrinsicGas(initCode, true, fork)
+	if err != nil {
+		return common.Address{}, ExecutionResult{Error: err}
+	}
+	if gas < intrinsicGas {
+		return common.Address{}, ExecutionResult{Error: ErrIntrinsicGas}
+	}
+	if evm.StateDB.GetBalance(caller).Lt(value) {
+		return common.Address{}, ExecutionResult{Error: ErrInsufficientBalance}
+	}
+	defer evm.StateDB.Commit()
+
+	evm.Context.Origin = caller
+	evm.jumpdests = nil
+	address := CreateAddress(caller, evm.StateDB.GetNonce(caller))
+	if evm.Tracer != nil {
+		evm.Tracer.OnTxStart(evm, caller, address, true, initCode, gas, value)
+	}
+	evm.warmAccessList(fork, address)
+
+	ret, leftOverGas, err := evm.create(CREATE, caller, initCode, gas-intrinsicGas, value, address, 0, evm.GasTable)
+	result := newExecutionResult(fork, evm.StateDB, gas, leftOverGas, ret, err)
+	if evm.Tracer != nil {
+		evm.Tracer.OnTxEnd(result)
+	}
+	return address, result
+}
+
+// create runs initCode in a new frame and installs its output as the code
+// of a new contract at address. It bumps the deployer's nonce, even if the
+// creation then fails, and returns the output and unused gas. typ is the
+// CREATE or CREATE2 reported to the tracer.
+func (evm *EVM) create(typ OpCode, caller common.Address, initCode []byte, gas uint64, value *uint256.Int, address common.Address, depth int, gasTable *GasTable) (ret []byte, leftOverGas uint64, err error) {
+	if evm.Tracer != nil {
+		evm.Tracer.OnEnter(depth, typ, caller, address, initCode, gas, value)
+		defer func() {
+			evm.Tracer.OnExit(depth, ret, gas-leftOverGas, err)
+		}()
+	}
+	if depth > callDepthLimit {
+		return nil, gas, ErrDepth
+	}
+	if evm.StateDB.GetBalance(caller).Lt(value) {
+		return nil, gas, ErrInsufficientBalance
+	}
+	nonce := evm.StateDB.GetNonce(caller)
+	if nonce+1 < nonce {
+		return nil, gas, ErrNonceUintOverflow
+	}
+	evm.StateDB.SetNonce(caller, nonce+1)
+
+	fork := gasTable.Fork
+	if fork >= Berlin {
+		evm.StateDB.AddAddressToAccessList(address)
+	}
+	// Deploying over an account with code or a nonce is not allowed
+	codeHash := evm.StateDB.GetCodeHash(address)
+	if evm.StateDB.GetNonce(address) != 0 || (codeHash != (common.Hash{}) && codeHash != emptyCodeHash) {
+		return nil, 0, ErrContractAddressCollision
+	}
+
+	snapshot := evm.StateDB.Snapshot()
+	evm.StateDB.CreateAccount(address)
+	if fork >= SpuriousDragon {
+		// EIP-161: contracts start with nonce 1
+		evm.StateDB.SetNonce(address, 1)
+	}
+	if !value.IsZero() {
+		evm.StateDB.SubBalance(caller, value)
+		evm.StateDB.AddBalance(address, value)
+	}
+
+	frame := evm.newFrame(message{
+		kind:        typ,
+		caller:      caller,
+		address:     address,
+		codeAddress: address,
+		value:       value,
+		gas:         gas,
+		depth:       depth,
+	}, gasTable)
+	frame.Code = initCode
+	frame.jumpdests = evm.jumpdestsFor(initCode)
+	err = frame.interpret()
+	ret = frame.Output
+	if err == nil {
+		err = frame.storeCode(ret)
+	}
+
+	switch {
+	case err == nil:
+		return ret, frame.Gas, nil
+	case errors.Is(err, ErrExecutionReverted):
+		evm.StateDB.RevertToSnapshot(snapshot)
+		return ret, frame.Gas, err
+	default:
+		evm.StateDB.RevertToSnapshot(snapshot)
+		return nil, 0, err
+	}
+}
+
+// storeCode checks the code returned by init code and charges for storing
+// it as the code of the account being created
+func (vm *VM) storeCode(code []byte) error {
+	fork := vm.GasTable.Fork
+	if fork >= SpuriousDragon && len(code) > maxCodeSize {
+		// EIP-170
+		return ErrMaxCodeSizeExceeded
+	}
+	if fork >= London && len(code) > 0 && code[0] == 0xef {
+		// EIP-3541: reserved for the EVM object format
+		return ErrInvalidCode
+	}
+	if err := vm.ConsumeGas(uint64(len(code)) * gasCreateData); err != nil {
+		if fork >= Homestead {
+			return ErrCodeStoreOutOfGas
+		}
+		// Frontier kept the contract, without code, when it could not pay
+		// for it
+		return nil
+	}
+	vm.StateDB.SetCode(vm.Address, code)
+	return nil
+}
@@ -0,0 +1,49 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors that abort execution of a contract
+var (
+	ErrOutOfGas              = errors.New("out of gas")
+	ErrGasUintOverflow       = errors.New("gas uint64 overflow")
+	ErrInvalidOpcode         = errors.New("invalid opcode")
+	ErrStackUnderflow        = errors.New("stack underflow")
+	ErrStackOverflow         = errors.New("stack overflow")
+	ErrInvalidJump           = errors.New("invalid jump destination")
+	ErrExecutionReverted     = errors.New("execution reverted")
+	ErrReturnDataOutOfBounds = errors.New("return data out of bounds")
+	ErrInsufficientBalance   = errors.New("insufficient balance for transfer")
+	ErrDepth                 = errors.New("max call depth exceeded")
+	ErrWriteProtection       = errors.New("write protection")
+
+	ErrContractAddressCollision = errors.New("contract address collision")
+	ErrMaxCodeSizeExceeded      = errors.New("max code size exceeded")
+	ErrMaxInitCodeSizeExceeded  = errors.New("max initcode size exceeded")
+	ErrInvalidCode              = errors.New("invalid code: must not begin with 0xef")
+	ErrCodeStoreOutOfGas        = errors.New("contract creation code storage out of gas")
+	ErrNonceUintOverflow        = errors.New("nonce uint64 overflow")
+)
+
+// Errors that make a transaction invalid before any code runs
+var (
+	ErrIntrinsicGas = errors.New("intrinsic gas too low")
+)
+
+// ExecutionError is the error a frame halts with when one of its
+// instructions fails exceptionally: the error of the instruction and where
+// it happened
+type ExecutionError struct {
+	PC  uint64
+	Err error
+}
+
+func (e *ExecutionError) Error() string {
+	return fmt.Sprintf("execution error at PC=%d: %v", e.PC, e.Err)
+}
+
+func (e *ExecutionError) Unwrap() error {
+	return e.Err
+}
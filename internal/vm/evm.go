@@ -0,0 +1,228 @@
+package vm
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/holiman/uint256"
+)
+
+// callDepthLimit is the deepest a call can be nested below the transaction's
+// own frame
+const callDepthLimit = 1024
+
+// EVM runs calls against a world state that persists between them. The
+// block and transaction fields of Context apply to every call; the call
+// fields (Caller, Address, Value, GasLimit) are taken from each call's
+// arguments instead.
+type EVM struct {
+	Context ExecutionContext
+	StateDB StateDB
+	// Instruction set and gas schedule used for calls made through the EVM
+	GasTable *GasTable
+	// Tracer receiving the events of executions, or nil
+	Tracer Tracer
+
+	// Jumpdest analyses of the code run by the current transaction
+	jumpdests map[common.Hash]jumpdestBitmap
+}
+
+// NewEVM creates an EVM executing in ctx against state
+func NewEVM(ctx ExecutionContext, state StateDB) *EVM {
+	return &EVM{Context: ctx, StateDB: state, GasTable: NewGasTable(ctx.Fork)}
+}
+
+// message describes a call frame
+type message struct {
+	kind        OpCode         // instruction that started the frame, CALL for a transaction
+	caller      common.Address // CALLER of the frame
+	address     common.Address // account whose storage and balance the frame uses
+	codeAddress common.Address // account whose code runs
+	value       *uint256.Int   // CALLVALUE of the frame
+	transfer    bool           // whether value moves from caller to address
+	input       []byte
+	gas         uint64
+	depth       int
+	readOnly    bool
+}
+
+// newFrame creates the VM running a call frame
+func (evm *EVM) newFrame(msg message, gasTable *GasTable) *VM {
+	return &VM{
+		Memory:   NewMemory(),
+		Stack:    make([]uint256.Int, 0, stackLimit),
+		Gas:      msg.gas,
+		StateDB:  evm.StateDB,
+		Context:  &evm.Context,
+		Address:  msg.address,
+		Caller:   msg.caller,
+		Value:    msg.value,
+		Input:    msg.input,
+		GasTable: gasTable,
+		Depth:    msg.depth,
+		ReadOnly: msg.readOnly,
+		evm:      evm,
+	}
+}
+
+// Call sends a transaction from caller to the account at to. It bumps the
+// caller's nonce, transfers value, runs the code of to with input as
+// calldata and gas as the gas limit, and then commits the state. The
+// caller becomes the ORIGIN of the transaction, and the gas used includes
+// the transaction's intrinsic gas.
+func (evm *EVM) Call(caller, to common.Address, input []byte, gas uint64, value *uint256.Int) ExecutionResult {
+	value = orZero(value)
+	intrinsicGas, err := IntrinsicGas(input, false, evm.GasTable.Fork)
+	if err != nil {
+		return ExecutionResult{Error: err}
+	}
+	if gas < intrinsicGas {
+		return ExecutionResult{Error: ErrIntrinsicGas}
+	}
+	if evm.StateDB.GetBalance(caller).Lt(value) {
+		return ExecutionResult{Error: ErrInsufficientBalance}
+	}
+	defer evm.StateDB.Commit()
+
+	evm.Context.Origin = caller
+	evm.jumpdests = nil
+	if evm.Tracer != nil {
+		evm.Tracer.OnTxStart(evm, caller, to, false, input, gas, value)
+	}
+	// The nonce is used up even if the call fails
+	evm.StateDB.SetNonce(caller, evm.StateDB.GetNonce(caller)+1)
+	evm.warmAccessList(evm.GasTable.Fork, to)
+
+	ret, leftOverGas, err := evm.call(message{
+		kind:        CALL,
+		caller:      caller,
+		address:     to,
+		codeAddress: to,
+		value:       value,
+		transfer:    true,
+		input:       input,
+		gas:         gas - intrinsicGas,
+	}, evm.GasTable)
+	result := newExecutionResult(evm.GasTable.Fork, evm.StateDB, gas, leftOverGas, ret, err)
+	if evm.Tracer != nil {
+		evm.Tracer.OnTxEnd(result)
+	}
+	return result
+}
+
+// IntrinsicGas returns the gas a transaction pays before any code runs: a
+// base fee, higher for contract creation since Homestead, a fee per byte of
+// data and, since Shanghai (EIP-3860), a fee per word of init code
+func IntrinsicGas(data []byte, isCreate bool, fork Fork) (uint64, error) {
+	gas := gasTx
+	if isCreate && fork >= Homestead {
+		gas = gasTxContractCreation
+	}
+	nonZeroGas := gasTxDataNonZeroFrontier
+	if fork >= Istanbul {
+		// EIP-2028
+		nonZeroGas = gasTxDataNonZeroEIP2028
+	}
+	var nonZero uint64
+	for _, b := range data {
+		if b != 0 {
+			nonZero++
+		}
+	}
+	zero := uint64(len(data)) - nonZero
+
+	dataGas, overflow := math.SafeMul(nonZero, nonZeroGas)
+	if overflow {
+		return 0, ErrGasUintOverflow
+	}
+	if gas, overflow = math.SafeAdd(gas, dataGas); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	if gas, overflow = math.SafeAdd(gas, zero*gasTxDataZero); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	if isCreate && fork >= Shanghai {
+		if gas, overflow = math.SafeAdd(gas, toWordSize(uint64(len(data)))*gasInitCodeWord); overflow {
+			return 0, ErrGasUintOverflow
+		}
+	}
+	return gas, nil
+}
+
+// warmAccessList marks the accounts every transaction starts with access to:
+// the sender, the called account, the precompiled contracts and, since
+// Shanghai (EIP-3651), the coinbase
+func (evm *EVM) warmAccessList(fork Fork, to common.Address) {
+	evm.StateDB.AddAddressToAccessList(evm.Context.Origin)
+	evm.StateDB.AddAddressToAccessList(to)
+	for _, addr := range ActivePrecompiles(fork) {
+		evm.StateDB.AddAddressToAccessList(addr)
+	}
+	if fork >= Shanghai {
+		evm.StateDB.AddAddressToAccessList(evm.Context.Coinbase)
+	}
+}
+
+// call runs a call frame and returns its output and unused gas. A failed
+// frame leaves no trace in the state; unless it reverted, it also uses up
+// all of its gas.
+func (evm *EVM) call(msg message, gasTable *GasTable) (ret []byte, leftOverGas uint64, err error) {
+	if evm.Tracer != nil {
+		// A delegated call runs on behalf of the account delegating it
+		from := msg.caller
+		if msg.kind == DELEGATECALL {
+			from = msg.address
+		}
+		evm.Tracer.OnEnter(msg.depth, msg.kind, from, msg.codeAddress, msg.input, msg.gas, msg.value)
+		defer func() {
+			evm.Tracer.OnExit(msg.depth, ret, msg.gas-leftOverGas, err)
+		}()
+	}
+	if msg.depth > callDepthLimit {
+		return nil, msg.gas, ErrDepth
+	}
+	if msg.transfer && evm.StateDB.GetBalance(msg.caller).Lt(msg.value) {
+		return nil, msg.gas, ErrInsufficientBalance
+	}
+
+	snapshot := evm.StateDB.Snapshot()
+	if msg.transfer && msg.value.IsZero() && gasTable.Fork < SpuriousDragon && !evm.StateDB.Exist(msg.address) {
+		// Until EIP-158, a call creates the account it is sent to even
+		// when it transfers nothing
+		evm.StateDB.CreateAccount(msg.address)
+	}
+	if msg.transfer && !msg.value.IsZero() {
+		evm.StateDB.SubBalance(msg.caller, msg.value)
+		evm.StateDB.AddBalance(msg.address, msg.value)
+	}
+
+	if p, ok := activePrecompiles(gasTable.Fork)[msg.codeAddress]; ok {
+		ret, gas, err := runPrecompile(p, msg.input, msg.gas)
+		if err != nil {
+			evm.StateDB.RevertToSnapshot(snapshot)
+			return nil, 0, err
+		}
+		return ret, gas, nil
+	}
+
+	code := evm.StateDB.GetCode(msg.codeAddress)
+	if len(code) == 0 {
+		return nil, msg.gas, nil
+	}
+
+	frame := evm.newFrame(msg, gasTable)
+	frame.Code = code
+	frame.jumpdests = evm.jumpdestsFor(code)
+	err = frame.interpret()
+	switch {
+	case err == nil:
+		return frame.Output, frame.Gas, nil
+	case errors.Is(err, ErrExecutionReverted):
+		evm.StateDB.RevertToSnapshot(snapshot)
+		return frame.Output, frame.Gas, err
+	default:
+		evm.StateDB.RevertToSnapshot(snapshot)
+		return nil, 0, err
+	}
+}
@@ -1,7 +1,12 @@
 package vm
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/holiman/uint256"
 )
 
 // Contract represents a compiled Solidity contract
@@ -10,129 +15,241 @@ type Contract struct {
 	ABI      interface{} // This would be more structured in a real implementation
 }
 
-// ExecutionResult contains the result of a VM execution
+// ExecutionResult contains the result of a VM execution. Exactly one of
+// three outcomes applies: success, a REVERT whose payload is in ReturnData,
+// or an exceptional halt (out of gas, invalid jump, ...) described by Error.
+//
+// GasUsed is the gas consumed by execution and GasRefund the part of it paid
+// back for clearing storage, already capped for the active hardfork. Logs
+// holds the events emitted by a successful execution, and Bloom their logs
+// bloom; a failed execution emits none.
 type ExecutionResult struct {
 	Success    bool
+	Reverted   bool
 	ReturnData []byte
 	GasUsed    uint64
+	GasRefund  uint64
+	Logs       []*Log
+	Bloom      Bloom
 	Error      error
 }
 
-// Execute runs the bytecode in the VM
+// Execute runs the bytecode in the VM using the default context
 func Execute(contract Contract, input []byte) ExecutionResult {
-	vm := NewVM()
+	return ExecuteWithContext(DefaultContext(), contract, input)
+}
+
+// Run executes the contract on this VM instance, using its gas table and
+// remaining gas. The frame starts afresh: the program counter, stack,
+// memory, output and return data of a previous run are cleared. Like a
+// transaction, the run ends by committing its changes to the state.
+func (vm *VM) Run(contract Contract, input []byte) ExecutionResult {
+	vm.Code = contract.Bytecode
+	vm.Input = input
+	vm.PC = 0
+	vm.Stack = vm.Stack[:0]
+	vm.Memory = NewMemory()
+	vm.Output = nil
+	vm.ReturnData = nil
+	vm.evm.jumpdests = nil
+	vm.jumpdests = vm.evm.jumpdestsFor(contract.Bytecode)
+	defer vm.StateDB.Commit()
+
+	vm.evm.warmAccessList(vm.GasTable.Fork, vm.Address)
+	snapshot := vm.StateDB.Snapshot()
+
+	tracer := vm.evm.Tracer
+	if tracer != nil {
+		tracer.OnTxStart(vm.evm, vm.Caller, vm.Address, false, input, vm.Gas, vm.Value)
+		tracer.OnEnter(vm.Depth, CALL, vm.Caller, vm.Address, input, vm.Gas, vm.Value)
+	}
+	initialGas := vm.Gas
+	err := vm.interpret()
+	if err != nil {
+		// A failed execution leaves no trace in the state
+		vm.StateDB.RevertToSnapshot(snapshot)
+		if !errors.Is(err, ErrExecutionReverted) {
+			vm.Gas = 0
+		}
+	}
+	result := newExecutionResult(vm.GasTable.Fork, vm.StateDB, initialGas, vm.Gas, vm.Output, err)
+	if tracer != nil {
+		tracer.OnExit(vm.Depth, result.ReturnData, result.GasUsed, err)
+		tracer.OnTxEnd(result)
+	}
+	return result
+}
 
-	// Deploy contract bytecode to memory
-	if err := vm.Store(0, contract.Bytecode); err != nil {
+// newExecutionResult describes how an execution given gasLimit gas ended,
+// with leftOverGas unused, output returned and err as the error it stopped
+// with. The refund and logs are read from state before it is committed.
+func newExecutionResult(fork Fork, state StateDB, gasLimit, leftOverGas uint64, output []byte, err error) ExecutionResult {
+	gasUsed := gasLimit - leftOverGas
+	switch {
+	case err == nil:
+		logs := state.Logs()
 		return ExecutionResult{
-			Success: false,
-			Error:   fmt.Errorf("failed to load bytecode: %w", err),
+			Success:    true,
+			ReturnData: output,
+			GasUsed:    gasUsed,
+			GasRefund:  maxRefund(fork, gasUsed, state.GetRefund()),
+			Logs:       logs,
+			Bloom:      LogsBloom(logs),
+		}
+	case errors.Is(err, ErrExecutionReverted):
+		// A revert refunds the remaining gas and keeps its payload
+		return ExecutionResult{
+			Reverted:   true,
+			ReturnData: output,
+			GasUsed:    gasUsed,
+			Error:      err,
+		}
+	default:
+		// An exceptional halt consumes all gas given to the execution
+		return ExecutionResult{
+			GasUsed: gasLimit,
+			Error:   err,
 		}
 	}
+}
 
-	// Execute until STOP or error
-	initialGas := vm.Gas
-	for vm.PC < uint64(len(contract.Bytecode)) {
-		// Consume gas for each instruction
-		if err := vm.ConsumeGas(1); err != nil {
-			return ExecutionResult{
-				Success: false,
-				GasUsed: initialGas - vm.Gas,
-				Error:   err,
+// interpret runs the fetch-execute loop until STOP, RETURN, REVERT, the end
+// of the code or an error
+func (vm *VM) interpret() error {
+	for vm.PC < uint64(len(vm.Code)) {
+		pc, gas := vm.PC, vm.Gas
+		halt, err := vm.step()
+		if err != nil {
+			if errors.Is(err, ErrExecutionReverted) {
+				return err
 			}
+			if tracer := vm.evm.Tracer; tracer != nil {
+				tracer.OnFault(pc, OpCode(vm.Code[pc]), gas, gas-vm.Gas, vm, err)
+			}
+			return &ExecutionError{PC: pc, Err: err}
+		}
+		if halt {
+			return nil
 		}
+	}
+	return nil
+}
 
-		// Fetch opcode
-		opcode := OpCode(contract.Bytecode[vm.PC])
-		vm.PC++
-
-		// Get operand if needed
-		var operand []byte
-		if opcode >= PUSH1 && opcode <= PUSH32 {
-			size := int(opcode - PUSH1 + 1)
-			if vm.PC+uint64(size) > uint64(len(contract.Bytecode)) {
-				return ExecutionResult{
-					Success: false,
-					GasUsed: initialGas - vm.Gas,
-					Error:   fmt.Errorf("unexpected end of bytecode"),
-				}
-			}
+// step executes the instruction at PC: it checks the stack, charges static
+// and dynamic gas, reports the instruction to the tracer, expands memory and
+// then runs the opcode. It reports whether the instruction ended the
+// execution successfully.
+func (vm *VM) step() (bool, error) {
+	gas := vm.Gas
+	opcode := OpCode(vm.Code[vm.PC])
+	operation := vm.GasTable.ops[opcode]
+	if operation == nil {
+		return false, fmt.Errorf("%w: 0x%x", ErrInvalidOpcode, byte(opcode))
+	}
 
-			operand = contract.Bytecode[vm.PC : vm.PC+uint64(size)]
-			vm.PC += uint64(size)
-		}
+	if len(vm.Stack) < operation.minStack {
+		return false, ErrStackUnderflow
+	}
+	if len(vm.Stack) > operation.maxStack {
+		return false, ErrStackOverflow
+	}
 
-		// Execute the opcode
-		if err := ExecuteOpcode(vm, opcode, operand); err != nil {
-			return ExecutionResult{
-				Success: false,
-				GasUsed: initialGas - vm.Gas,
-				Error:   fmt.Errorf("execution error at PC=%d: %w", vm.PC-1, err),
-			}
-		}
+	if err := vm.ConsumeGas(operation.constantGas); err != nil {
+		return false, err
+	}
 
-		// If the opcode was STOP, break the loop
-		if opcode == STOP {
-			break
+	var memorySize uint64
+	if operation.memorySize != nil {
+		size, overflow := operation.memorySize(vm)
+		if overflow {
+			return false, ErrGasUintOverflow
+		}
+		// Memory grows in whole words
+		if memorySize, overflow = math.SafeMul(toWordSize(size), 32); overflow {
+			return false, ErrGasUintOverflow
 		}
 	}
 
-	// Return any data left on the stack as the result
-	var returnData []byte
-	if len(vm.Stack) > 0 {
-		value := vm.Stack[len(vm.Stack)-1]
-		returnData = make([]byte, 8)
-		for i := 0; i < 8; i++ {
-			returnData[7-i] = byte(value >> (8 * i))
+	if operation.dynamicGas != nil {
+		cost, err := operation.dynamicGas(vm, memorySize)
+		if err != nil {
+			return false, err
+		}
+		if err := vm.ConsumeGas(cost); err != nil {
+			return false, err
 		}
 	}
 
-	return ExecutionResult{
-		Success:    true,
-		ReturnData: returnData,
-		GasUsed:    initialGas - vm.Gas,
+	if tracer := vm.evm.Tracer; tracer != nil {
+		tracer.OnOpcode(vm.PC, opcode, gas, gas-vm.Gas, vm)
 	}
-}
 
-type Executor struct {
-	// Define fields for the execution context, such as the stack, memory, and program counter
-	stack          []interface{}
-	memory         []byte
-	programCounter int
-}
+	if memorySize > 0 {
+		vm.Memory.Resize(memorySize)
+	}
+
+	vm.PC++
 
-// NewExecutor initializes a new Executor instance
-func NewExecutor() *Executor {
-	return &Executor{
-		stack:          make([]interface{}, 0),
-		memory:         make([]byte, 1024), // Example memory size
-		programCounter: 0,
+	// Get operand if needed. Push data running past the end of the
+	// code is padded with zeros, as if the code were followed by STOPs.
+	var operand []byte
+	if opcode.IsPush() {
+		size := uint64(opcode - PUSH1 + 1)
+		operand = make([]byte, size)
+		if vm.PC < uint64(len(vm.Code)) {
+			copy(operand, vm.Code[vm.PC:])
+		}
+		vm.PC += size
 	}
-}
 
-// Execute runs the bytecode in the virtual machine
-func (e *Executor) Execute(bytecode []byte) error {
-	// Implementation of bytecode execution logic
-	for e.programCounter < len(bytecode) {
-		opcode := bytecode[e.programCounter]
-		fmt.Printf("Executing opcode: %x\n", opcode)
-		// Handle the opcode execution
-		e.programCounter++
+	if err := ExecuteOpcode(vm, opcode, operand); err != nil {
+		return false, err
 	}
-	return nil
-}
 
-// Push adds a value to the stack
-func (e *Executor) Push(value interface{}) {
-	e.stack = append(e.stack, value)
+	// STOP, RETURN and SELFDESTRUCT end the execution successfully
+	return opcode == STOP || opcode == RETURN || opcode == SELFDESTRUCT, nil
 }
 
-// Pop removes and returns the top value from the stack
-func (e *Executor) Pop() interface{} {
-	if len(e.stack) == 0 {
-		return nil // or handle underflow error
+// RevertReason decodes the message carried by a reverted execution's payload
+// when it was produced by require/revert with a string (Error(string)) or by
+// a Solidity panic (Panic(uint256)). Custom errors are left to the caller,
+// who can inspect ReturnData directly.
+func (r ExecutionResult) RevertReason() (string, bool) {
+	if !r.Reverted || len(r.ReturnData) < 4 {
+		return "", false
 	}
-	value := e.stack[len(e.stack)-1]
-	e.stack = e.stack[:len(e.stack)-1]
-	return value
+	selector, data := r.ReturnData[:4], r.ReturnData[4:]
+	switch {
+	case bytes.Equal(selector, errorSelector):
+		// ABI encoding: offset word, length word, then the string bytes
+		if len(data) < 64 {
+			return "", false
+		}
+		var offset, length uint256.Int
+		offset.SetBytes(data[:32])
+		if !offset.IsUint64() || offset.Uint64()+32 > uint64(len(data)) {
+			return "", false
+		}
+		length.SetBytes(data[offset.Uint64() : offset.Uint64()+32])
+		start := offset.Uint64() + 32
+		if !length.IsUint64() || start+length.Uint64() > uint64(len(data)) {
+			return "", false
+		}
+		return string(data[start : start+length.Uint64()]), true
+	case bytes.Equal(selector, panicSelector):
+		if len(data) < 32 {
+			return "", false
+		}
+		var code uint256.Int
+		code.SetBytes(data[:32])
+		return "panic: " + code.Hex(), true
+	}
+	return "", false
 }
+
+var (
+	// errorSelector is the selector of Error(string)
+	errorSelector = []byte{0x08, 0xc3, 0x79, 0xa0}
+	// panicSelector is the selector of Panic(uint256)
+	panicSelector = []byte{0x4e, 0x48, 0x7b, 0x71}
+)
@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fork identifies an Ethereum hardfork. Forks are ordered, so later forks
+// compare greater than earlier ones and include all of their rule changes.
+type Fork int
+
+// Hardforks that changed EVM behavior, in activation order
+const (
+	Frontier         Fork = iota
+	Homestead             // EIP-7: DELEGATECALL
+	TangerineWhistle      // EIP-150: IO-heavy repricing, 63/64 call gas rule
+	SpuriousDragon        // EIP-160: EXP repricing, EIP-170: code size limit
+	Byzantium             // REVERT, RETURNDATA*, STATICCALL
+	Constantinople        // SHL/SHR/SAR, CREATE2, EXTCODEHASH, EIP-1283
+	Petersburg            // Constantinople without EIP-1283
+	Istanbul              // CHAINID, SELFBALANCE, EIP-1884, EIP-2200
+	Berlin                // EIP-2929: warm/cold state access
+	London                // BASEFEE, EIP-3529: reduced refunds
+	Paris                 // The Merge: PREVRANDAO replaces DIFFICULTY
+	Shanghai              // PUSH0, EIP-3860: initcode limit
+	Cancun                // TLOAD/TSTORE, MCOPY, blobs, EIP-6780
+
+	// LatestFork is the newest supported hardfork
+	LatestFork = Cancun
+)
+
+var forkNames = []string{
+	Frontier:         "Frontier",
+	Homestead:        "Homestead",
+	TangerineWhistle: "TangerineWhistle",
+	SpuriousDragon:   "SpuriousDragon",
+	Byzantium:        "Byzantium",
+	Constantinople:   "Constantinople",
+	Petersburg:       "Petersburg",
+	Istanbul:         "Istanbul",
+	Berlin:           "Berlin",
+	London:           "London",
+	Paris:            "Paris",
+	Shanghai:         "Shanghai",
+	Cancun:           "Cancun",
+}
+
+// String returns the fork name
+func (f Fork) String() string {
+	if f < 0 || int(f) >= len(forkNames) {
+		return fmt.Sprintf("Fork(%d)", int(f))
+	}
+	return forkNames[f]
+}
+
+// ParseFork looks up a fork by name, ignoring case. "Merge" is accepted as
+// an alias for Paris.
+func ParseFork(name string) (Fork, error) {
+	if strings.EqualFold(name, "Merge") {
+		return Paris, nil
+	}
+	for fork, forkName := range forkNames {
+		if strings.EqualFold(name, forkName) {
+			return Fork(fork), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown fork: %q", name)
+}
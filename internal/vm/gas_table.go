@@ -0,0 +1,862 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/holiman/uint256"
+)
+
+// Gas costs, named after the tiers of the yellow paper where they have one
+const (
+	gasZero        uint64 = 0
+	gasJumpdest    uint64 = 1
+	gasQuickStep   uint64 = 2
+	gasFastestStep uint64 = 3
+	gasFastStep    uint64 = 5
+	gasMidStep     uint64 = 8
+	gasSlowStep    uint64 = 10
+
+	gasCopyWord uint64 = 3 // per 32-byte word copied
+
+	gasKeccak     uint64 = 30
+	gasKeccakWord uint64 = 6 // per word hashed
+
+	gasExp              uint64 = 10
+	gasExpByteFrontier  uint64 = 10
+	gasExpByteEIP160    uint64 = 50
+	gasSloadFrontier    uint64 = 50
+	gasSloadEIP150      uint64 = 200
+	gasSloadEIP1884     uint64 = 800
+	gasSstoreSet        uint64 = 20000
+	gasSstoreReset      uint64 = 5000
+	gasColdSloadEIP2929 uint64 = 2100
+	gasWarmReadEIP2929  uint64 = 100
+
+	// Reading another account
+	gasBalanceFrontier           uint64 = 20
+	gasBalanceEIP150             uint64 = 400
+	gasBalanceEIP1884            uint64 = 700
+	gasExtcodeFrontier           uint64 = 20
+	gasExtcodeEIP150             uint64 = 700
+	gasExtcodeHashConstantinople uint64 = 400
+	gasExtcodeHashEIP1884        uint64 = 700
+	gasColdAccountAccessEIP2929  uint64 = 2600
+
+	// Message calls
+	gasCallFrontier      uint64 = 40
+	gasCallEIP150        uint64 = 700
+	gasCallValueTransfer uint64 = 9000  // when the call sends wei
+	gasCallNewAccount    uint64 = 25000 // when the call creates the callee
+	gasCallStipend       uint64 = 2300  // free gas given along with wei
+
+	// Contract creation
+	gasCreate       uint64 = 32000
+	gasCreateData   uint64 = 200 // per byte of deployed code
+	gasInitCodeWord uint64 = 2   // per word of init code, EIP-3860
+	maxCodeSize            = 24576
+	maxInitCodeSize        = 2 * maxCodeSize
+
+	// Self-destruction
+	gasSelfdestructEIP150 uint64 = 5000
+	refundSelfdestruct    uint64 = 24000 // until London (EIP-3529)
+
+	// Event logs
+	gasLog      uint64 = 375
+	gasLogTopic uint64 = 375
+	gasLogData  uint64 = 8 // per byte of data
+
+	// Precompiled contracts
+	gasEcrecover                     uint64 = 3000
+	gasSha256Base                    uint64 = 60
+	gasSha256Word                    uint64 = 12
+	gasRipemd160Base                 uint64 = 600
+	gasRipemd160Word                 uint64 = 120
+	gasIdentityBase                  uint64 = 15
+	gasIdentityWord                  uint64 = 3
+	gasModExpMinEIP2565              uint64 = 200
+	gasBn256AddByzantium             uint64 = 500
+	gasBn256AddIstanbul              uint64 = 150
+	gasBn256ScalarMulByzantium       uint64 = 40000
+	gasBn256ScalarMulIstanbul        uint64 = 6000
+	gasBn256PairingBaseByzantium     uint64 = 100000
+	gasBn256PairingBaseIstanbul      uint64 = 45000
+	gasBn256PairingPerPointByzantium uint64 = 80000
+	gasBn256PairingPerPointIstanbul  uint64 = 34000
+	gasPointEvaluation               uint64 = 50000
+
+	// Intrinsic gas of transactions
+	gasTx                    uint64 = 21000
+	gasTxContractCreation    uint64 = 53000
+	gasTxDataZero            uint64 = 4
+	gasTxDataNonZeroFrontier uint64 = 68
+	gasTxDataNonZeroEIP2028  uint64 = 16
+
+	// SSTORE net gas metering
+	gasNetSstoreNoopEIP1283   uint64 = 200
+	gasSloadEIP2200           uint64 = 800
+	gasSstoreSentryEIP2200    uint64 = 2300
+	refundSstoreClears        uint64 = 15000
+	refundSstoreClearsEIP3529 uint64 = gasSstoreReset - gasColdSloadEIP2929 + 1900
+
+	// Maximum share of the gas used that refunds can pay back
+	refundQuotient        uint64 = 2
+	refundQuotientEIP3529 uint64 = 5
+)
+
+// stackLimit is the maximum number of items on the stack
+const stackLimit = 1024
+
+// gasFunc computes the dynamic part of an instruction's cost from the VM
+// state before the instruction runs. memorySize is the memory size the
+// instruction needs, already rounded up to whole words.
+type gasFunc func(vm *VM, memorySize uint64) (uint64, error)
+
+// memorySizeFunc returns the number of bytes of memory an instruction
+// touches, and whether computing it overflowed
+type memorySizeFunc func(vm *VM) (uint64, bool)
+
+// operation describes the static properties of an instruction
+type operation struct {
+	constantGas uint64
+	dynamicGas  gasFunc
+	memorySize  memorySizeFunc
+
+	// minStack is the number of items the instruction pops and maxStack the
+	// deepest stack it can run on without overflowing
+	minStack int
+	maxStack int
+}
+
+// GasTable lists the instructions defined in a hardfork and their gas costs.
+// Instructions missing from the table are invalid in that fork. Tables
+// returned by NewGasTable can be adjusted to model custom chains.
+type GasTable struct {
+	Fork Fork
+	ops  [256]*operation
+}
+
+// NewGasTable builds the instruction set and gas schedule of a hardfork
+func NewGasTable(fork Fork) *GasTable {
+	t := &GasTable{Fork: fork}
+	t.defineFrontier()
+
+	if fork >= Homestead {
+		// EIP-7: DELEGATECALL
+		t.ops[DELEGATECALL] = &operation{constantGas: gasCallFrontier, dynamicGas: gasDelegateOrStaticCall, memorySize: memoryDelegateOrStaticCall, minStack: 6, maxStack: maxStack(6, 1)}
+	}
+	if fork >= TangerineWhistle {
+		// EIP-150: reprice IO-heavy operations
+		t.ops[SLOAD].constantGas = gasSloadEIP150
+		t.ops[BALANCE].constantGas = gasBalanceEIP150
+		t.ops[EXTCODESIZE].constantGas = gasExtcodeEIP150
+		t.ops[EXTCODECOPY].constantGas = gasExtcodeEIP150
+		t.ops[CALL].constantGas = gasCallEIP150
+		t.ops[CALLCODE].constantGas = gasCallEIP150
+		t.ops[DELEGATECALL].constantGas = gasCallEIP150
+		t.ops[SELFDESTRUCT].constantGas = gasSelfdestructEIP150
+	}
+	if fork >= SpuriousDragon {
+		// EIP-160: reprice EXP
+		t.ops[EXP].dynamicGas = makeGasExp(gasExpByteEIP160)
+	}
+	if fork >= Byzantium {
+		t.ops[RETURNDATASIZE] = &operation{constantGas: gasQuickStep, minStack: 0, maxStack: maxStack(0, 1)}
+		t.ops[RETURNDATACOPY] = &operation{constantGas: gasFastestStep, dynamicGas: makeGasCopy(2), memorySize: memoryReturnDataCopy, minStack: 3, maxStack: maxStack(3, 0)}
+		t.ops[REVERT] = &operation{constantGas: gasZero, dynamicGas: gasMemoryOnly, memorySize: memoryReturn, minStack: 2, maxStack: maxStack(2, 0)}
+		// EIP-214: STATICCALL
+		t.ops[STATICCALL] = &operation{constantGas: gasCallEIP150, dynamicGas: gasDelegateOrStaticCall, memorySize: memoryDelegateOrStaticCall, minStack: 6, maxStack: maxStack(6, 1)}
+	}
+	if fork >= Constantinople {
+		// EIP-145: bitwise shifts
+		t.ops[SHL] = &operation{constantGas: gasFastestStep, minStack: 2, maxStack: maxStack(2, 1)}
+		t.ops[SHR] = &operation{constantGas: gasFastestStep, minStack: 2, maxStack: maxStack(2, 1)}
+		t.ops[SAR] = &operation{constantGas: gasFastestStep, minStack: 2, maxStack: maxStack(2, 1)}
+		// EIP-1014: CREATE2
+		t.ops[CREATE2] = &operation{constantGas: gasCreate, dynamicGas: makeGasCreate(gasKeccakWord, false), memorySize: memoryCreate, minStack: 4, maxStack: maxStack(4, 1)}
+		// EIP-1052: EXTCODEHASH
+		t.ops[EXTCODEHASH] = &operation{constantGas: gasExtcodeHashConstantinople, minStack: 1, maxStack: maxStack(1, 1)}
+	}
+	if fork == Constantinople {
+		// EIP-1283: net gas metering, withdrawn again by Petersburg
+		t.ops[SSTORE].dynamicGas = makeGasSStoreNetMetering(gasNetSstoreNoopEIP1283, false)
+	}
+	if fork >= Istanbul {
+		// EIP-1884: reprice trie-size-dependent opcodes
+		t.ops[SLOAD].constantGas = gasSloadEIP1884
+		t.ops[BALANCE].constantGas = gasBalanceEIP1884
+		t.ops[EXTCODEHASH].constantGas = gasExtcodeHashEIP1884
+		// EIP-2200: net gas metering with a reentrancy sentry
+		t.ops[SSTORE].dynamicGas = makeGasSStoreNetMetering(gasSloadEIP2200, true)
+		// EIP-1344: CHAINID, and EIP-1884: SELFBALANCE
+		t.ops[CHAINID] = &operation{constantGas: gasQuickStep, minStack: 0, maxStack: maxStack(0, 1)}
+		t.ops[SELFBALANCE] = &operation{constantGas: gasFastStep, minStack: 0, maxStack: maxStack(0, 1)}
+	}
+	if fork >= Berlin {
+		// EIP-2929: storage reads are priced by warm/cold access
+		t.ops[SLOAD].constantGas = gasZero
+		t.ops[SLOAD].dynamicGas = gasSLoadEIP2929
+		t.ops[SSTORE].dynamicGas = makeGasSStoreEIP2929(refundSstoreClears)
+		// and so are reads of other accounts: a warm read is charged up
+		// front, the cold surcharge dynamically
+		for _, op := range []OpCode{BALANCE, EXTCODESIZE, EXTCODEHASH, EXTCODECOPY} {
+			t.ops[op].constantGas = gasWarmReadEIP2929
+			t.ops[op].dynamicGas = makeGasAccountEIP2929(t.ops[op].dynamicGas)
+		}
+		for _, op := range []OpCode{CALL, CALLCODE, DELEGATECALL, STATICCALL} {
+			t.ops[op].constantGas = gasWarmReadEIP2929
+			t.ops[op].dynamicGas = makeGasCallEIP2929(t.ops[op].dynamicGas)
+		}
+	}
+	if fork >= London {
+		// EIP-3529: smaller refund for clearing storage
+		t.ops[SSTORE].dynamicGas = makeGasSStoreEIP2929(refundSstoreClearsEIP3529)
+		// EIP-3198: BASEFEE
+		t.ops[BASEFEE] = &operation{constantGas: gasQuickStep, minStack: 0, maxStack: maxStack(0, 1)}
+	}
+	if fork >= Shanghai {
+		// EIP-3860: limit and meter init code
+		t.ops[CREATE].dynamicGas = makeGasCreate(gasInitCodeWord, true)
+		t.ops[CREATE2].dynamicGas = makeGasCreate(gasKeccakWord+gasInitCodeWord, true)
+		// EIP-3855: PUSH0
+		t.ops[PUSH0] = &operation{constantGas: gasQuickStep, minStack: 0, maxStack: maxStack(0, 1)}
+	}
+	if fork >= Cancun {
+		// EIP-1153: transient storage, priced as a warm storage access
+		t.ops[TLOAD] = &operation{constantGas: gasWarmReadEIP2929, minStack: 1, maxStack: maxStack(1, 1)}
+		t.ops[TSTORE] = &operation{constantGas: gasWarmReadEIP2929, minStack: 2, maxStack: maxStack(2, 0)}
+		// EIP-5656: MCOPY
+		t.ops[MCOPY] = &operation{constantGas: gasFastestStep, dynamicGas: makeGasCopy(2), memorySize: memoryMcopy, minStack: 3, maxStack: maxStack(3, 0)}
+		// EIP-7516: BLOBBASEFEE
+		t.ops[BLOBBASEFEE] = &operation{constantGas: gasQuickStep, minStack: 0, maxStack: maxStack(0, 1)}
+	}
+	return t
+}
+
+// defineFrontier fills the table with the original instruction set
+func (t *GasTable) defineFrontier() {
+	simple := func(op OpCode, gas uint64, pops, pushes int) {
+		t.ops[op] = &operation{constantGas: gas, minStack: pops, maxStack: maxStack(pops, pushes)}
+	}
+
+	simple(STOP, gasZero, 0, 0)
+	simple(ADD, gasFastestStep, 2, 1)
+	simple(MUL, gasFastStep, 2, 1)
+	simple(SUB, gasFastestStep, 2, 1)
+	simple(DIV, gasFastStep, 2, 1)
+	simple(SDIV, gasFastStep, 2, 1)
+	simple(MOD, gasFastStep, 2, 1)
+	simple(SMOD, gasFastStep, 2, 1)
+	simple(ADDMOD, gasMidStep, 3, 1)
+	simple(MULMOD, gasMidStep, 3, 1)
+	t.ops[EXP] = &operation{constantGas: gasExp, dynamicGas: makeGasExp(gasExpByteFrontier), minStack: 2, maxStack: maxStack(2, 1)}
+	simple(SIGNEXTEND, gasFastStep, 2, 1)
+
+	simple(LT, gasFastestStep, 2, 1)
+	simple(GT, gasFastestStep, 2, 1)
+	simple(SLT, gasFastestStep, 2, 1)
+	simple(SGT, gasFastestStep, 2, 1)
+	simple(EQ, gasFastestStep, 2, 1)
+	simple(ISZERO, gasFastestStep, 1, 1)
+	simple(AND, gasFastestStep, 2, 1)
+	simple(OR, gasFastestStep, 2, 1)
+	simple(XOR, gasFastestStep, 2, 1)
+	simple(NOT, gasFastestStep, 1, 1)
+	simple(BYTE, gasFastestStep, 2, 1)
+
+	t.ops[SHA3] = &operation{constantGas: gasKeccak, dynamicGas: gasSha3, memorySize: memorySha3, minStack: 2, maxStack: maxStack(2, 1)}
+
+	simple(ADDRESS, gasQuickStep, 0, 1)
+	simple(BALANCE, gasBalanceFrontier, 1, 1)
+	simple(ORIGIN, gasQuickStep, 0, 1)
+	simple(CALLER, gasQuickStep, 0, 1)
+	simple(CALLVALUE, gasQuickStep, 0, 1)
+	simple(CALLDATALOAD, gasFastestStep, 1, 1)
+	simple(CALLDATASIZE, gasQuickStep, 0, 1)
+	t.ops[CALLDATACOPY] = &operation{constantGas: gasFastestStep, dynamicGas: makeGasCopy(2), memorySize: memoryCallDataCopy, minStack: 3, maxStack: maxStack(3, 0)}
+	simple(CODESIZE, gasQuickStep, 0, 1)
+	t.ops[CODECOPY] = &operation{constantGas: gasFastestStep, dynamicGas: makeGasCopy(2), memorySize: memoryCodeCopy, minStack: 3, maxStack: maxStack(3, 0)}
+	simple(GASPRICE, gasQuickStep, 0, 1)
+	simple(EXTCODESIZE, gasExtcodeFrontier, 1, 1)
+	t.ops[EXTCODECOPY] = &operation{constantGas: gasExtcodeFrontier, dynamicGas: makeGasCopy(3), memorySize: memoryExtCodeCopy, minStack: 4, maxStack: maxStack(4, 0)}
+
+	simple(COINBASE, gasQuickStep, 0, 1)
+	simple(TIMESTAMP, gasQuickStep, 0, 1)
+	simple(NUMBER, gasQuickStep, 0, 1)
+	simple(DIFFICULTY, gasQuickStep, 0, 1)
+	simple(GASLIMIT, gasQuickStep, 0, 1)
+
+	simple(POP, gasQuickStep, 1, 0)
+	t.ops[MLOAD] = &operation{constantGas: gasFastestStep, dynamicGas: gasMemoryOnly, memorySize: memoryMload, minStack: 1, maxStack: maxStack(1, 1)}
+	t.ops[MSTORE] = &operation{constantGas: gasFastestStep, dynamicGas: gasMemoryOnly, memorySize: memoryMstore, minStack: 2, maxStack: maxStack(2, 0)}
+	t.ops[MSTORE8] = &operation{constantGas: gasFastestStep, dynamicGas: gasMemoryOnly, memorySize: memoryMstore8, minStack: 2, maxStack: maxStack(2, 0)}
+	simple(SLOAD, gasSloadFrontier, 1, 1)
+	t.ops[SSTORE] = &operation{constantGas: gasZero, dynamicGas: gasSStoreLegacy, minStack: 2, maxStack: maxStack(2, 0)}
+	simple(JUMP, gasMidStep, 1, 0)
+	simple(JUMPI, gasSlowStep, 2, 0)
+	simple(PC, gasQuickStep, 0, 1)
+	simple(MSIZE, gasQuickStep, 0, 1)
+	simple(GAS, gasQuickStep, 0, 1)
+	simple(JUMPDEST, gasJumpdest, 0, 0)
+
+	for op := PUSH1; op <= PUSH32; op++ {
+		simple(op, gasFastestStep, 0, 1)
+	}
+	for n := 1; n <= 16; n++ {
+		simple(DUP1+OpCode(n-1), gasFastestStep, n, n+1)
+		simple(SWAP1+OpCode(n-1), gasFastestStep, n+1, n+1)
+	}
+
+	for n := 0; n <= 4; n++ {
+		t.ops[LOG0+OpCode(n)] = &operation{constantGas: gasLog + uint64(n)*gasLogTopic, dynamicGas: gasLogDataBytes, memorySize: memoryLog, minStack: 2 + n, maxStack: maxStack(2+n, 0)}
+	}
+
+	t.ops[CREATE] = &operation{constantGas: gasCreate, dynamicGas: gasMemoryOnly, memorySize: memoryCreate, minStack: 3, maxStack: maxStack(3, 1)}
+	t.ops[CALL] = &operation{constantGas: gasCallFrontier, dynamicGas: gasCall, memorySize: memoryCall, minStack: 7, maxStack: maxStack(7, 1)}
+	t.ops[CALLCODE] = &operation{constantGas: gasCallFrontier, dynamicGas: gasCallCode, memorySize: memoryCall, minStack: 7, maxStack: maxStack(7, 1)}
+	t.ops[RETURN] = &operation{constantGas: gasZero, dynamicGas: gasMemoryOnly, memorySize: memoryReturn, minStack: 2, maxStack: maxStack(2, 0)}
+	t.ops[SELFDESTRUCT] = &operation{constantGas: gasZero, dynamicGas: gasSelfdestruct, minStack: 1, maxStack: maxStack(1, 0)}
+}
+
+// maxStack is the deepest stack an instruction with the given pops and
+// pushes can run on
+func maxStack(pops, pushes int) int {
+	return stackLimit + pops - pushes
+}
+
+// Copy returns an independent copy of the table
+func (t *GasTable) Copy() *GasTable {
+	cpy := &GasTable{Fork: t.Fork}
+	for i, op := range t.ops {
+		if op != nil {
+			opCopy := *op
+			cpy.ops[i] = &opCopy
+		}
+	}
+	return cpy
+}
+
+// IsDefined reports whether op is a valid instruction in this table
+func (t *GasTable) IsDefined(op OpCode) bool {
+	return t.ops[op] != nil
+}
+
+// ConstantGas returns the static cost of op, charged before any dynamic
+// component
+func (t *GasTable) ConstantGas(op OpCode) uint64 {
+	if t.ops[op] == nil {
+		return 0
+	}
+	return t.ops[op].constantGas
+}
+
+// SetConstantGas overrides the static cost of an instruction defined in the
+// table
+func (t *GasTable) SetConstantGas(op OpCode, gas uint64) {
+	if t.ops[op] != nil {
+		t.ops[op].constantGas = gas
+	}
+}
+
+// memoryGas returns the cost of growing memory to newSize bytes, which is
+// the difference between the quadratic cost of the new and the current size
+func memoryGas(vm *VM, newSize uint64) (uint64, error) {
+	if newSize == 0 || newSize <= uint64(vm.Memory.Len()) {
+		return 0, nil
+	}
+	if newSize > maxMemorySize {
+		return 0, ErrGasUintOverflow
+	}
+	cost := memoryGasCost(newSize / 32)
+	fee := cost - vm.Memory.gasCost
+	vm.Memory.gasCost = cost
+	return fee, nil
+}
+
+// gasMemoryOnly charges for memory expansion and nothing else
+func gasMemoryOnly(vm *VM, memorySize uint64) (uint64, error) {
+	return memoryGas(vm, memorySize)
+}
+
+// makeGasExp prices EXP by the byte length of the exponent
+func makeGasExp(byteCost uint64) gasFunc {
+	return func(vm *VM, memorySize uint64) (uint64, error) {
+		exponentBytes := uint64((vm.back(1).BitLen() + 7) / 8)
+		gas, overflow := math.SafeMul(exponentBytes, byteCost)
+		if overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return gas, nil
+	}
+}
+
+// makeGasCopy prices copy instructions per word copied, with the length at
+// the given stack position, plus memory expansion
+func makeGasCopy(lengthPos int) gasFunc {
+	return func(vm *VM, memorySize uint64) (uint64, error) {
+		gas, err := memoryGas(vm, memorySize)
+		if err != nil {
+			return 0, err
+		}
+		length := vm.back(lengthPos)
+		if !length.IsUint64() {
+			return 0, ErrGasUintOverflow
+		}
+		words, overflow := math.SafeMul(toWordSize(length.Uint64()), gasCopyWord)
+		if overflow {
+			return 0, ErrGasUintOverflow
+		}
+		if gas, overflow = math.SafeAdd(gas, words); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return gas, nil
+	}
+}
+
+// gasSLoadEIP2929 charges a cold or warm storage read
+func gasSLoadEIP2929(vm *VM, memorySize uint64) (uint64, error) {
+	slot := common.Hash(vm.back(0).Bytes32())
+	if _, slotWarm := vm.StateDB.SlotInAccessList(vm.Address, slot); slotWarm {
+		return gasWarmReadEIP2929, nil
+	}
+	vm.StateDB.AddSlotToAccessList(vm.Address, slot)
+	return gasColdSloadEIP2929, nil
+}
+
+// makeGasAccountEIP2929 adds the cold access surcharge for the account on
+// top of the stack to inner, the instruction's other dynamic cost if any
+func makeGasAccountEIP2929(inner gasFunc) gasFunc {
+	return func(vm *VM, memorySize uint64) (uint64, error) {
+		var gas uint64
+		addr := common.Address(vm.back(0).Bytes20())
+		if !vm.StateDB.AddressInAccessList(addr) {
+			vm.StateDB.AddAddressToAccessList(addr)
+			gas = gasColdAccountAccessEIP2929 - gasWarmReadEIP2929
+		}
+		if inner == nil {
+			return gas, nil
+		}
+		innerGas, err := inner(vm, memorySize)
+		if err != nil {
+			return 0, err
+		}
+		total, overflow := math.SafeAdd(gas, innerGas)
+		if overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return total, nil
+	}
+}
+
+// makeGasCreate prices CREATE and CREATE2 per word of init code, which
+// CREATE2 hashes to derive the address, plus memory expansion. With
+// limitInitCode, init code longer than maxInitCodeSize is an error.
+func makeGasCreate(wordGas uint64, limitInitCode bool) gasFunc {
+	return func(vm *VM, memorySize uint64) (uint64, error) {
+		gas, err := memoryGas(vm, memorySize)
+		if err != nil {
+			return 0, err
+		}
+		size := vm.back(2)
+		if !size.IsUint64() {
+			return 0, ErrGasUintOverflow
+		}
+		if limitInitCode && size.Uint64() > maxInitCodeSize {
+			return 0, fmt.Errorf("%w: size %d", ErrMaxInitCodeSizeExceeded, size.Uint64())
+		}
+		words, overflow := math.SafeMul(toWordSize(size.Uint64()), wordGas)
+		if overflow {
+			return 0, ErrGasUintOverflow
+		}
+		if gas, overflow = math.SafeAdd(gas, words); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return gas, nil
+	}
+}
+
+// gasSelfdestruct prices SELFDESTRUCT beyond its constant cost and grants
+// its refund. Since Tangerine Whistle (EIP-150), sending the balance to a
+// missing account costs as much as a call creating it, and since Spurious
+// Dragon (EIP-161) only when there is a balance to send. Since Berlin
+// (EIP-2929) a cold beneficiary costs extra, and since London (EIP-3529)
+// there is no refund.
+func gasSelfdestruct(vm *VM, memorySize uint64) (uint64, error) {
+	var gas uint64
+	fork := vm.GasTable.Fork
+	beneficiary := common.Address(vm.back(0).Bytes20())
+	if fork >= Berlin && !vm.StateDB.AddressInAccessList(beneficiary) {
+		vm.StateDB.AddAddressToAccessList(beneficiary)
+		gas = gasColdAccountAccessEIP2929
+	}
+	switch {
+	case fork >= SpuriousDragon:
+		if vm.StateDB.Empty(beneficiary) && !vm.StateDB.GetBalance(vm.Address).IsZero() {
+			gas += gasCallNewAccount
+		}
+	case fork >= TangerineWhistle:
+		if !vm.StateDB.Exist(beneficiary) {
+			gas += gasCallNewAccount
+		}
+	}
+	if fork < London && !vm.StateDB.HasSelfDestructed(vm.Address) {
+		vm.StateDB.AddRefund(refundSelfdestruct)
+	}
+	return gas, nil
+}
+
+// gasSha3 prices SHA3 per word hashed, plus memory expansion
+func gasSha3(vm *VM, memorySize uint64) (uint64, error) {
+	gas, err := memoryGas(vm, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	size := vm.back(1)
+	if !size.IsUint64() {
+		return 0, ErrGasUintOverflow
+	}
+	words, overflow := math.SafeMul(toWordSize(size.Uint64()), gasKeccakWord)
+	if overflow {
+		return 0, ErrGasUintOverflow
+	}
+	if gas, overflow = math.SafeAdd(gas, words); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	return gas, nil
+}
+
+// gasLogDataBytes prices the data of a LOG instruction per byte, plus
+// memory expansion. The topics are part of the constant cost.
+func gasLogDataBytes(vm *VM, memorySize uint64) (uint64, error) {
+	gas, err := memoryGas(vm, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	size := vm.back(1)
+	if !size.IsUint64() {
+		return 0, ErrGasUintOverflow
+	}
+	dataGas, overflow := math.SafeMul(size.Uint64(), gasLogData)
+	if overflow {
+		return 0, ErrGasUintOverflow
+	}
+	if gas, overflow = math.SafeAdd(gas, dataGas); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	return gas, nil
+}
+
+// gasCall prices CALL: sending wei costs extra, and so does creating the
+// callee, which since Spurious Dragon (EIP-161) only counts when wei is
+// sent to an empty account
+func gasCall(vm *VM, memorySize uint64) (uint64, error) {
+	var gas uint64
+	transfersValue := !vm.back(2).IsZero()
+	addr := common.Address(vm.back(1).Bytes20())
+	if vm.GasTable.Fork >= SpuriousDragon {
+		if transfersValue && vm.StateDB.Empty(addr) {
+			gas += gasCallNewAccount
+		}
+	} else if !vm.StateDB.Exist(addr) {
+		gas += gasCallNewAccount
+	}
+	if transfersValue {
+		gas += gasCallValueTransfer
+	}
+	return addCallGas(vm, memorySize, gas)
+}
+
+// gasCallCode prices CALLCODE, which never creates an account
+func gasCallCode(vm *VM, memorySize uint64) (uint64, error) {
+	var gas uint64
+	if !vm.back(2).IsZero() {
+		gas = gasCallValueTransfer
+	}
+	return addCallGas(vm, memorySize, gas)
+}
+
+// gasDelegateOrStaticCall prices DELEGATECALL and STATICCALL, which never
+// send wei
+func gasDelegateOrStaticCall(vm *VM, memorySize uint64) (uint64, error) {
+	return addCallGas(vm, memorySize, 0)
+}
+
+// addCallGas adds memory expansion and the gas passed on to the callee to
+// the cost of a call. Since Tangerine Whistle (EIP-150) a call can pass on
+// at most 63/64 of the gas left after paying for everything else; asking
+// for more is not an error, the callee just gets less.
+func addCallGas(vm *VM, memorySize uint64, gas uint64) (uint64, error) {
+	memGas, err := memoryGas(vm, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	gas, overflow := math.SafeAdd(gas, memGas)
+	if overflow {
+		return 0, ErrGasUintOverflow
+	}
+
+	requested := vm.back(0)
+	if vm.GasTable.Fork >= TangerineWhistle && vm.Gas >= gas {
+		available := vm.Gas - gas
+		available -= available / 64
+		if !requested.IsUint64() || requested.Uint64() > available {
+			vm.callGasTemp = available
+		} else {
+			vm.callGasTemp = requested.Uint64()
+		}
+	} else {
+		if !requested.IsUint64() {
+			return 0, ErrGasUintOverflow
+		}
+		vm.callGasTemp = requested.Uint64()
+	}
+
+	if gas, overflow = math.SafeAdd(gas, vm.callGasTemp); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	return gas, nil
+}
+
+// makeGasCallEIP2929 adds the cold access surcharge for the callee to
+// inner. The surcharge is taken before inner runs so that it is not part of
+// the gas passed on to the callee.
+func makeGasCallEIP2929(inner gasFunc) gasFunc {
+	return func(vm *VM, memorySize uint64) (uint64, error) {
+		addr := common.Address(vm.back(1).Bytes20())
+		if vm.StateDB.AddressInAccessList(addr) {
+			return inner(vm, memorySize)
+		}
+		vm.StateDB.AddAddressToAccessList(addr)
+		coldCost := gasColdAccountAccessEIP2929 - gasWarmReadEIP2929
+		if err := vm.ConsumeGas(coldCost); err != nil {
+			return 0, err
+		}
+		gas, err := inner(vm, memorySize)
+		// The surcharge is charged again as part of the total
+		vm.Gas += coldCost
+		if err != nil {
+			return 0, err
+		}
+		total, overflow := math.SafeAdd(gas, coldCost)
+		if overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return total, nil
+	}
+}
+
+// gasSStoreLegacy charges 20000 gas for turning a zero slot non-zero and
+// 5000 gas for any other write, refunding 15000 gas when a slot is cleared
+func gasSStoreLegacy(vm *VM, memorySize uint64) (uint64, error) {
+	current := vm.GetStorage(vm.back(0).Bytes32())
+	switch {
+	case current == (common.Hash{}) && !vm.back(1).IsZero():
+		return gasSstoreSet, nil
+	case current != (common.Hash{}) && vm.back(1).IsZero():
+		vm.StateDB.AddRefund(refundSstoreClears)
+		return gasSstoreReset, nil
+	default:
+		return gasSstoreReset, nil
+	}
+}
+
+// makeGasSStoreNetMetering implements EIP-1283 and EIP-2200, which price a
+// write by comparing the new value with the current one and with the
+// original one from the start of the transaction. Writes that do not
+// change the slot or only touch an already dirty slot cost noopGas.
+func makeGasSStoreNetMetering(noopGas uint64, sentry bool) gasFunc {
+	return func(vm *VM, memorySize uint64) (uint64, error) {
+		// EIP-2200: refuse to run SSTORE with only the call stipend left
+		if sentry && vm.Gas <= gasSstoreSentryEIP2200 {
+			return 0, fmt.Errorf("%w: not enough gas for reentrancy sentry", ErrOutOfGas)
+		}
+		slot := common.Hash(vm.back(0).Bytes32())
+		value := common.Hash(vm.back(1).Bytes32())
+		current := vm.GetStorage(slot)
+		if current == value {
+			return noopGas, nil
+		}
+		original := vm.GetCommittedStorage(slot)
+		if original == current {
+			// First write to the slot in this transaction
+			if original == (common.Hash{}) {
+				return gasSstoreSet, nil
+			}
+			if value == (common.Hash{}) {
+				vm.StateDB.AddRefund(refundSstoreClears)
+			}
+			return gasSstoreReset, nil
+		}
+		// The slot is dirty: undo or add clearing refunds as it moves
+		// between zero and non-zero, and refund the write cost when it is
+		// restored to its original value
+		if original != (common.Hash{}) {
+			if current == (common.Hash{}) {
+				vm.StateDB.SubRefund(refundSstoreClears)
+			} else if value == (common.Hash{}) {
+				vm.StateDB.AddRefund(refundSstoreClears)
+			}
+		}
+		if original == value {
+			if original == (common.Hash{}) {
+				vm.StateDB.AddRefund(gasSstoreSet - noopGas)
+			} else {
+				vm.StateDB.AddRefund(gasSstoreReset - noopGas)
+			}
+		}
+		return noopGas, nil
+	}
+}
+
+// makeGasSStoreEIP2929 is EIP-2200 net gas metering repriced for warm and
+// cold access: a cold slot costs an extra 2100 gas up front, and the noop
+// and dirty cases cost a warm read. clearingRefund is what clearing a slot
+// refunds, lowered by EIP-3529.
+func makeGasSStoreEIP2929(clearingRefund uint64) gasFunc {
+	return func(vm *VM, memorySize uint64) (uint64, error) {
+		if vm.Gas <= gasSstoreSentryEIP2200 {
+			return 0, fmt.Errorf("%w: not enough gas for reentrancy sentry", ErrOutOfGas)
+		}
+		slot := common.Hash(vm.back(0).Bytes32())
+		value := common.Hash(vm.back(1).Bytes32())
+
+		var cost uint64
+		if _, slotWarm := vm.StateDB.SlotInAccessList(vm.Address, slot); !slotWarm {
+			cost = gasColdSloadEIP2929
+			vm.StateDB.AddSlotToAccessList(vm.Address, slot)
+		}
+
+		current := vm.GetStorage(slot)
+		if current == value {
+			return cost + gasWarmReadEIP2929, nil
+		}
+		original := vm.GetCommittedStorage(slot)
+		if original == current {
+			if original == (common.Hash{}) {
+				return cost + gasSstoreSet, nil
+			}
+			if value == (common.Hash{}) {
+				vm.StateDB.AddRefund(clearingRefund)
+			}
+			return cost + (gasSstoreReset - gasColdSloadEIP2929), nil
+		}
+		if original != (common.Hash{}) {
+			if current == (common.Hash{}) {
+				vm.StateDB.SubRefund(clearingRefund)
+			} else if value == (common.Hash{}) {
+				vm.StateDB.AddRefund(clearingRefund)
+			}
+		}
+		if original == value {
+			if original == (common.Hash{}) {
+				vm.StateDB.AddRefund(gasSstoreSet - gasWarmReadEIP2929)
+			} else {
+				vm.StateDB.AddRefund((gasSstoreReset - gasColdSloadEIP2929) - gasWarmReadEIP2929)
+			}
+		}
+		return cost + gasWarmReadEIP2929, nil
+	}
+}
+
+// maxRefund caps the refund paid back for an execution that used gasUsed:
+// half of it before London, a fifth of it afterwards (EIP-3529)
+func maxRefund(fork Fork, gasUsed, refund uint64) uint64 {
+	quotient := refundQuotient
+	if fork >= London {
+		quotient = refundQuotientEIP3529
+	}
+	return min(refund, gasUsed/quotient)
+}
+
+// calcMemSize returns offset+length, the memory an access of length bytes
+// at offset needs. A zero length needs no memory, whatever the offset.
+func calcMemSize(offset, length *uint256.Int) (uint64, bool) {
+	if length.IsZero() {
+		return 0, false
+	}
+	if !offset.IsUint64() || !length.IsUint64() {
+		return 0, true
+	}
+	return math.SafeAdd(offset.Uint64(), length.Uint64())
+}
+
+func memoryMload(vm *VM) (uint64, bool) {
+	return calcMemSize(vm.back(0), uint256.NewInt(32))
+}
+
+func memoryMstore(vm *VM) (uint64, bool) {
+	return calcMemSize(vm.back(0), uint256.NewInt(32))
+}
+
+func memoryMstore8(vm *VM) (uint64, bool) {
+	return calcMemSize(vm.back(0), uint256.NewInt(1))
+}
+
+func memoryMcopy(vm *VM) (uint64, bool) {
+	dst, overflow := calcMemSize(vm.back(0), vm.back(2))
+	if overflow {
+		return 0, true
+	}
+	src, overflow := calcMemSize(vm.back(1), vm.back(2))
+	if overflow {
+		return 0, true
+	}
+	return max(dst, src), false
+}
+
+func memoryReturn(vm *VM) (uint64, bool) {
+	return calcMemSize(vm.back(0), vm.back(1))
+}
+
+func memorySha3(vm *VM) (uint64, bool) {
+	return calcMemSize(vm.back(0), vm.back(1))
+}
+
+func memoryLog(vm *VM) (uint64, bool) {
+	return calcMemSize(vm.back(0), vm.back(1))
+}
+
+func memoryCallDataCopy(vm *VM) (uint64, bool) {
+	return calcMemSize(vm.back(0), vm.back(2))
+}
+
+func memoryCodeCopy(vm *VM) (uint64, bool) {
+	return calcMemSize(vm.back(0), vm.back(2))
+}
+
+func memoryExtCodeCopy(vm *VM) (uint64, bool) {
+	return calcMemSize(vm.back(1), vm.back(3))
+}
+
+func memoryCreate(vm *VM) (uint64, bool) {
+	return calcMemSize(vm.back(1), vm.back(2))
+}
+
+func memoryCall(vm *VM) (uint64, bool) {
+	in, overflow := calcMemSize(vm.back(3), vm.back(4))
+	if overflow {
+		return 0, true
+	}
+	out, overflow := calcMemSize(vm.back(5), vm.back(6))
+	if overflow {
+		return 0, true
+	}
+	return max(in, out), false
+}
+
+func memoryDelegateOrStaticCall(vm *VM) (uint64, bool) {
+	in, overflow := calcMemSize(vm.back(2), vm.back(3))
+	if overflow {
+		return 0, true
+	}
+	out, overflow := calcMemSize(vm.back(4), vm.back(5))
+	if overflow {
+		return 0, true
+	}
+	return max(in, out), false
+}
+
+func memoryReturnDataCopy(vm *VM) (uint64, bool) {
+	return calcMemSize(vm.back(0), vm.back(2))
+}
@@ -0,0 +1,143 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// journalEntry is a state change that can be undone
+type journalEntry interface {
+	revert(s *MemoryStateDB)
+}
+
+// journal lists the changes made to a MemoryStateDB during the current
+// transaction, so that they can be undone back to a snapshot
+type journal struct {
+	entries []journalEntry
+}
+
+// append records a change
+func (j *journal) append(entry journalEntry) {
+	j.entries = append(j.entries, entry)
+}
+
+// snapshot returns an identifier for the current position in the journal
+func (j *journal) snapshot() int {
+	return len(j.entries)
+}
+
+// revertTo undoes the changes made since the snapshot, newest first
+func (j *journal) revertTo(s *MemoryStateDB, id int) {
+	if id < 0 || id > len(j.entries) {
+		panic(fmt.Sprintf("snapshot %d cannot be reverted (journal length %d)", id, len(j.entries)))
+	}
+	for i := len(j.entries) - 1; i >= id; i-- {
+		j.entries[i].revert(s)
+	}
+	j.entries = j.entries[:id]
+}
+
+// reset forgets all changes, which can then no longer be undone
+func (j *journal) reset() {
+	j.entries = nil
+}
+
+type (
+	// createAccountChange records a new account, and the account it
+	// replaced if any
+	createAccountChange struct {
+		addr common.Address
+		prev *account
+	}
+	balanceChange struct {
+		addr common.Address
+		prev uint256.Int
+	}
+	nonceChange struct {
+		addr common.Address
+		prev uint64
+	}
+	codeChange struct {
+		addr     common.Address
+		prevCode []byte
+		prevHash common.Hash
+	}
+	selfDestructChange struct {
+		addr        common.Address
+		prev        bool
+		prevBalance uint256.Int
+	}
+	storageChange struct {
+		addr      common.Address
+		key, prev common.Hash
+	}
+	transientStorageChange struct {
+		addr      common.Address
+		key, prev common.Hash
+	}
+	refundChange struct {
+		prev uint64
+	}
+	accessListAddAccountChange struct {
+		addr common.Address
+	}
+	accessListAddSlotChange struct {
+		addr common.Address
+		slot common.Hash
+	}
+	addLogChange struct{}
+)
+
+func (ch createAccountChange) revert(s *MemoryStateDB) {
+	if ch.prev == nil {
+		delete(s.accounts, ch.addr)
+	} else {
+		s.accounts[ch.addr] = ch.prev
+	}
+}
+
+func (ch balanceChange) revert(s *MemoryStateDB) {
+	s.accounts[ch.addr].balance = ch.prev
+}
+
+func (ch nonceChange) revert(s *MemoryStateDB) {
+	s.accounts[ch.addr].nonce = ch.prev
+}
+
+func (ch codeChange) revert(s *MemoryStateDB) {
+	acc := s.accounts[ch.addr]
+	acc.code = ch.prevCode
+	acc.codeHash = ch.prevHash
+}
+
+func (ch selfDestructChange) revert(s *MemoryStateDB) {
+	acc := s.accounts[ch.addr]
+	acc.selfDestructed = ch.prev
+	acc.balance = ch.prevBalance
+}
+
+func (ch storageChange) revert(s *MemoryStateDB) {
+	s.accounts[ch.addr].setState(ch.key, ch.prev)
+}
+
+func (ch transientStorageChange) revert(s *MemoryStateDB) {
+	s.setTransientState(ch.addr, ch.key, ch.prev)
+}
+
+func (ch refundChange) revert(s *MemoryStateDB) {
+	s.refund = ch.prev
+}
+
+func (ch accessListAddAccountChange) revert(s *MemoryStateDB) {
+	s.accessList.deleteAddress(ch.addr)
+}
+
+func (ch accessListAddSlotChange) revert(s *MemoryStateDB) {
+	s.accessList.deleteSlot(ch.addr, ch.slot)
+}
+
+func (ch addLogChange) revert(s *MemoryStateDB) {
+	s.logs = s.logs[:len(s.logs)-1]
+}
@@ -0,0 +1,66 @@
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Log is an event emitted by one of the LOG instructions
+type Log struct {
+	// Address is the contract that emitted the event
+	Address common.Address
+	// Topics holds up to four indexed values; for Solidity events the first
+	// one is the hash of the event signature unless the event is anonymous
+	Topics []common.Hash
+	// Data holds the ABI-encoded non-indexed values
+	Data []byte
+}
+
+// BloomBitLength is the number of bits in a logs bloom
+const BloomBitLength = 2048
+
+// Bloom is the 2048-bit bloom filter of a set of logs, as stored in
+// receipts and block headers. It lets a reader rule out that an address or
+// topic was logged without looking at the logs themselves.
+type Bloom [BloomBitLength / 8]byte
+
+// Add sets the three bits that data maps to: each is taken from a pair of
+// bytes of the Keccak-256 hash of data, modulo 2048
+func (b *Bloom) Add(data []byte) {
+	for _, i := range bloomIndexes(data) {
+		b[len(b)-1-i/8] |= 1 << (i % 8)
+	}
+}
+
+// Test reports whether data may have been added to the filter. False
+// positives are possible, false negatives are not.
+func (b Bloom) Test(data []byte) bool {
+	for _, i := range bloomIndexes(data) {
+		if b[len(b)-1-i/8]&(1<<(i%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomIndexes returns the bit positions data maps to in a bloom
+func bloomIndexes(data []byte) [3]int {
+	hash := crypto.Keccak256(data)
+	var idx [3]int
+	for i := range idx {
+		idx[i] = (int(hash[2*i])<<8 | int(hash[2*i+1])) & (BloomBitLength - 1)
+	}
+	return idx
+}
+
+// LogsBloom returns the bloom of the address and topics of every log
+func LogsBloom(logs []*Log) Bloom {
+	var b Bloom
+	for _, log := range logs {
+		b.Add(log.Address[:])
+		for _, topic := range log.Topics {
+			b.Add(topic[:])
+		}
+	}
+	return b
+}
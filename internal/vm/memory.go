@@ -1,141 +1,246 @@
 package vm
 
 import (
-	"errors"
 	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
 )
 
+// maxMemorySize is the largest memory size whose expansion cost still fits
+// in a uint64; anything beyond it is reported as out of gas
+const maxMemorySize = 0x1FFFFFFFE0
+
+// Memory is the byte-addressable scratch space of an execution. It starts
+// empty, reads as zero everywhere and grows in 32-byte words.
 type Memory struct {
 	data []byte
-	size int
+	// gasCost is the total expansion cost already paid for the current size
+	gasCost uint64
+}
+
+// NewMemory initializes a new, empty memory instance.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Len returns the current memory size in bytes.
+func (m *Memory) Len() int {
+	return len(m.data)
+}
+
+// Data returns the underlying memory contents.
+func (m *Memory) Data() []byte {
+	return m.data
 }
 
-// NewMemory initializes a new memory instance with the specified size.
-func NewMemory(size int) *Memory {
-	return &Memory{
-		data: make([]byte, size),
-		size: size,
+// Resize grows memory to size bytes. Memory never shrinks.
+func (m *Memory) Resize(size uint64) {
+	if uint64(len(m.data)) < size {
+		m.data = append(m.data, make([]byte, size-uint64(len(m.data)))...)
 	}
 }
 
-// Get retrieves a byte from memory at the specified address.
-func (m *Memory) Get(address int) byte {
-	if address < 0 || address >= m.size {
-		panic("memory access out of bounds")
+// Set copies value into memory at offset. The range must already be
+// allocated.
+func (m *Memory) Set(offset uint64, value []byte) {
+	if len(value) == 0 {
+		return
 	}
-	return m.data[address]
+	copy(m.data[offset:offset+uint64(len(value))], value)
 }
 
-// Set stores a byte in memory at the specified address.
-func (m *Memory) Set(address int, value byte) {
-	if address < 0 || address >= m.size {
-		panic("memory access out of bounds")
+// Set32 stores val as a 32-byte big-endian word at offset.
+func (m *Memory) Set32(offset uint64, val *uint256.Int) {
+	val.PutUint256(m.data[offset : offset+32])
+}
+
+// GetCopy returns a copy of size bytes starting at offset.
+func (m *Memory) GetCopy(offset, size uint64) []byte {
+	if size == 0 {
+		return nil
+	}
+	result := make([]byte, size)
+	copy(result, m.data[offset:offset+size])
+	return result
+}
+
+// GetPtr returns a slice aliasing size bytes of memory starting at offset.
+func (m *Memory) GetPtr(offset, size uint64) []byte {
+	if size == 0 {
+		return nil
 	}
-	m.data[address] = value
+	return m.data[offset : offset+size]
 }
 
-// Allocate allocates a block of memory and returns the starting address.
-func (m *Memory) Allocate(size int) int {
-	// Simple allocation logic (not handling fragmentation)
-	startAddress := m.size - size
-	if startAddress < 0 {
-		panic("not enough memory to allocate")
+// Copy moves length bytes from src to dst, handling overlapping ranges.
+func (m *Memory) Copy(dst, src, length uint64) {
+	if length == 0 {
+		return
 	}
-	m.size -= size
-	return startAddress
+	copy(m.data[dst:dst+length], m.data[src:src+length])
+}
+
+// memoryGasCost is the total cost of a memory of the given number of words:
+// 3 gas per word plus a quadratic term that dominates for large sizes.
+func memoryGasCost(words uint64) uint64 {
+	return words*3 + words*words/512
 }
 
-// Deallocate frees a block of memory starting from the specified address.
-func (m *Memory) Deallocate(address int, size int) {
-	// Simple deallocation logic (not handling fragmentation)
-	if address < 0 || address+size > m.size {
-		panic("memory deallocation out of bounds")
+// toWordSize rounds a byte size up to whole 32-byte words.
+func toWordSize(size uint64) uint64 {
+	if size > ^uint64(0)-31 {
+		return ^uint64(0)/32 + 1
 	}
-	// In a real implementation, we would handle the freed memory
+	return (size + 31) / 32
 }
 
 // VM represents the virtual machine state
 type VM struct {
-	// Memory storage
-	Memory []byte
-	// Stack for operations
-	Stack []uint64
+	// Memory of the current execution
+	Memory *Memory
+	// Stack of 256-bit words
+	Stack []uint256.Int
 	// Program counter
 	PC uint64
 	// Gas remaining for execution
 	Gas uint64
-	// Contract storage (simulating Ethereum's state)
-	Storage map[string][]byte
+	// World state holding the accounts, their storage and the refund
+	// counter and access list of the transaction
+	StateDB StateDB
+	// Transaction and block the execution runs in
+	Context *ExecutionContext
+	// Address of the account whose code is running
+	Address common.Address
+	// Caller of the running code and the wei it sent
+	Caller common.Address
+	Value  *uint256.Int
+	// Calldata of the call, read by CALLDATALOAD, CALLDATASIZE and
+	// CALLDATACOPY
+	Input []byte
+	// Code being executed
+	Code []byte
+	// Output set by RETURN or REVERT
+	Output []byte
+	// Return data of the most recent sub-call, read by RETURNDATASIZE and
+	// RETURNDATACOPY
+	ReturnData []byte
+
+	// Instruction set and gas schedule of the active hardfork
+	GasTable *GasTable
+
+	// Call depth of this frame, 0 for the frame of the transaction
+	Depth int
+	// Whether state modifications are forbidden, inside a STATICCALL
+	ReadOnly bool
+
+	// EVM that runs the sub-calls made by this frame
+	evm *EVM
+	// Valid jump destinations within Code
+	jumpdests jumpdestBitmap
+	// Gas to give the callee of the CALL being executed, worked out while
+	// charging its dynamic gas
+	callGasTemp uint64
 }
 
+// Execute runs bytecode on this VM and returns the word left on top of the
+// stack, or nil if the stack is empty
 func (vm *VM) Execute(bytecode []byte) (any, error) {
-	panic("unimplemented")
+	result := vm.Run(Contract{Bytecode: bytecode}, nil)
+	if !result.Success {
+		return nil, result.Error
+	}
+	if len(vm.Stack) == 0 {
+		return nil, nil
+	}
+	top := vm.Stack[len(vm.Stack)-1]
+	return &top, nil
 }
 
-// NewVM creates a new instance of the virtual machine
+// NewVM creates a new instance of the virtual machine in the default
+// context
 func NewVM() *VM {
-	return &VM{
-		Memory:  make([]byte, 2048*2048),
-		Stack:   make([]uint64, 0, 2048),
-		PC:      0,
-		Gas:     100000, // Initial gas limit
-		Storage: make(map[string][]byte),
-	}
+	return NewVMWithContext(DefaultContext())
+}
+
+// NewVMWithContext creates a virtual machine for a call described by ctx,
+// using the gas limit and hardfork it specifies, on an empty world state
+func NewVMWithContext(ctx ExecutionContext) *VM {
+	evm := NewEVM(ctx, NewMemoryStateDB())
+	return evm.newFrame(message{
+		kind:        CALL,
+		caller:      ctx.Caller,
+		address:     ctx.Address,
+		codeAddress: ctx.Address,
+		value:       orZero(ctx.Value),
+		gas:         ctx.GasLimit,
+	}, evm.GasTable)
 }
 
-// Push adds a value to the stack
-func (vm *VM) Push(value uint64) error {
-	if len(vm.Stack) >= 1024 {
-		return errors.New("stack overflow")
+// Push adds a copy of value to the stack
+func (vm *VM) Push(value *uint256.Int) error {
+	if len(vm.Stack) >= stackLimit {
+		return ErrStackOverflow
 	}
-	vm.Stack = append(vm.Stack, value)
+	vm.Stack = append(vm.Stack, *value)
 	return nil
 }
 
 // Pop removes and returns the top value from the stack
-func (vm *VM) Pop() (uint64, error) {
+func (vm *VM) Pop() (uint256.Int, error) {
 	if len(vm.Stack) == 0 {
-		return 0, errors.New("stack underflow")
+		return uint256.Int{}, ErrStackUnderflow
 	}
 	value := vm.Stack[len(vm.Stack)-1]
 	vm.Stack = vm.Stack[:len(vm.Stack)-1]
 	return value, nil
 }
 
-// Store stores data in memory
-func (vm *VM) Store(offset uint64, data []byte) error {
-	if offset+uint64(len(data)) > uint64(len(vm.Memory)) {
-		return errors.New("memory out of bounds")
+// back returns the n-th stack item counting from 0 at the top, without
+// removing it. The caller must ensure the stack is deep enough.
+func (vm *VM) back(n int) *uint256.Int {
+	return &vm.Stack[len(vm.Stack)-1-n]
+}
+
+// Dup pushes a copy of the n-th stack item, counting from 1 at the top
+func (vm *VM) Dup(n int) error {
+	if len(vm.Stack) < n {
+		return fmt.Errorf("stack underflow: DUP%d needs %d items, have %d", n, n, len(vm.Stack))
 	}
-	copy(vm.Memory[offset:], data)
-	return nil
+	return vm.Push(&vm.Stack[len(vm.Stack)-n])
 }
 
-// Load reads data from memory
-func (vm *VM) Load(offset uint64, length uint64) ([]byte, error) {
-	if offset+length > uint64(len(vm.Memory)) {
-		return nil, errors.New("memory out of bounds")
+// Swap exchanges the top stack item with the one n places below it
+func (vm *VM) Swap(n int) error {
+	if len(vm.Stack) <= n {
+		return fmt.Errorf("stack underflow: SWAP%d needs %d items, have %d", n, n+1, len(vm.Stack))
 	}
-	result := make([]byte, length)
-	copy(result, vm.Memory[offset:offset+length])
-	return result, nil
+	top := len(vm.Stack) - 1
+	vm.Stack[top], vm.Stack[top-n] = vm.Stack[top-n], vm.Stack[top]
+	return nil
 }
 
-// SetStorage sets a value in contract storage
-func (vm *VM) SetStorage(key string, value []byte) {
-	vm.Storage[key] = value
+// SetStorage sets a 32-byte word in the storage of the running account
+func (vm *VM) SetStorage(key, value common.Hash) {
+	vm.StateDB.SetState(vm.Address, key, value)
 }
 
-// GetStorage retrieves a value from contract storage
-func (vm *VM) GetStorage(key string) ([]byte, bool) {
-	value, exists := vm.Storage[key]
-	return value, exists
+// GetStorage retrieves a 32-byte word from the storage of the running
+// account
+func (vm *VM) GetStorage(key common.Hash) common.Hash {
+	return vm.StateDB.GetState(vm.Address, key)
+}
+
+// GetCommittedStorage retrieves the value a slot held when the transaction
+// started, before any writes made by it
+func (vm *VM) GetCommittedStorage(key common.Hash) common.Hash {
+	return vm.StateDB.GetCommittedState(vm.Address, key)
 }
 
 // ConsumeGas reduces the available gas and checks if we've run out
 func (vm *VM) ConsumeGas(amount uint64) error {
 	if vm.Gas < amount {
-		return errors.New("out of gas")
+		return ErrOutOfGas
 	}
 	vm.Gas -= amount
 	return nil
@@ -143,5 +248,9 @@ func (vm *VM) ConsumeGas(amount uint64) error {
 
 // String returns a string representation of VM state
 func (vm *VM) String() string {
-	return fmt.Sprintf("VM{PC:%d, Gas:%d, Stack:%v}", vm.PC, vm.Gas, vm.Stack)
+	stack := make([]string, len(vm.Stack))
+	for i := range vm.Stack {
+		stack[i] = vm.Stack[i].Hex()
+	}
+	return fmt.Sprintf("VM{PC:%d, Gas:%d, Stack:%v}", vm.PC, vm.Gas, stack)
 }
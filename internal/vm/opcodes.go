@@ -1,149 +1,1036 @@
 package vm
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
 
 // OpCode represents a VM operation code
 type OpCode byte
 
 // Define opcodes similar to Ethereum VM
 const (
+	// 0x00 range - arithmetic
+	STOP       OpCode = 0x00
+	ADD        OpCode = 0x01
+	MUL        OpCode = 0x02
+	SUB        OpCode = 0x03
+	DIV        OpCode = 0x04
+	SDIV       OpCode = 0x05
+	MOD        OpCode = 0x06
+	SMOD       OpCode = 0x07
+	ADDMOD     OpCode = 0x08
+	MULMOD     OpCode = 0x09
+	EXP        OpCode = 0x0a
+	SIGNEXTEND OpCode = 0x0b
+
+	// 0x10 range - comparison and bitwise logic
+	LT     OpCode = 0x10
+	GT     OpCode = 0x11
+	SLT    OpCode = 0x12
+	SGT    OpCode = 0x13
+	EQ     OpCode = 0x14
+	ISZERO OpCode = 0x15
+	AND    OpCode = 0x16
+	OR     OpCode = 0x17
+	XOR    OpCode = 0x18
+	NOT    OpCode = 0x19
+	BYTE   OpCode = 0x1a
+	SHL    OpCode = 0x1b
+	SHR    OpCode = 0x1c
+	SAR    OpCode = 0x1d
+
+	// 0x20 range - crypto
+	SHA3 OpCode = 0x20
+
+	// KECCAK256 is the name SHA3 goes by since Solidity 0.8, as it computes
+	// Keccak-256 rather than the standardized SHA3-256
+	KECCAK256 = SHA3
+
+	// 0x30 range - environment
+	ADDRESS        OpCode = 0x30
+	BALANCE        OpCode = 0x31
+	ORIGIN         OpCode = 0x32
+	CALLER         OpCode = 0x33
+	CALLVALUE      OpCode = 0x34
+	CALLDATALOAD   OpCode = 0x35
+	CALLDATASIZE   OpCode = 0x36
+	CALLDATACOPY   OpCode = 0x37
+	CODESIZE       OpCode = 0x38
+	CODECOPY       OpCode = 0x39
+	GASPRICE       OpCode = 0x3a
+	EXTCODESIZE    OpCode = 0x3b
+	EXTCODECOPY    OpCode = 0x3c
+	RETURNDATASIZE OpCode = 0x3d
+	RETURNDATACOPY OpCode = 0x3e
+	EXTCODEHASH    OpCode = 0x3f
+
+	// 0x40 range - block information
+	COINBASE    OpCode = 0x41
+	TIMESTAMP   OpCode = 0x42
+	NUMBER      OpCode = 0x43
+	PREVRANDAO  OpCode = 0x44
+	GASLIMIT    OpCode = 0x45
+	CHAINID     OpCode = 0x46
+	SELFBALANCE OpCode = 0x47
+	BASEFEE     OpCode = 0x48
+	BLOBBASEFEE OpCode = 0x4a
+
+	// DIFFICULTY is the pre-Merge name of PREVRANDAO
+	DIFFICULTY = PREVRANDAO
+
+	// 0x50 range - stack, storage and flow
+	POP      OpCode = 0x50
+	MLOAD    OpCode = 0x51
+	MSTORE   OpCode = 0x52
+	MSTORE8  OpCode = 0x53
+	SLOAD    OpCode = 0x54
+	SSTORE   OpCode = 0x55
+	JUMP     OpCode = 0x56
+	JUMPI    OpCode = 0x57
+	PC       OpCode = 0x58
+	MSIZE    OpCode = 0x59
+	GAS      OpCode = 0x5a
+	JUMPDEST OpCode = 0x5b
+	TLOAD    OpCode = 0x5c
+	TSTORE   OpCode = 0x5d
+	MCOPY    OpCode = 0x5e
+	PUSH0    OpCode = 0x5f
+
+	// 0x60 range - push
 	PUSH1  OpCode = 0x60
+	PUSH2  OpCode = 0x61
+	PUSH3  OpCode = 0x62
+	PUSH4  OpCode = 0x63
+	PUSH5  OpCode = 0x64
+	PUSH6  OpCode = 0x65
+	PUSH7  OpCode = 0x66
+	PUSH8  OpCode = 0x67
+	PUSH9  OpCode = 0x68
+	PUSH10 OpCode = 0x69
+	PUSH11 OpCode = 0x6a
+	PUSH12 OpCode = 0x6b
+	PUSH13 OpCode = 0x6c
+	PUSH14 OpCode = 0x6d
+	PUSH15 OpCode = 0x6e
+	PUSH16 OpCode = 0x6f
+	PUSH17 OpCode = 0x70
+	PUSH18 OpCode = 0x71
+	PUSH19 OpCode = 0x72
+	PUSH20 OpCode = 0x73
+	PUSH21 OpCode = 0x74
+	PUSH22 OpCode = 0x75
+	PUSH23 OpCode = 0x76
+	PUSH24 OpCode = 0x77
+	PUSH25 OpCode = 0x78
+	PUSH26 OpCode = 0x79
+	PUSH27 OpCode = 0x7a
+	PUSH28 OpCode = 0x7b
+	PUSH29 OpCode = 0x7c
+	PUSH30 OpCode = 0x7d
+	PUSH31 OpCode = 0x7e
 	PUSH32 OpCode = 0x7f
-	POP    OpCode = 0x50
-	ADD    OpCode = 0x01
-	SUB    OpCode = 0x03
-	MUL    OpCode = 0x02
-	DIV    OpCode = 0x04
-	SSTORE OpCode = 0x55
-	SLOAD  OpCode = 0x54
-	JUMP   OpCode = 0x56
-	JUMPI  OpCode = 0x57
-	STOP   OpCode = 0x00
+
+	// 0x80 range - dup
+	DUP1  OpCode = 0x80
+	DUP2  OpCode = 0x81
+	DUP3  OpCode = 0x82
+	DUP4  OpCode = 0x83
+	DUP5  OpCode = 0x84
+	DUP6  OpCode = 0x85
+	DUP7  OpCode = 0x86
+	DUP8  OpCode = 0x87
+	DUP9  OpCode = 0x88
+	DUP10 OpCode = 0x89
+	DUP11 OpCode = 0x8a
+	DUP12 OpCode = 0x8b
+	DUP13 OpCode = 0x8c
+	DUP14 OpCode = 0x8d
+	DUP15 OpCode = 0x8e
+	DUP16 OpCode = 0x8f
+
+	// 0x90 range - swap
+	SWAP1  OpCode = 0x90
+	SWAP2  OpCode = 0x91
+	SWAP3  OpCode = 0x92
+	SWAP4  OpCode = 0x93
+	SWAP5  OpCode = 0x94
+	SWAP6  OpCode = 0x95
+	SWAP7  OpCode = 0x96
+	SWAP8  OpCode = 0x97
+	SWAP9  OpCode = 0x98
+	SWAP10 OpCode = 0x99
+	SWAP11 OpCode = 0x9a
+	SWAP12 OpCode = 0x9b
+	SWAP13 OpCode = 0x9c
+	SWAP14 OpCode = 0x9d
+	SWAP15 OpCode = 0x9e
+	SWAP16 OpCode = 0x9f
+
+	// 0xa0 range - logging
+	LOG0 OpCode = 0xa0
+	LOG1 OpCode = 0xa1
+	LOG2 OpCode = 0xa2
+	LOG3 OpCode = 0xa3
+	LOG4 OpCode = 0xa4
+
+	// 0xf0 range - system
+	CREATE       OpCode = 0xf0
+	CALL         OpCode = 0xf1
+	CALLCODE     OpCode = 0xf2
+	RETURN       OpCode = 0xf3
+	DELEGATECALL OpCode = 0xf4
+	CREATE2      OpCode = 0xf5
+	STATICCALL   OpCode = 0xfa
+	REVERT       OpCode = 0xfd
+	INVALID      OpCode = 0xfe
+	SELFDESTRUCT OpCode = 0xff
 )
 
-// ExecuteOpcode executes a single opcode
+// IsPush reports whether op is one of PUSH1 through PUSH32
+func (op OpCode) IsPush() bool {
+	return op >= PUSH1 && op <= PUSH32
+}
+
+// opCodeNames holds the mnemonic of each defined opcode, as used by geth and
+// in EIP-3155 traces. The PUSH, DUP, SWAP and LOG families are filled in by
+// init.
+var opCodeNames = map[OpCode]string{
+	STOP:       "STOP",
+	ADD:        "ADD",
+	MUL:        "MUL",
+	SUB:        "SUB",
+	DIV:        "DIV",
+	SDIV:       "SDIV",
+	MOD:        "MOD",
+	SMOD:       "SMOD",
+	ADDMOD:     "ADDMOD",
+	MULMOD:     "MULMOD",
+	EXP:        "EXP",
+	SIGNEXTEND: "SIGNEXTEND",
+
+	LT:     "LT",
+	GT:     "GT",
+	SLT:    "SLT",
+	SGT:    "SGT",
+	EQ:     "EQ",
+	ISZERO: "ISZERO",
+	AND:    "AND",
+	OR:     "OR",
+	XOR:    "XOR",
+	NOT:    "NOT",
+	BYTE:   "BYTE",
+	SHL:    "SHL",
+	SHR:    "SHR",
+	SAR:    "SAR",
+
+	KECCAK256: "KECCAK256",
+
+	ADDRESS:        "ADDRESS",
+	BALANCE:        "BALANCE",
+	ORIGIN:         "ORIGIN",
+	CALLER:         "CALLER",
+	CALLVALUE:      "CALLVALUE",
+	CALLDATALOAD:   "CALLDATALOAD",
+	CALLDATASIZE:   "CALLDATASIZE",
+	CALLDATACOPY:   "CALLDATACOPY",
+	CODESIZE:       "CODESIZE",
+	CODECOPY:       "CODECOPY",
+	GASPRICE:       "GASPRICE",
+	EXTCODESIZE:    "EXTCODESIZE",
+	EXTCODECOPY:    "EXTCODECOPY",
+	RETURNDATASIZE: "RETURNDATASIZE",
+	RETURNDATACOPY: "RETURNDATACOPY",
+	EXTCODEHASH:    "EXTCODEHASH",
+
+	COINBASE:    "COINBASE",
+	TIMESTAMP:   "TIMESTAMP",
+	NUMBER:      "NUMBER",
+	PREVRANDAO:  "PREVRANDAO",
+	GASLIMIT:    "GASLIMIT",
+	CHAINID:     "CHAINID",
+	SELFBALANCE: "SELFBALANCE",
+	BASEFEE:     "BASEFEE",
+	BLOBBASEFEE: "BLOBBASEFEE",
+
+	POP:      "POP",
+	MLOAD:    "MLOAD",
+	MSTORE:   "MSTORE",
+	MSTORE8:  "MSTORE8",
+	SLOAD:    "SLOAD",
+	SSTORE:   "SSTORE",
+	JUMP:     "JUMP",
+	JUMPI:    "JUMPI",
+	PC:       "PC",
+	MSIZE:    "MSIZE",
+	GAS:      "GAS",
+	JUMPDEST: "JUMPDEST",
+	TLOAD:    "TLOAD",
+	TSTORE:   "TSTORE",
+	MCOPY:    "MCOPY",
+	PUSH0:    "PUSH0",
+
+	CREATE:       "CREATE",
+	CALL:         "CALL",
+	CALLCODE:     "CALLCODE",
+	RETURN:       "RETURN",
+	DELEGATECALL: "DELEGATECALL",
+	CREATE2:      "CREATE2",
+	STATICCALL:   "STATICCALL",
+	REVERT:       "REVERT",
+	INVALID:      "INVALID",
+	SELFDESTRUCT: "SELFDESTRUCT",
+}
+
+func init() {
+	for i := 0; i < 32; i++ {
+		opCodeNames[PUSH1+OpCode(i)] = fmt.Sprintf("PUSH%d", i+1)
+	}
+	for i := 0; i < 16; i++ {
+		opCodeNames[DUP1+OpCode(i)] = fmt.Sprintf("DUP%d", i+1)
+		opCodeNames[SWAP1+OpCode(i)] = fmt.Sprintf("SWAP%d", i+1)
+	}
+	for i := 0; i <= 4; i++ {
+		opCodeNames[LOG0+OpCode(i)] = fmt.Sprintf("LOG%d", i)
+	}
+	for op, name := range opCodeNames {
+		opCodesByName[name] = op
+	}
+}
+
+// opCodesByName maps mnemonics back to opcodes, accepting the older names
+// of renamed opcodes as well
+var opCodesByName = map[string]OpCode{
+	"SHA3":       SHA3,
+	"DIFFICULTY": DIFFICULTY,
+}
+
+// String returns the mnemonic of op, or a description of it if it is not
+// a defined opcode
+func (op OpCode) String() string {
+	if name, ok := opCodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("opcode %#x not defined", int(op))
+}
+
+// StringToOpCode returns the opcode whose mnemonic is name, and whether
+// there is one
+func StringToOpCode(name string) (OpCode, bool) {
+	op, ok := opCodesByName[name]
+	return op, ok
+}
+
+// ExecuteOpcode executes a single opcode. Stack bounds, gas and memory
+// expansion have already been handled by the interpreter loop from the
+// VM's gas table.
 func ExecuteOpcode(vm *VM, opcode OpCode, operand []byte) error {
+	switch {
+	case opcode.IsPush():
+		var value uint256.Int
+		value.SetBytes(operand)
+		return vm.Push(&value)
+	case opcode >= DUP1 && opcode <= DUP16:
+		return vm.Dup(int(opcode-DUP1) + 1)
+	case opcode >= SWAP1 && opcode <= SWAP16:
+		return vm.Swap(int(opcode-SWAP1) + 1)
+	}
+
 	switch opcode {
-	case PUSH1, PUSH32:
-		// Convert operand bytes to uint64
-		var value uint64
-		for i := 0; i < len(operand); i++ {
-			value = (value << 8) | uint64(operand[i])
-		}
-		return vm.Push(value)
+	case PUSH0:
+		return vm.Push(new(uint256.Int))
 
 	case POP:
 		_, err := vm.Pop()
 		return err
 
+	// Arithmetic. The top of the stack is the left-hand operand.
 	case ADD:
-		b, err := vm.Pop()
+		return binaryOp(vm, func(x, y *uint256.Int) { x.Add(x, y) })
+	case SUB:
+		return binaryOp(vm, func(x, y *uint256.Int) { x.Sub(x, y) })
+	case MUL:
+		return binaryOp(vm, func(x, y *uint256.Int) { x.Mul(x, y) })
+	case DIV:
+		// Division by zero yields 0, uint256 already follows that rule
+		return binaryOp(vm, func(x, y *uint256.Int) { x.Div(x, y) })
+	case SDIV:
+		return binaryOp(vm, func(x, y *uint256.Int) { x.SDiv(x, y) })
+	case MOD:
+		return binaryOp(vm, func(x, y *uint256.Int) { x.Mod(x, y) })
+	case SMOD:
+		return binaryOp(vm, func(x, y *uint256.Int) { x.SMod(x, y) })
+	case ADDMOD:
+		return ternaryOp(vm, func(x, y, m *uint256.Int) { x.AddMod(x, y, m) })
+	case MULMOD:
+		return ternaryOp(vm, func(x, y, m *uint256.Int) { x.MulMod(x, y, m) })
+	case EXP:
+		return binaryOp(vm, func(base, exponent *uint256.Int) { base.Exp(base, exponent) })
+	case SIGNEXTEND:
+		// The byte position is on top, the value to extend below it
+		return binaryOp(vm, func(back, num *uint256.Int) { back.ExtendSign(num, back) })
+
+	// Comparison, pushing 1 for true and 0 for false
+	case LT:
+		return binaryOp(vm, func(x, y *uint256.Int) { setBool(x, x.Lt(y)) })
+	case GT:
+		return binaryOp(vm, func(x, y *uint256.Int) { setBool(x, x.Gt(y)) })
+	case SLT:
+		return binaryOp(vm, func(x, y *uint256.Int) { setBool(x, x.Slt(y)) })
+	case SGT:
+		return binaryOp(vm, func(x, y *uint256.Int) { setBool(x, x.Sgt(y)) })
+	case EQ:
+		return binaryOp(vm, func(x, y *uint256.Int) { setBool(x, x.Eq(y)) })
+	case ISZERO:
+		return unaryOp(vm, func(x *uint256.Int) { setBool(x, x.IsZero()) })
+
+	// Bitwise logic
+	case AND:
+		return binaryOp(vm, func(x, y *uint256.Int) { x.And(x, y) })
+	case OR:
+		return binaryOp(vm, func(x, y *uint256.Int) { x.Or(x, y) })
+	case XOR:
+		return binaryOp(vm, func(x, y *uint256.Int) { x.Xor(x, y) })
+	case NOT:
+		return unaryOp(vm, func(x *uint256.Int) { x.Not(x) })
+	case BYTE:
+		// Byte index on top, word below; out of range indices yield 0
+		return binaryOp(vm, func(index, word *uint256.Int) { index.Set(word.Byte(index)) })
+	case SHL:
+		return binaryOp(vm, func(shift, value *uint256.Int) {
+			if shift.LtUint64(256) {
+				shift.Lsh(value, uint(shift.Uint64()))
+			} else {
+				shift.Clear()
+			}
+		})
+	case SHR:
+		return binaryOp(vm, func(shift, value *uint256.Int) {
+			if shift.LtUint64(256) {
+				shift.Rsh(value, uint(shift.Uint64()))
+			} else {
+				shift.Clear()
+			}
+		})
+	case SAR:
+		return binaryOp(vm, func(shift, value *uint256.Int) {
+			switch {
+			case shift.LtUint64(256):
+				shift.SRsh(value, uint(shift.Uint64()))
+			case value.Sign() >= 0:
+				// Shifting a non-negative value out entirely leaves 0
+				shift.Clear()
+			default:
+				// Negative values saturate to -1
+				shift.SetAllOne()
+			}
+		})
+
+	case MLOAD:
+		offset, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		a, err := vm.Pop()
+		var value uint256.Int
+		value.SetBytes32(vm.Memory.GetPtr(offset.Uint64(), 32))
+		return vm.Push(&value)
+
+	case MSTORE:
+		offset, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		return vm.Push(a + b)
+		value, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		vm.Memory.Set32(offset.Uint64(), &value)
+		return nil
 
-	case SUB:
-		b, err := vm.Pop()
+	case MSTORE8:
+		offset, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		a, err := vm.Pop()
+		value, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		return vm.Push(a - b)
+		vm.Memory.Set(offset.Uint64(), []byte{byte(value.Uint64())})
+		return nil
 
-	case MUL:
-		b, err := vm.Pop()
+	case MSIZE:
+		return vm.Push(uint256.NewInt(uint64(vm.Memory.Len())))
+
+	case MCOPY:
+		dst, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		a, err := vm.Pop()
+		src, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		return vm.Push(a * b)
-
-	case DIV:
-		b, err := vm.Pop()
+		length, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		if b == 0 {
-			return vm.Push(0) // Division by zero returns 0 in Solidity
+		vm.Memory.Copy(dst.Uint64(), src.Uint64(), length.Uint64())
+		return nil
+
+	case SHA3:
+		offset, err := vm.Pop()
+		if err != nil {
+			return err
 		}
-		a, err := vm.Pop()
+		size, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		return vm.Push(a / b)
+		var hash uint256.Int
+		hash.SetBytes(crypto.Keccak256(vm.Memory.GetPtr(offset.Uint64(), size.Uint64())))
+		return vm.Push(&hash)
 
 	case SSTORE:
+		if vm.ReadOnly {
+			return ErrWriteProtection
+		}
+		key, err := vm.Pop()
+		if err != nil {
+			return err
+		}
 		value, err := vm.Pop()
 		if err != nil {
 			return err
 		}
+		if tracer := vm.evm.Tracer; tracer != nil {
+			tracer.OnStorageChange(vm.Address, key.Bytes32(), vm.GetStorage(key.Bytes32()), value.Bytes32())
+		}
+		vm.SetStorage(key.Bytes32(), value.Bytes32())
+		return nil
+
+	case TSTORE:
+		if vm.ReadOnly {
+			return ErrWriteProtection
+		}
 		key, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		keyStr := fmt.Sprintf("%x", key)
-		valueBytes := make([]byte, 8)
-		for i := 0; i < 8; i++ {
-			valueBytes[i] = byte(value >> (8 * (7 - i)))
+		value, err := vm.Pop()
+		if err != nil {
+			return err
 		}
-		vm.SetStorage(keyStr, valueBytes)
+		vm.StateDB.SetTransientState(vm.Address, key.Bytes32(), value.Bytes32())
 		return nil
 
-	case SLOAD:
+	case TLOAD:
 		key, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		keyStr := fmt.Sprintf("%x", key)
-		value, exists := vm.GetStorage(keyStr)
-		if !exists {
-			return vm.Push(0)
-		}
+		stored := vm.StateDB.GetTransientState(vm.Address, key.Bytes32())
+		var value uint256.Int
+		value.SetBytes32(stored[:])
+		return vm.Push(&value)
 
-		var result uint64
-		for i := 0; i < len(value) && i < 8; i++ {
-			result = (result << 8) | uint64(value[i])
+	case SLOAD:
+		key, err := vm.Pop()
+		if err != nil {
+			return err
 		}
-		return vm.Push(result)
+		stored := vm.GetStorage(key.Bytes32())
+		var value uint256.Int
+		value.SetBytes32(stored[:])
+		return vm.Push(&value)
 
 	case JUMP:
 		dest, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		vm.PC = dest
+		if !vm.validJumpdest(&dest) {
+			return ErrInvalidJump
+		}
+		vm.PC = dest.Uint64()
 		return nil
 
 	case JUMPI:
+		dest, err := vm.Pop()
+		if err != nil {
+			return err
+		}
 		cond, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		dest, err := vm.Pop()
+		if cond.IsZero() {
+			return nil
+		}
+		if !vm.validJumpdest(&dest) {
+			return ErrInvalidJump
+		}
+		vm.PC = dest.Uint64()
+		return nil
+
+	case JUMPDEST:
+		// Marks a valid jump target, no effect when executed
+		return nil
+
+	case PC:
+		// vm.PC already points past this single-byte instruction
+		return vm.Push(uint256.NewInt(vm.PC - 1))
+
+	case ADDRESS:
+		return vm.Push(new(uint256.Int).SetBytes20(vm.Address[:]))
+	case ORIGIN:
+		return vm.Push(new(uint256.Int).SetBytes20(vm.Context.Origin[:]))
+	case CALLER:
+		return vm.Push(new(uint256.Int).SetBytes20(vm.Caller[:]))
+	case CALLVALUE:
+		return vm.Push(vm.Value)
+	case GASPRICE:
+		return vm.Push(orZero(vm.Context.GasPrice))
+
+	case BALANCE:
+		addr, err := vm.Pop()
 		if err != nil {
 			return err
 		}
-		if cond != 0 {
-			vm.PC = dest
+		return vm.Push(vm.StateDB.GetBalance(addr.Bytes20()))
+	case EXTCODESIZE:
+		addr, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		return vm.Push(uint256.NewInt(uint64(vm.StateDB.GetCodeSize(addr.Bytes20()))))
+	case EXTCODEHASH:
+		addr, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		// Accounts that do not exist or are empty hash to zero (EIP-1052)
+		if vm.StateDB.Empty(addr.Bytes20()) {
+			return vm.Push(new(uint256.Int))
+		}
+		hash := vm.StateDB.GetCodeHash(addr.Bytes20())
+		return vm.Push(new(uint256.Int).SetBytes32(hash[:]))
+	case EXTCODECOPY:
+		addr, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		memOffset, err := vm.Pop()
+		if err != nil {
+			return err
 		}
+		codeOffset, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		length, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		code := vm.StateDB.GetCode(addr.Bytes20())
+		vm.Memory.Set(memOffset.Uint64(), getData(code, clampUint64(&codeOffset), length.Uint64()))
+		return nil
+
+	case CALLDATALOAD:
+		offset, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		var value uint256.Int
+		value.SetBytes32(getData(vm.Input, clampUint64(&offset), 32))
+		return vm.Push(&value)
+	case CALLDATASIZE:
+		return vm.Push(uint256.NewInt(uint64(len(vm.Input))))
+	case CODESIZE:
+		return vm.Push(uint256.NewInt(uint64(len(vm.Code))))
+	case CALLDATACOPY, CODECOPY:
+		memOffset, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		dataOffset, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		length, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		source := vm.Input
+		if opcode == CODECOPY {
+			source = vm.Code
+		}
+		// Bytes past the end of the source are copied as zeros
+		vm.Memory.Set(memOffset.Uint64(), getData(source, clampUint64(&dataOffset), length.Uint64()))
+		return nil
+
+	case COINBASE:
+		return vm.Push(new(uint256.Int).SetBytes20(vm.Context.Coinbase[:]))
+	case TIMESTAMP:
+		return vm.Push(uint256.NewInt(vm.Context.Timestamp))
+	case NUMBER:
+		return vm.Push(uint256.NewInt(vm.Context.BlockNumber))
+	case PREVRANDAO:
+		return vm.Push(new(uint256.Int).SetBytes32(vm.Context.PrevRandao[:]))
+	case GASLIMIT:
+		return vm.Push(uint256.NewInt(vm.Context.BlockGasLimit))
+	case CHAINID:
+		return vm.Push(orZero(vm.Context.ChainID))
+	case SELFBALANCE:
+		return vm.Push(vm.StateDB.GetBalance(vm.Address))
+	case BASEFEE:
+		return vm.Push(orZero(vm.Context.BaseFee))
+	case BLOBBASEFEE:
+		return vm.Push(orZero(vm.Context.BlobBaseFee))
+	case GAS:
+		// Gas left after paying for this instruction
+		return vm.Push(uint256.NewInt(vm.Gas))
+
+	case RETURNDATASIZE:
+		return vm.Push(uint256.NewInt(uint64(len(vm.ReturnData))))
+
+	case RETURNDATACOPY:
+		memOffset, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		dataOffset, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		length, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		// Unlike other copies, reading past the buffer is an error
+		end, overflow := new(uint256.Int).AddOverflow(&dataOffset, &length)
+		if overflow || !end.IsUint64() || end.Uint64() > uint64(len(vm.ReturnData)) {
+			return ErrReturnDataOutOfBounds
+		}
+		vm.Memory.Set(memOffset.Uint64(), vm.ReturnData[dataOffset.Uint64():end.Uint64()])
 		return nil
 
 	case STOP:
 		// Just stop execution
 		return nil
 
+	case LOG0, LOG1, LOG2, LOG3, LOG4:
+		return opLog(vm, int(opcode-LOG0))
+
+	case SELFDESTRUCT:
+		return opSelfdestruct(vm)
+
+	case CREATE, CREATE2:
+		return opCreate(vm, opcode)
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		return opCall(vm, opcode)
+
+	case RETURN, REVERT:
+		offset, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		size, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		vm.Output = vm.Memory.GetCopy(offset.Uint64(), size.Uint64())
+		if opcode == REVERT {
+			return ErrExecutionReverted
+		}
+		return nil
+
 	default:
-		return fmt.Errorf("unknown opcode: 0x%x", opcode)
+		return fmt.Errorf("%w: 0x%x", ErrInvalidOpcode, byte(opcode))
+	}
+}
+
+// opLog runs LOG0 to LOG4: it records an event with n topics from the
+// stack and data from memory. The event is dropped if the frame, or any
+// frame calling it, fails.
+func opLog(vm *VM, n int) error {
+	if vm.ReadOnly {
+		return ErrWriteProtection
+	}
+	offset, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	size, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	topics := make([]common.Hash, n)
+	for i := range topics {
+		topic, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		topics[i] = topic.Bytes32()
+	}
+	log := &Log{
+		Address: vm.Address,
+		Topics:  topics,
+		Data:    vm.Memory.GetCopy(offset.Uint64(), size.Uint64()),
+	}
+	vm.StateDB.AddLog(log)
+	if tracer := vm.evm.Tracer; tracer != nil {
+		tracer.OnLog(log)
+	}
+	return nil
+}
+
+// opSelfdestruct runs SELFDESTRUCT: it sends the balance of the account to
+// the beneficiary on the stack and destroys the account at the end of the
+// transaction. Since Cancun (EIP-6780) the account is only destroyed if the
+// same transaction created it; otherwise only the balance moves.
+func opSelfdestruct(vm *VM) error {
+	if vm.ReadOnly {
+		return ErrWriteProtection
+	}
+	top, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	beneficiary := common.Address(top.Bytes20())
+	balance := vm.StateDB.GetBalance(vm.Address)
+
+	if vm.GasTable.Fork >= Cancun && !vm.StateDB.CreatedInTransaction(vm.Address) {
+		if beneficiary != vm.Address && !balance.IsZero() {
+			vm.StateDB.SubBalance(vm.Address, balance)
+			vm.StateDB.AddBalance(beneficiary, balance)
+		}
+		return nil
+	}
+	// Sending the balance to the account itself burns it
+	if !balance.IsZero() {
+		vm.StateDB.AddBalance(beneficiary, balance)
+	}
+	vm.StateDB.SelfDestruct(vm.Address)
+	return nil
+}
+
+// opCreate runs CREATE or CREATE2: it deploys a contract from init code in
+// memory and pushes its address, or 0 if the creation failed. The new
+// contract gets all but 1/64 of the remaining gas (EIP-150).
+func opCreate(vm *VM, opcode OpCode) error {
+	if vm.ReadOnly {
+		return ErrWriteProtection
+	}
+	value, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	offset, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	size, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	initCode := vm.Memory.GetCopy(offset.Uint64(), size.Uint64())
+
+	var address common.Address
+	if opcode == CREATE2 {
+		salt, err := vm.Pop()
+		if err != nil {
+			return err
+		}
+		address = CreateAddress2(vm.Address, salt.Bytes32(), initCode)
+	} else {
+		address = CreateAddress(vm.Address, vm.StateDB.GetNonce(vm.Address))
+	}
+
+	gas := vm.Gas
+	if vm.GasTable.Fork >= TangerineWhistle {
+		gas -= gas / 64
+	}
+	vm.Gas -= gas
+
+	ret, returnGas, err := vm.evm.create(opcode, vm.Address, initCode, gas, &value, address, vm.Depth+1, vm.GasTable)
+	result := new(uint256.Int)
+	if err == nil {
+		result.SetBytes20(address[:])
+	}
+	vm.Gas += returnGas
+	// Only a reverted init code leaves return data
+	vm.ReturnData = nil
+	if errors.Is(err, ErrExecutionReverted) {
+		vm.ReturnData = ret
+	}
+	return vm.Push(result)
+}
+
+// opCall runs one of the CALL instructions: it pops the callee and the
+// memory ranges of input and output, runs the callee in a new frame and
+// pushes 1 if it succeeded and 0 otherwise. A failing callee does not fail
+// the caller.
+func opCall(vm *VM, opcode OpCode) error {
+	// The gas requested is on top of the stack, but the callee gets what
+	// the dynamic gas calculation settled on
+	if _, err := vm.Pop(); err != nil {
+		return err
+	}
+	addr, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	var value uint256.Int
+	if opcode == CALL || opcode == CALLCODE {
+		if value, err = vm.Pop(); err != nil {
+			return err
+		}
+	}
+	inOffset, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	inSize, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	retOffset, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	retSize, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+
+	gas := vm.callGasTemp
+	if !value.IsZero() {
+		if opcode == CALL && vm.ReadOnly {
+			return ErrWriteProtection
+		}
+		// The callee gets a stipend on top of the gas passed on
+		gas += gasCallStipend
+	}
+
+	target := common.Address(addr.Bytes20())
+	msg := message{
+		kind:        opcode,
+		caller:      vm.Address,
+		address:     target,
+		codeAddress: target,
+		value:       &value,
+		transfer:    true,
+		input:       vm.Memory.GetCopy(inOffset.Uint64(), inSize.Uint64()),
+		gas:         gas,
+		depth:       vm.Depth + 1,
+		readOnly:    vm.ReadOnly,
+	}
+	switch opcode {
+	case CALLCODE:
+		// Run the callee's code on this account's storage and balance
+		msg.address = vm.Address
+	case DELEGATECALL:
+		// Also keep the caller and value of this frame
+		msg.address = vm.Address
+		msg.caller = vm.Caller
+		msg.value = vm.Value
+		msg.transfer = false
+	case STATICCALL:
+		msg.readOnly = true
+		msg.transfer = false
+	}
+
+	ret, returnGas, err := vm.evm.call(msg, vm.GasTable)
+	success := new(uint256.Int)
+	if err == nil {
+		success.SetOne()
+	}
+	if err == nil || errors.Is(err, ErrExecutionReverted) {
+		vm.Memory.Set(retOffset.Uint64(), ret[:min(uint64(len(ret)), retSize.Uint64())])
+	}
+	vm.Gas += returnGas
+	vm.ReturnData = ret
+	return vm.Push(success)
+}
+
+// unaryOp replaces the top stack item with fn applied to it
+func unaryOp(vm *VM, fn func(x *uint256.Int)) error {
+	x, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	fn(&x)
+	return vm.Push(&x)
+}
+
+// binaryOp pops x (the top item) and y, and pushes x after fn has
+// written the result into it
+func binaryOp(vm *VM, fn func(x, y *uint256.Int)) error {
+	x, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	y, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	fn(&x, &y)
+	return vm.Push(&x)
+}
+
+// ternaryOp pops x, y and z, and pushes x after fn has written the result
+// into it
+func ternaryOp(vm *VM, fn func(x, y, z *uint256.Int)) error {
+	x, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	y, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	z, err := vm.Pop()
+	if err != nil {
+		return err
+	}
+	fn(&x, &y, &z)
+	return vm.Push(&x)
+}
+
+// getData returns size bytes of data starting at start, padded with zeros
+// where the range runs past the end of data
+func getData(data []byte, start, size uint64) []byte {
+	length := uint64(len(data))
+	if start > length {
+		start = length
+	}
+	end := start + size
+	if end > length || end < start {
+		end = length
+	}
+	padded := make([]byte, size)
+	copy(padded, data[start:end])
+	return padded
+}
+
+// clampUint64 returns x as a uint64, saturating values that do not fit
+func clampUint64(x *uint256.Int) uint64 {
+	if !x.IsUint64() {
+		return ^uint64(0)
+	}
+	return x.Uint64()
+}
+
+// setBool sets x to 1 if cond holds and to 0 otherwise
+func setBool(x *uint256.Int, cond bool) {
+	if cond {
+		x.SetOne()
+	} else {
+		x.Clear()
 	}
 }
@@ -0,0 +1,506 @@
+package vm
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/big"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/blake2b"
+	"github.com/ethereum/go-ethereum/crypto/bn256"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// PrecompiledContract is a contract implemented natively rather than in
+// bytecode. It lives at a fixed low address and is reached with the call
+// instructions like any other contract.
+type PrecompiledContract interface {
+	// RequiredGas returns the gas needed to run the contract on input
+	RequiredGas(input []byte) uint64
+	// Run computes the output for input. An error fails the call and uses
+	// up all the gas given to it.
+	Run(input []byte) ([]byte, error)
+}
+
+// Precompiled contracts by the hardfork that introduced or repriced them
+var (
+	precompilesFrontier = map[common.Address]PrecompiledContract{
+		common.BytesToAddress([]byte{0x01}): &ecrecover{},
+		common.BytesToAddress([]byte{0x02}): &sha256hash{},
+		common.BytesToAddress([]byte{0x03}): &ripemd160hash{},
+		common.BytesToAddress([]byte{0x04}): &dataCopy{},
+	}
+
+	// EIP-198 and EIP-196/197
+	precompilesByzantium = with(precompilesFrontier, map[common.Address]PrecompiledContract{
+		common.BytesToAddress([]byte{0x05}): &bigModExp{},
+		common.BytesToAddress([]byte{0x06}): &bn256Add{gas: gasBn256AddByzantium},
+		common.BytesToAddress([]byte{0x07}): &bn256ScalarMul{gas: gasBn256ScalarMulByzantium},
+		common.BytesToAddress([]byte{0x08}): &bn256Pairing{baseGas: gasBn256PairingBaseByzantium, pointGas: gasBn256PairingPerPointByzantium},
+	})
+
+	// EIP-1108: cheaper bn256, and EIP-152: BLAKE2 compression
+	precompilesIstanbul = with(precompilesByzantium, map[common.Address]PrecompiledContract{
+		common.BytesToAddress([]byte{0x06}): &bn256Add{gas: gasBn256AddIstanbul},
+		common.BytesToAddress([]byte{0x07}): &bn256ScalarMul{gas: gasBn256ScalarMulIstanbul},
+		common.BytesToAddress([]byte{0x08}): &bn256Pairing{baseGas: gasBn256PairingBaseIstanbul, pointGas: gasBn256PairingPerPointIstanbul},
+		common.BytesToAddress([]byte{0x09}): &blake2F{},
+	})
+
+	// EIP-2565: cheaper modexp
+	precompilesBerlin = with(precompilesIstanbul, map[common.Address]PrecompiledContract{
+		common.BytesToAddress([]byte{0x05}): &bigModExp{eip2565: true},
+	})
+
+	// EIP-4844: KZG point evaluation
+	precompilesCancun = with(precompilesBerlin, map[common.Address]PrecompiledContract{
+		common.BytesToAddress([]byte{0x0a}): &kzgPointEvaluation{},
+	})
+)
+
+// with returns a copy of base with the contracts of changes added or
+// replaced
+func with(base, changes map[common.Address]PrecompiledContract) map[common.Address]PrecompiledContract {
+	merged := maps.Clone(base)
+	maps.Copy(merged, changes)
+	return merged
+}
+
+// activePrecompiles returns the precompiled contracts of a hardfork
+func activePrecompiles(fork Fork) map[common.Address]PrecompiledContract {
+	switch {
+	case fork >= Cancun:
+		return precompilesCancun
+	case fork >= Berlin:
+		return precompilesBerlin
+	case fork >= Istanbul:
+		return precompilesIstanbul
+	case fork >= Byzantium:
+		return precompilesByzantium
+	default:
+		return precompilesFrontier
+	}
+}
+
+// ActivePrecompiles returns the addresses of the precompiled contracts of a
+// hardfork, in ascending order
+func ActivePrecompiles(fork Fork) []common.Address {
+	addrs := slices.Collect(maps.Keys(activePrecompiles(fork)))
+	slices.SortFunc(addrs, func(a, b common.Address) int { return a.Cmp(b) })
+	return addrs
+}
+
+// runPrecompile charges the gas p requires from gas and runs it, returning
+// the output and the gas left
+func runPrecompile(p PrecompiledContract, input []byte, gas uint64) ([]byte, uint64, error) {
+	cost := p.RequiredGas(input)
+	if gas < cost {
+		return nil, 0, ErrOutOfGas
+	}
+	output, err := p.Run(input)
+	return output, gas - cost, err
+}
+
+// wordGas returns base plus perWord for every 32-byte word of input
+func wordGas(input []byte, base, perWord uint64) uint64 {
+	return toWordSize(uint64(len(input)))*perWord + base
+}
+
+// ecrecover (0x01) recovers the address that signed a hash. Its input is
+// the hash, v, r and s, each a 32-byte word. Invalid signatures give empty
+// output rather than an error.
+type ecrecover struct{}
+
+func (c *ecrecover) RequiredGas(input []byte) uint64 {
+	return gasEcrecover
+}
+
+func (c *ecrecover) Run(input []byte) ([]byte, error) {
+	input = common.RightPadBytes(input, 128)
+	r := new(big.Int).SetBytes(input[64:96])
+	s := new(big.Int).SetBytes(input[96:128])
+	v := input[63] - 27
+
+	// v is a word, so all bytes but the last must be zero
+	if !allZero(input[32:63]) || !crypto.ValidateSignatureValues(v, r, s, false) {
+		return nil, nil
+	}
+	// Signatures are r, s, v in that order for the library
+	sig := make([]byte, 65)
+	copy(sig, input[64:128])
+	sig[64] = v
+	pubKey, err := crypto.Ecrecover(input[:32], sig)
+	if err != nil {
+		return nil, nil
+	}
+	// The address is the last 20 bytes of the hash of the key, without its
+	// 0x04 prefix
+	return common.LeftPadBytes(crypto.Keccak256(pubKey[1:])[12:], 32), nil
+}
+
+// sha256hash (0x02) returns the SHA-256 hash of its input
+type sha256hash struct{}
+
+func (c *sha256hash) RequiredGas(input []byte) uint64 {
+	return wordGas(input, gasSha256Base, gasSha256Word)
+}
+
+func (c *sha256hash) Run(input []byte) ([]byte, error) {
+	h := sha256.Sum256(input)
+	return h[:], nil
+}
+
+// ripemd160hash (0x03) returns the RIPEMD-160 hash of its input, padded to a
+// word
+type ripemd160hash struct{}
+
+func (c *ripemd160hash) RequiredGas(input []byte) uint64 {
+	return wordGas(input, gasRipemd160Base, gasRipemd160Word)
+}
+
+func (c *ripemd160hash) Run(input []byte) ([]byte, error) {
+	h := ripemd160.New()
+	h.Write(input)
+	return common.LeftPadBytes(h.Sum(nil), 32), nil
+}
+
+// dataCopy (0x04) returns its input unchanged
+type dataCopy struct{}
+
+func (c *dataCopy) RequiredGas(input []byte) uint64 {
+	return wordGas(input, gasIdentityBase, gasIdentityWord)
+}
+
+func (c *dataCopy) Run(input []byte) ([]byte, error) {
+	return common.CopyBytes(input), nil
+}
+
+// bigModExp (0x05) computes base**exp % mod on arbitrarily long numbers.
+// Its input is the lengths of base, exp and mod as words, followed by the
+// three numbers. It is priced by EIP-198, or by EIP-2565 since Berlin.
+type bigModExp struct {
+	eip2565 bool
+}
+
+// modExpLengths reads the lengths at the head of a modexp input and returns
+// them with the rest of the input
+func modExpLengths(input []byte) (baseLen, expLen, modLen *big.Int, rest []byte) {
+	baseLen = new(big.Int).SetBytes(getData(input, 0, 32))
+	expLen = new(big.Int).SetBytes(getData(input, 32, 32))
+	modLen = new(big.Int).SetBytes(getData(input, 64, 32))
+	if len(input) > 96 {
+		rest = input[96:]
+	}
+	return baseLen, expLen, modLen, rest
+}
+
+func (c *bigModExp) RequiredGas(input []byte) uint64 {
+	baseLen, expLen, modLen, input := modExpLengths(input)
+
+	// The adjusted exponent length is the position of the highest set bit
+	// in the first word of the exponent, plus 8 per byte beyond that word
+	expHead := new(big.Int)
+	if big.NewInt(int64(len(input))).Cmp(baseLen) > 0 {
+		headLen := uint64(32)
+		if expLen.Cmp(big.NewInt(32)) < 0 {
+			headLen = expLen.Uint64()
+		}
+		expHead.SetBytes(getData(input, baseLen.Uint64(), headLen))
+	}
+	adjExpLen := new(big.Int)
+	if expLen.Cmp(big.NewInt(32)) > 0 {
+		adjExpLen.Sub(expLen, big.NewInt(32))
+		adjExpLen.Lsh(adjExpLen, 3)
+	}
+	if bitLen := expHead.BitLen(); bitLen > 0 {
+		adjExpLen.Add(adjExpLen, big.NewInt(int64(bitLen-1)))
+	}
+	iterations := adjExpLen
+	if iterations.Sign() == 0 {
+		iterations = big.NewInt(1)
+	}
+
+	gas := new(big.Int).Set(modLen)
+	if baseLen.Cmp(modLen) > 0 {
+		gas.Set(baseLen)
+	}
+	if c.eip2565 {
+		// ceil(len / 8) ** 2 * iterations / 3, at least 200
+		gas.Add(gas, big.NewInt(7))
+		gas.Rsh(gas, 3)
+		gas.Mul(gas, gas)
+		gas.Mul(gas, iterations)
+		gas.Div(gas, big.NewInt(3))
+		if !gas.IsUint64() {
+			return math.MaxUint64
+		}
+		return max(gas.Uint64(), gasModExpMinEIP2565)
+	}
+	gas = modExpMultComplexity(gas)
+	gas.Mul(gas, iterations)
+	gas.Div(gas, big.NewInt(20))
+	if !gas.IsUint64() {
+		return math.MaxUint64
+	}
+	return gas.Uint64()
+}
+
+// modExpMultComplexity is the mult_complexity function of EIP-198
+func modExpMultComplexity(x *big.Int) *big.Int {
+	square := new(big.Int).Mul(x, x)
+	switch {
+	case x.Cmp(big.NewInt(64)) <= 0:
+		return square
+	case x.Cmp(big.NewInt(1024)) <= 0:
+		// x ** 2 / 4 + 96 * x - 3072
+		square.Rsh(square, 2)
+		linear := new(big.Int).Mul(big.NewInt(96), x)
+		return square.Add(square, linear.Sub(linear, big.NewInt(3072)))
+	default:
+		// x ** 2 / 16 + 480 * x - 199680
+		square.Rsh(square, 4)
+		linear := new(big.Int).Mul(big.NewInt(480), x)
+		return square.Add(square, linear.Sub(linear, big.NewInt(199680)))
+	}
+}
+
+func (c *bigModExp) Run(input []byte) ([]byte, error) {
+	baseLenBig, expLenBig, modLenBig, input := modExpLengths(input)
+	// Lengths too large for a uint64 cannot have been paid for
+	baseLen, expLen, modLen := baseLenBig.Uint64(), expLenBig.Uint64(), modLenBig.Uint64()
+	if baseLen == 0 && modLen == 0 {
+		return []byte{}, nil
+	}
+	base := new(big.Int).SetBytes(getData(input, 0, baseLen))
+	exp := new(big.Int).SetBytes(getData(input, baseLen, expLen))
+	mod := new(big.Int).SetBytes(getData(input, baseLen+expLen, modLen))
+
+	var v []byte
+	switch {
+	case mod.Sign() == 0:
+		// Modulo 0 is defined to give 0
+	case base.BitLen() == 1:
+		// base is 1, no need to exponentiate
+		v = base.Mod(base, mod).Bytes()
+	default:
+		v = base.Exp(base, exp, mod).Bytes()
+	}
+	return common.LeftPadBytes(v, int(modLen)), nil
+}
+
+// newCurvePoint decodes a point on the bn256 curve G1
+func newCurvePoint(blob []byte) (*bn256.G1, error) {
+	p := new(bn256.G1)
+	if _, err := p.Unmarshal(blob); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// newTwistPoint decodes a point on the bn256 twist G2
+func newTwistPoint(blob []byte) (*bn256.G2, error) {
+	p := new(bn256.G2)
+	if _, err := p.Unmarshal(blob); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// bn256Add (0x06) adds two points of the bn256 curve (EIP-196)
+type bn256Add struct {
+	gas uint64
+}
+
+func (c *bn256Add) RequiredGas(input []byte) uint64 {
+	return c.gas
+}
+
+func (c *bn256Add) Run(input []byte) ([]byte, error) {
+	x, err := newCurvePoint(getData(input, 0, 64))
+	if err != nil {
+		return nil, err
+	}
+	y, err := newCurvePoint(getData(input, 64, 64))
+	if err != nil {
+		return nil, err
+	}
+	return new(bn256.G1).Add(x, y).Marshal(), nil
+}
+
+// bn256ScalarMul (0x07) multiplies a point of the bn256 curve by a scalar
+// (EIP-196)
+type bn256ScalarMul struct {
+	gas uint64
+}
+
+func (c *bn256ScalarMul) RequiredGas(input []byte) uint64 {
+	return c.gas
+}
+
+func (c *bn256ScalarMul) Run(input []byte) ([]byte, error) {
+	p, err := newCurvePoint(getData(input, 0, 64))
+	if err != nil {
+		return nil, err
+	}
+	return new(bn256.G1).ScalarMult(p, new(big.Int).SetBytes(getData(input, 64, 32))).Marshal(), nil
+}
+
+// errBadPairingInput is returned for a pairing input that is not made of
+// whole 192-byte pairs
+var errBadPairingInput = errors.New("bad elliptic curve pairing size")
+
+// bn256Pairing (0x08) checks a pairing equation on the bn256 curve
+// (EIP-197). It returns 1 as a word if the product of the pairings of the
+// (G1, G2) pairs in its input is one, and 0 otherwise.
+type bn256Pairing struct {
+	baseGas, pointGas uint64
+}
+
+func (c *bn256Pairing) RequiredGas(input []byte) uint64 {
+	return c.baseGas + uint64(len(input)/192)*c.pointGas
+}
+
+func (c *bn256Pairing) Run(input []byte) ([]byte, error) {
+	if len(input)%192 != 0 {
+		return nil, errBadPairingInput
+	}
+	var (
+		g1s []*bn256.G1
+		g2s []*bn256.G2
+	)
+	for i := 0; i < len(input); i += 192 {
+		g1, err := newCurvePoint(input[i : i+64])
+		if err != nil {
+			return nil, err
+		}
+		g2, err := newTwistPoint(input[i+64 : i+192])
+		if err != nil {
+			return nil, err
+		}
+		g1s = append(g1s, g1)
+		g2s = append(g2s, g2)
+	}
+	result := make([]byte, 32)
+	if bn256.PairingCheck(g1s, g2s) {
+		result[31] = 1
+	}
+	return result, nil
+}
+
+// blake2FInputLength is the exact size of a BLAKE2 F input: rounds, state,
+// message, offset counters and final flag
+const blake2FInputLength = 213
+
+var (
+	errBlake2FInvalidInputLength = errors.New("invalid input length")
+	errBlake2FInvalidFinalFlag   = errors.New("invalid final flag")
+)
+
+// blake2F (0x09) runs the BLAKE2b compression function F (EIP-152), priced
+// at one gas per round
+type blake2F struct{}
+
+func (c *blake2F) RequiredGas(input []byte) uint64 {
+	// A malformed input fails in Run, so it costs nothing here
+	if len(input) != blake2FInputLength {
+		return 0
+	}
+	return uint64(binary.BigEndian.Uint32(input[0:4]))
+}
+
+func (c *blake2F) Run(input []byte) ([]byte, error) {
+	if len(input) != blake2FInputLength {
+		return nil, errBlake2FInvalidInputLength
+	}
+	if input[212] > 1 {
+		return nil, errBlake2FInvalidFinalFlag
+	}
+	var (
+		rounds = binary.BigEndian.Uint32(input[0:4])
+		final  = input[212] == 1
+		h      [8]uint64
+		m      [16]uint64
+		t      [2]uint64
+	)
+	for i := range h {
+		h[i] = binary.LittleEndian.Uint64(input[4+i*8:])
+	}
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(input[68+i*8:])
+	}
+	t[0] = binary.LittleEndian.Uint64(input[196:204])
+	t[1] = binary.LittleEndian.Uint64(input[204:212])
+
+	blake2b.F(&h, m, t, final, rounds)
+
+	output := make([]byte, 64)
+	for i, word := range h {
+		binary.LittleEndian.PutUint64(output[i*8:], word)
+	}
+	return output, nil
+}
+
+// pointEvaluationInputLength is the exact size of a point evaluation input:
+// versioned hash, point, claimed value, commitment and proof
+const pointEvaluationInputLength = 192
+
+var (
+	errPointEvaluationInputLength = errors.New("invalid input length")
+	errPointEvaluationVersion     = errors.New("mismatched versioned hash")
+	errPointEvaluationProof       = errors.New("error verifying kzg proof")
+
+	// pointEvaluationOutput is FIELD_ELEMENTS_PER_BLOB followed by
+	// BLS_MODULUS, returned when the proof is valid
+	pointEvaluationOutput = common.FromHex("000000000000000000000000000000000000000000000000000000000000100073eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001")
+)
+
+// kzgPointEvaluation (0x0a) verifies a KZG proof that a blob, identified
+// by the versioned hash of its commitment, evaluates to a claimed value at
+// a point (EIP-4844)
+type kzgPointEvaluation struct{}
+
+func (c *kzgPointEvaluation) RequiredGas(input []byte) uint64 {
+	return gasPointEvaluation
+}
+
+func (c *kzgPointEvaluation) Run(input []byte) ([]byte, error) {
+	if len(input) != pointEvaluationInputLength {
+		return nil, errPointEvaluationInputLength
+	}
+	var (
+		point      kzg4844.Point
+		claim      kzg4844.Claim
+		commitment kzg4844.Commitment
+		proof      kzg4844.Proof
+	)
+	versionedHash := common.BytesToHash(input[:32])
+	copy(point[:], input[32:64])
+	copy(claim[:], input[64:96])
+	copy(commitment[:], input[96:144])
+	copy(proof[:], input[144:192])
+
+	if kzg4844.CalcBlobHashV1(sha256.New(), &commitment) != versionedHash {
+		return nil, errPointEvaluationVersion
+	}
+	if err := kzg4844.VerifyProof(commitment, point, claim, proof); err != nil {
+		return nil, fmt.Errorf("%w: %v", errPointEvaluationProof, err)
+	}
+	return common.CopyBytes(pointEvaluationOutput), nil
+}
+
+// allZero reports whether every byte of b is zero
+func allZero(b []byte) bool {
+	for _, x := range b {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
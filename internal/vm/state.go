@@ -0,0 +1,83 @@
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// StateDB is the world state an execution reads and modifies: accounts
+// keyed by address, each with a balance, a nonce, code and storage. It
+// outlives the executions run against it, so a contract can be deployed
+// once and called many times.
+//
+// The state also carries what a transaction accumulates while it runs, the
+// gas refund counter, the EIP-2929 access list, transient storage and the
+// logs emitted, until Commit ends the transaction. All changes made within a
+// transaction, including those to the refund counter, access list,
+// transient storage and logs, can be rolled back to a snapshot.
+type StateDB interface {
+	// CreateAccount adds an empty account at addr, replacing any account
+	// already there
+	CreateAccount(addr common.Address)
+	// Exist reports whether an account exists at addr
+	Exist(addr common.Address) bool
+	// Empty reports whether the account at addr is missing or has no
+	// balance, nonce or code (EIP-161)
+	Empty(addr common.Address) bool
+
+	GetBalance(addr common.Address) *uint256.Int
+	AddBalance(addr common.Address, amount *uint256.Int)
+	SubBalance(addr common.Address, amount *uint256.Int)
+
+	GetNonce(addr common.Address) uint64
+	SetNonce(addr common.Address, nonce uint64)
+
+	// SelfDestruct clears the balance of the account at addr and deletes
+	// the account when the transaction is committed
+	SelfDestruct(addr common.Address)
+	HasSelfDestructed(addr common.Address) bool
+	// CreatedInTransaction reports whether the account at addr was created
+	// by CreateAccount since the last Commit
+	CreatedInTransaction(addr common.Address) bool
+
+	GetCode(addr common.Address) []byte
+	GetCodeHash(addr common.Address) common.Hash
+	GetCodeSize(addr common.Address) int
+	SetCode(addr common.Address, code []byte)
+
+	// GetState returns the current value of a storage slot
+	GetState(addr common.Address, key common.Hash) common.Hash
+	// GetCommittedState returns the value a storage slot held when the
+	// current transaction started
+	GetCommittedState(addr common.Address, key common.Hash) common.Hash
+	SetState(addr common.Address, key, value common.Hash)
+
+	// GetTransientState and SetTransientState access transient storage
+	// (EIP-1153), which is kept apart from storage and cleared by Commit
+	GetTransientState(addr common.Address, key common.Hash) common.Hash
+	SetTransientState(addr common.Address, key, value common.Hash)
+
+	AddRefund(gas uint64)
+	SubRefund(gas uint64)
+	GetRefund() uint64
+
+	AddressInAccessList(addr common.Address) bool
+	SlotInAccessList(addr common.Address, slot common.Hash) (addressOk, slotOk bool)
+	AddAddressToAccessList(addr common.Address)
+	AddSlotToAccessList(addr common.Address, slot common.Hash)
+
+	// AddLog records an event emitted by the current transaction, and Logs
+	// returns those emitted so far, oldest first
+	AddLog(log *Log)
+	Logs() []*Log
+
+	// Snapshot returns an identifier for the current state, and
+	// RevertToSnapshot undoes every change made since then
+	Snapshot() int
+	RevertToSnapshot(id int)
+
+	// Commit ends the current transaction: its storage writes become the
+	// committed state, the accounts it destroyed are deleted, and the
+	// refund counter, access list, transient storage and logs are reset
+	Commit()
+}
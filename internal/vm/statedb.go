@@ -0,0 +1,342 @@
+package vm
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+// emptyCodeHash is the Keccak-256 hash of empty code, the code hash of every
+// account without code
+var emptyCodeHash = crypto.Keccak256Hash(nil)
+
+// account is an entry of MemoryStateDB
+type account struct {
+	balance  uint256.Int
+	nonce    uint64
+	code     []byte
+	codeHash common.Hash
+	storage  map[common.Hash]common.Hash
+	// committed holds the storage as of the start of the transaction
+	committed map[common.Hash]common.Hash
+
+	// created is set on accounts created by the current transaction, and
+	// selfDestructed on those it destroyed
+	created        bool
+	selfDestructed bool
+}
+
+func newAccount() *account {
+	return &account{
+		codeHash:  emptyCodeHash,
+		storage:   make(map[common.Hash]common.Hash),
+		committed: make(map[common.Hash]common.Hash),
+	}
+}
+
+// setState writes a storage slot, deleting it when value is zero
+func (acc *account) setState(key, value common.Hash) {
+	if value == (common.Hash{}) {
+		delete(acc.storage, key)
+		return
+	}
+	acc.storage[key] = value
+}
+
+// MemoryStateDB is a StateDB kept entirely in memory. Every change is
+// journaled until Commit, so it can be undone with RevertToSnapshot.
+type MemoryStateDB struct {
+	accounts   map[common.Address]*account
+	refund     uint64
+	accessList *AccessList
+	logs       []*Log
+	journal    journal
+
+	// transient holds the transient storage of each account
+	transient map[common.Address]map[common.Hash]common.Hash
+}
+
+// NewMemoryStateDB creates a world state without any accounts
+func NewMemoryStateDB() *MemoryStateDB {
+	return &MemoryStateDB{
+		accounts:   make(map[common.Address]*account),
+		accessList: NewAccessList(),
+		transient:  make(map[common.Address]map[common.Hash]common.Hash),
+	}
+}
+
+// getOrNewAccount returns the account at addr, creating it if needed
+func (s *MemoryStateDB) getOrNewAccount(addr common.Address) *account {
+	acc, ok := s.accounts[addr]
+	if !ok {
+		acc = newAccount()
+		s.accounts[addr] = acc
+		s.journal.append(createAccountChange{addr: addr})
+	}
+	return acc
+}
+
+// CreateAccount adds an empty account at addr. Any balance the address
+// already held is kept, as on Ethereum where wei can be sent to an address
+// before a contract is deployed there.
+func (s *MemoryStateDB) CreateAccount(addr common.Address) {
+	acc := newAccount()
+	acc.created = true
+	prev, ok := s.accounts[addr]
+	if ok {
+		acc.balance = prev.balance
+	}
+	s.accounts[addr] = acc
+	s.journal.append(createAccountChange{addr: addr, prev: prev})
+}
+
+// Exist reports whether an account exists at addr
+func (s *MemoryStateDB) Exist(addr common.Address) bool {
+	_, ok := s.accounts[addr]
+	return ok
+}
+
+// Empty reports whether the account at addr is missing or has zero
+// balance, zero nonce and no code
+func (s *MemoryStateDB) Empty(addr common.Address) bool {
+	acc, ok := s.accounts[addr]
+	return !ok || (acc.balance.IsZero() && acc.nonce == 0 && acc.codeHash == emptyCodeHash)
+}
+
+// GetBalance returns a copy of the balance of addr
+func (s *MemoryStateDB) GetBalance(addr common.Address) *uint256.Int {
+	if acc, ok := s.accounts[addr]; ok {
+		return new(uint256.Int).Set(&acc.balance)
+	}
+	return new(uint256.Int)
+}
+
+// AddBalance credits amount to addr
+func (s *MemoryStateDB) AddBalance(addr common.Address, amount *uint256.Int) {
+	acc := s.getOrNewAccount(addr)
+	s.journal.append(balanceChange{addr: addr, prev: acc.balance})
+	acc.balance.Add(&acc.balance, amount)
+}
+
+// SubBalance debits amount from addr. Callers check the balance first.
+func (s *MemoryStateDB) SubBalance(addr common.Address, amount *uint256.Int) {
+	acc := s.getOrNewAccount(addr)
+	s.journal.append(balanceChange{addr: addr, prev: acc.balance})
+	acc.balance.Sub(&acc.balance, amount)
+}
+
+// GetNonce returns the nonce of addr
+func (s *MemoryStateDB) GetNonce(addr common.Address) uint64 {
+	if acc, ok := s.accounts[addr]; ok {
+		return acc.nonce
+	}
+	return 0
+}
+
+// SetNonce sets the nonce of addr
+func (s *MemoryStateDB) SetNonce(addr common.Address, nonce uint64) {
+	acc := s.getOrNewAccount(addr)
+	s.journal.append(nonceChange{addr: addr, prev: acc.nonce})
+	acc.nonce = nonce
+}
+
+// GetCode returns the code of addr
+func (s *MemoryStateDB) GetCode(addr common.Address) []byte {
+	if acc, ok := s.accounts[addr]; ok {
+		return acc.code
+	}
+	return nil
+}
+
+// GetCodeHash returns the hash of the code of addr, or the zero hash if no
+// account exists there
+func (s *MemoryStateDB) GetCodeHash(addr common.Address) common.Hash {
+	if acc, ok := s.accounts[addr]; ok {
+		return acc.codeHash
+	}
+	return common.Hash{}
+}
+
+// GetCodeSize returns the length of the code of addr
+func (s *MemoryStateDB) GetCodeSize(addr common.Address) int {
+	return len(s.GetCode(addr))
+}
+
+// SetCode sets the code of addr
+func (s *MemoryStateDB) SetCode(addr common.Address, code []byte) {
+	acc := s.getOrNewAccount(addr)
+	s.journal.append(codeChange{addr: addr, prevCode: acc.code, prevHash: acc.codeHash})
+	acc.code = code
+	acc.codeHash = crypto.Keccak256Hash(code)
+}
+
+// GetState returns the current value of a storage slot of addr
+func (s *MemoryStateDB) GetState(addr common.Address, key common.Hash) common.Hash {
+	if acc, ok := s.accounts[addr]; ok {
+		return acc.storage[key]
+	}
+	return common.Hash{}
+}
+
+// GetCommittedState returns the value a storage slot of addr held when the
+// current transaction started
+func (s *MemoryStateDB) GetCommittedState(addr common.Address, key common.Hash) common.Hash {
+	if acc, ok := s.accounts[addr]; ok {
+		return acc.committed[key]
+	}
+	return common.Hash{}
+}
+
+// SetState writes a storage slot of addr. Writing zero clears the slot.
+func (s *MemoryStateDB) SetState(addr common.Address, key, value common.Hash) {
+	acc := s.getOrNewAccount(addr)
+	s.journal.append(storageChange{addr: addr, key: key, prev: acc.storage[key]})
+	acc.setState(key, value)
+}
+
+// SelfDestruct destroys the account at addr: its balance is cleared at
+// once and the account is deleted when the transaction ends
+func (s *MemoryStateDB) SelfDestruct(addr common.Address) {
+	acc, ok := s.accounts[addr]
+	if !ok {
+		return
+	}
+	s.journal.append(selfDestructChange{addr: addr, prev: acc.selfDestructed, prevBalance: acc.balance})
+	acc.selfDestructed = true
+	acc.balance.Clear()
+}
+
+// HasSelfDestructed reports whether the account at addr was destroyed by
+// the current transaction
+func (s *MemoryStateDB) HasSelfDestructed(addr common.Address) bool {
+	acc, ok := s.accounts[addr]
+	return ok && acc.selfDestructed
+}
+
+// CreatedInTransaction reports whether the account at addr was created by
+// the current transaction
+func (s *MemoryStateDB) CreatedInTransaction(addr common.Address) bool {
+	acc, ok := s.accounts[addr]
+	return ok && acc.created
+}
+
+// GetTransientState returns the value of a transient storage slot of addr
+func (s *MemoryStateDB) GetTransientState(addr common.Address, key common.Hash) common.Hash {
+	return s.transient[addr][key]
+}
+
+// SetTransientState writes a transient storage slot of addr
+func (s *MemoryStateDB) SetTransientState(addr common.Address, key, value common.Hash) {
+	prev := s.GetTransientState(addr, key)
+	if prev == value {
+		return
+	}
+	s.journal.append(transientStorageChange{addr: addr, key: key, prev: prev})
+	s.setTransientState(addr, key, value)
+}
+
+// setTransientState writes a transient storage slot without journaling it
+func (s *MemoryStateDB) setTransientState(addr common.Address, key, value common.Hash) {
+	slots, ok := s.transient[addr]
+	if !ok {
+		slots = make(map[common.Hash]common.Hash)
+		s.transient[addr] = slots
+	}
+	if value == (common.Hash{}) {
+		delete(slots, key)
+		return
+	}
+	slots[key] = value
+}
+
+// AddRefund adds gas to the refund counter
+func (s *MemoryStateDB) AddRefund(gas uint64) {
+	s.journal.append(refundChange{prev: s.refund})
+	s.refund += gas
+}
+
+// SubRefund removes gas from the refund counter. Net gas metering never
+// takes back more than it granted, so going below zero is a bug.
+func (s *MemoryStateDB) SubRefund(gas uint64) {
+	if gas > s.refund {
+		panic(fmt.Sprintf("refund counter below zero (gas: %d > refund: %d)", gas, s.refund))
+	}
+	s.journal.append(refundChange{prev: s.refund})
+	s.refund -= gas
+}
+
+// GetRefund returns the refund counter
+func (s *MemoryStateDB) GetRefund() uint64 {
+	return s.refund
+}
+
+// AddressInAccessList reports whether addr is warm
+func (s *MemoryStateDB) AddressInAccessList(addr common.Address) bool {
+	return s.accessList.ContainsAddress(addr)
+}
+
+// SlotInAccessList reports whether addr and a slot within it are warm
+func (s *MemoryStateDB) SlotInAccessList(addr common.Address, slot common.Hash) (addressOk, slotOk bool) {
+	return s.accessList.Contains(addr, slot)
+}
+
+// AddAddressToAccessList warms addr
+func (s *MemoryStateDB) AddAddressToAccessList(addr common.Address) {
+	if s.accessList.AddAddress(addr) {
+		s.journal.append(accessListAddAccountChange{addr: addr})
+	}
+}
+
+// AddSlotToAccessList warms a slot and its account
+func (s *MemoryStateDB) AddSlotToAccessList(addr common.Address, slot common.Hash) {
+	addressAdded, slotAdded := s.accessList.AddSlot(addr, slot)
+	if addressAdded {
+		s.journal.append(accessListAddAccountChange{addr: addr})
+	}
+	if slotAdded {
+		s.journal.append(accessListAddSlotChange{addr: addr, slot: slot})
+	}
+}
+
+// AddLog records an event emitted by the current transaction
+func (s *MemoryStateDB) AddLog(log *Log) {
+	s.journal.append(addLogChange{})
+	s.logs = append(s.logs, log)
+}
+
+// Logs returns the events emitted by the current transaction
+func (s *MemoryStateDB) Logs() []*Log {
+	return s.logs
+}
+
+// Snapshot returns an identifier for the current state, to be passed to
+// RevertToSnapshot
+func (s *MemoryStateDB) Snapshot() int {
+	return s.journal.snapshot()
+}
+
+// RevertToSnapshot undoes every change made since the snapshot was taken.
+// Snapshots taken after it become invalid.
+func (s *MemoryStateDB) RevertToSnapshot(id int) {
+	s.journal.revertTo(s, id)
+}
+
+// Commit ends the current transaction and deletes the accounts it destroyed
+func (s *MemoryStateDB) Commit() {
+	for addr, acc := range s.accounts {
+		if acc.selfDestructed {
+			delete(s.accounts, addr)
+			continue
+		}
+		acc.created = false
+		acc.committed = maps.Clone(acc.storage)
+	}
+	s.refund = 0
+	s.accessList = NewAccessList()
+	s.transient = make(map[common.Address]map[common.Hash]common.Hash)
+	s.logs = nil
+	s.journal.reset()
+}
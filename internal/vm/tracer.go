@@ -0,0 +1,81 @@
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// Tracer receives the events of an execution as they happen. It is set on
+// the EVM running the execution; with no tracer set, none of the hooks
+// below are called and tracing costs nothing.
+//
+// Hooks must not modify the VM, stack, memory or state they are given, nor
+// keep references to them past the hook: copy what has to be kept.
+type Tracer interface {
+	// OnTxStart is called once the transaction has been validated, before
+	// any state changes. For a creation, to is the new contract's address.
+	OnTxStart(evm *EVM, from, to common.Address, create bool, input []byte, gas uint64, value *uint256.Int)
+	// OnTxEnd is called with the result of the transaction, before its
+	// state is committed
+	OnTxEnd(result ExecutionResult)
+
+	// OnEnter is called when a call frame starts, including the frame of
+	// the transaction at depth 0. typ is CALL, CALLCODE, DELEGATECALL,
+	// STATICCALL, CREATE or CREATE2, and from the account making the call.
+	OnEnter(depth int, typ OpCode, from, to common.Address, input []byte, gas uint64, value *uint256.Int)
+	// OnExit is called when the frame entered last ends, with its output,
+	// the gas it used and the error it failed with, if any
+	OnExit(depth int, output []byte, gasUsed uint64, err error)
+
+	// OnOpcode is called before each instruction runs, once its gas has
+	// been charged: gas is what was left before the instruction and cost
+	// what it was charged. The stack, memory and depth are those of scope;
+	// memory has not yet been expanded for the instruction.
+	OnOpcode(pc uint64, op OpCode, gas, cost uint64, scope *VM)
+	// OnFault is called when an instruction halts its frame exceptionally,
+	// whether or not OnOpcode was called for it. A REVERT is not a fault.
+	OnFault(pc uint64, op OpCode, gas, cost uint64, scope *VM, err error)
+
+	// OnStorageChange is called when SSTORE writes value to a slot that
+	// held prev
+	OnStorageChange(addr common.Address, key, prev, value common.Hash)
+	// OnLog is called when a LOG instruction emits log. The log is still
+	// reported if a frame then fails and drops it.
+	OnLog(log *Log)
+}
+
+// NoopTracer implements every Tracer hook by doing nothing. Tracers that
+// only need some of the hooks can embed it.
+type NoopTracer struct{}
+
+func (NoopTracer) OnTxStart(*EVM, common.Address, common.Address, bool, []byte, uint64, *uint256.Int) {
+}
+
+func (NoopTracer) OnTxEnd(ExecutionResult) {}
+
+func (NoopTracer) OnEnter(int, OpCode, common.Address, common.Address, []byte, uint64, *uint256.Int) {
+}
+
+func (NoopTracer) OnExit(int, []byte, uint64, error) {}
+
+func (NoopTracer) OnOpcode(uint64, OpCode, uint64, uint64, *VM) {}
+
+func (NoopTracer) OnFault(uint64, OpCode, uint64, uint64, *VM, error) {}
+
+func (NoopTracer) OnStorageChange(common.Address, common.Hash, common.Hash, common.Hash) {}
+
+func (NoopTracer) OnLog(*Log) {}
+
+// SetTracer attaches tracer to the VM and to the frames it calls into, or
+// detaches the current one if tracer is nil
+func (vm *VM) SetTracer(tracer Tracer) {
+	vm.evm.Tracer = tracer
+}
+
+// ExecuteWithTracer runs the contract like ExecuteWithContext, reporting
+// the execution to tracer
+func ExecuteWithTracer(ctx ExecutionContext, contract Contract, input []byte, tracer Tracer) ExecutionResult {
+	vm := NewVMWithContext(ctx)
+	vm.SetTracer(tracer)
+	return vm.Run(contract, input)
+}
@@ -1,5 +1,7 @@
 package solidity
 
+import "strconv"
+
 // Contract represents a Solidity contract with its name and functions.
 type Contract struct {
 	Name      string
@@ -37,7 +39,7 @@ type UintType struct {
 }
 
 func (u UintType) String() string {
-	return "uint" + string(u.Bits)
+	return "uint" + strconv.Itoa(u.Bits)
 }
 
 func (u UintType) Size() int {
@@ -75,7 +77,7 @@ func (b BytesType) String() string {
 	if b.Length == -1 {
 		return "bytes"
 	}
-	return "bytes" + string(b.Length)
+	return "bytes" + strconv.Itoa(b.Length)
 }
 
 func (b BytesType) Size() int {
@@ -7,6 +7,8 @@ import (
 	"log"
 	"math/big"
 	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 // CheckError is a utility function that checks for errors and logs them if they occur.
@@ -94,11 +96,23 @@ func FormatStorage(value []byte) string {
 	return "0x" + hex.EncodeToString(value)
 }
 
-// FunctionSelector computes the first 4 bytes of the keccak256 hash of a function signature
-// In a real implementation, this would use keccak256
+// Keccak256 returns the Keccak-256 hash of the concatenated data, the hash
+// function used throughout Ethereum
+func Keccak256(data ...[]byte) []byte {
+	return crypto.Keccak256(data...)
+}
+
+// FunctionSelector computes the first 4 bytes of the keccak256 hash of a function signature,
+// written without spaces or parameter names, e.g. "transfer(address,uint256)"
 func FunctionSelector(signature string) []byte {
-	// This is a placeholder - in reality you'd need to implement keccak256
-	return []byte{byte(len(signature)), byte(len(signature) >> 8), byte(len(signature) >> 16), byte(len(signature) >> 24)}
+	return Keccak256([]byte(signature))[:4]
+}
+
+// EventTopic computes the keccak256 hash of an event signature, e.g.
+// "Transfer(address,address,uint256)". It is the first topic of every log
+// emitted for the event, unless the event is anonymous.
+func EventTopic(signature string) [32]byte {
+	return [32]byte(Keccak256([]byte(signature)))
 }
 
 // PrintGasUsage prints information about gas usage
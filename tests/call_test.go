@@ -0,0 +1,235 @@
+package tests
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+var (
+	proxy  = common.HexToAddress("0x4000000000000000000000000000000000000004")
+	callee = common.HexToAddress("0x5000000000000000000000000000000000000005")
+)
+
+// callProgram calls target with op, passing all gas, value wei for CALL and
+// CALLCODE and no input. It returns the first 64 bytes of the callee's
+// output followed by the success flag.
+func callProgram(op vm.OpCode, target common.Address, value byte) []byte {
+	code := []byte{byte(vm.PUSH1), 0x40, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00}
+	if op == vm.CALL || op == vm.CALLCODE {
+		code = append(code, byte(vm.PUSH1), value)
+	}
+	code = append(code, byte(vm.PUSH20))
+	code = append(code, target[:]...)
+	return append(code, byte(vm.GAS), byte(op),
+		byte(vm.PUSH1), 0x40, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x60, byte(vm.PUSH1), 0x00, byte(vm.RETURN))
+}
+
+// storeOne is code that writes 1 to slot 0
+var storeOne = []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SSTORE)}
+
+func TestCallOpcodes(t *testing.T) {
+	// The callee returns CALLER and ADDRESS as it sees them
+	whoAmI := []byte{
+		byte(vm.CALLER), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.ADDRESS), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x40, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	}
+	address := func(addr common.Address) []byte { return common.LeftPadBytes(addr[:], 32) }
+
+	tests := []struct {
+		op             vm.OpCode
+		caller, runsAs common.Address
+	}{
+		{vm.CALL, proxy, callee},
+		{vm.CALLCODE, proxy, proxy},
+		{vm.DELEGATECALL, sender, proxy},
+		{vm.STATICCALL, proxy, callee},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.op.String(), func(t *testing.T) {
+			state := vm.NewMemoryStateDB()
+			state.SetCode(proxy, callProgram(tt.op, callee, 0))
+			state.SetCode(callee, whoAmI)
+
+			result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+			if !result.Success {
+				t.Fatalf("Call() failed: %v", result.Error)
+			}
+			expected := append(append(address(tt.caller), address(tt.runsAs)...), word(big.NewInt(1))...)
+			if !bytes.Equal(result.ReturnData, expected) {
+				t.Errorf("Call() returned %x, want %x", result.ReturnData, expected)
+			}
+		})
+	}
+}
+
+func TestStaticCallWriteProtection(t *testing.T) {
+	state := vm.NewMemoryStateDB()
+	state.SetCode(proxy, callProgram(vm.STATICCALL, callee, 0))
+	state.SetCode(callee, storeOne)
+
+	result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+	if !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+	if success := result.ReturnData[64:]; !bytes.Equal(success, make([]byte, 32)) {
+		t.Errorf("STATICCALL to a writing contract pushed %x, want 0", success)
+	}
+	if got := state.GetState(callee, common.Hash{}); got != (common.Hash{}) {
+		t.Errorf("static callee wrote slot 0 = %s", got)
+	}
+}
+
+func TestFailedSubCallIsIsolated(t *testing.T) {
+	// The callee writes, then reverts with the word 0x2a
+	reverter := append(append([]byte{}, storeOne...),
+		byte(vm.PUSH1), 0x2a, byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.REVERT))
+	// The caller writes too, and goes on after the failed call
+	caller := append(append([]byte{}, storeOne...), callProgram(vm.CALL, callee, 0)...)
+
+	state := vm.NewMemoryStateDB()
+	state.SetCode(proxy, caller)
+	state.SetCode(callee, reverter)
+
+	result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+	if !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+	expected := append(append(word(big.NewInt(0x2a)), make([]byte, 32)...), make([]byte, 32)...)
+	if !bytes.Equal(result.ReturnData, expected) {
+		t.Errorf("Call() returned %x, want the revert data and a 0 success flag", result.ReturnData)
+	}
+	if got := state.GetState(callee, common.Hash{}); got != (common.Hash{}) {
+		t.Errorf("reverted callee kept slot 0 = %s", got)
+	}
+	if got := state.GetState(proxy, common.Hash{}); got != common.BigToHash(big.NewInt(1)) {
+		t.Errorf("caller lost its own write: slot 0 = %s", got)
+	}
+}
+
+func TestCallValueTransfer(t *testing.T) {
+	state := vm.NewMemoryStateDB()
+	state.AddBalance(proxy, uint256.NewInt(100))
+	state.SetCode(proxy, callProgram(vm.CALL, callee, 60))
+	evm := vm.NewEVM(vm.DefaultContext(), state)
+
+	if result := evm.Call(sender, proxy, nil, vm.DefaultGasLimit, nil); !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+	if got := state.GetBalance(callee); got.Uint64() != 60 {
+		t.Errorf("callee balance = %s, want 60", got)
+	}
+
+	// Only 40 wei are left, so the second transfer fails without failing
+	// the caller
+	result := evm.Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+	if !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+	if success := result.ReturnData[64:]; !bytes.Equal(success, make([]byte, 32)) {
+		t.Errorf("CALL without enough balance pushed %x, want 0", success)
+	}
+	if got := state.GetBalance(proxy); got.Uint64() != 40 {
+		t.Errorf("proxy balance = %s, want 40", got)
+	}
+}
+
+func TestCallCreatesAccount(t *testing.T) {
+	// A CALL without value creates the missing account it is sent to until
+	// Spurious Dragon (EIP-158), and leaves it missing since
+	tests := []struct {
+		fork    vm.Fork
+		created bool
+	}{
+		{vm.Frontier, true},
+		{vm.Homestead, true},
+		{vm.SpuriousDragon, false},
+		{vm.Cancun, false},
+	}
+	// Calls with 10000 gas: before Tangerine Whistle, asking for all the
+	// gas left leaves none for creating the account
+	code := []byte{byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1), byte(vm.PUSH20)}
+	code = append(code, callee[:]...)
+	code = append(code, byte(vm.PUSH2), 0x27, 0x10, byte(vm.CALL))
+	for _, tt := range tests {
+		ctx := vm.DefaultContext()
+		ctx.Fork = tt.fork
+		state := vm.NewMemoryStateDB()
+		state.SetCode(proxy, code)
+
+		if result := vm.NewEVM(ctx, state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil); !result.Success {
+			t.Fatalf("%s: Call() failed: %v", tt.fork, result.Error)
+		}
+		if state.Exist(callee) != tt.created {
+			t.Errorf("%s: callee exists = %v, want %v", tt.fork, state.Exist(callee), tt.created)
+		}
+	}
+}
+
+func TestCallDepthLimit(t *testing.T) {
+	// Increment slot 0, then call itself with all but 100 gas
+	recursive := []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.SLOAD), byte(vm.PUSH1), 0x01, byte(vm.ADD), byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1),
+		byte(vm.ADDRESS), byte(vm.PUSH1), 0x64, byte(vm.GAS), byte(vm.SUB), byte(vm.CALL),
+	}
+
+	// Frontier has no 63/64 rule, so the gas does not run out before the
+	// depth limit is reached
+	ctx := vm.DefaultContext()
+	ctx.Fork = vm.Frontier
+	state := vm.NewMemoryStateDB()
+	state.SetCode(proxy, recursive)
+
+	result := vm.NewEVM(ctx, state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+	if !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+	// The transaction's frame plus 1024 nested calls
+	if got := state.GetState(proxy, common.Hash{}); got != common.BigToHash(big.NewInt(1025)) {
+		t.Errorf("recursion ran %s frames, want 1025", got.Big())
+	}
+}
+
+func TestCallGasForwarding(t *testing.T) {
+	// Asking for more gas than is left is capped to 63/64 of it since
+	// Tangerine Whistle, and runs out of gas before
+	askAll := func() []byte {
+		code := []byte{byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1), byte(vm.PUSH20)}
+		code = append(code, callee[:]...)
+		return append(code, byte(vm.PUSH32), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+			0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+			0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, byte(vm.CALL))
+	}()
+
+	for _, tt := range []struct {
+		fork    vm.Fork
+		success bool
+	}{
+		{vm.Homestead, false},
+		{vm.TangerineWhistle, true},
+		{vm.Cancun, true},
+	} {
+		t.Run(tt.fork.String(), func(t *testing.T) {
+			ctx := vm.DefaultContext()
+			ctx.Fork = tt.fork
+			state := vm.NewMemoryStateDB()
+			state.SetCode(proxy, askAll)
+			state.SetCode(callee, []byte{byte(vm.STOP)})
+
+			result := vm.NewEVM(ctx, state).Call(sender, proxy, nil, 100000, nil)
+			if result.Success != tt.success {
+				t.Errorf("Call() success = %v, want %v (error: %v)", result.Success, tt.success, result.Error)
+			}
+		})
+	}
+}
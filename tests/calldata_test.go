@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"solidity-vm-go/internal/compiler"
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// returnTop appends code that returns the top stack item as one word
+func returnTop(code ...byte) []byte {
+	return append(code,
+		byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN))
+}
+
+// copyAndReturn copies length bytes at offset with a copy opcode into memory
+// and returns them
+func copyAndReturn(op vm.OpCode, offset, length byte) []byte {
+	return []byte{
+		byte(vm.PUSH1), length, byte(vm.PUSH1), offset, byte(vm.PUSH1), 0x00, byte(op),
+		byte(vm.PUSH1), length, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	}
+}
+
+func TestCalldataOpcodes(t *testing.T) {
+	input := common.FromHex("0x000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f2021222324")
+
+	tests := []struct {
+		name     string
+		code     []byte
+		expected []byte
+	}{
+		{"CALLDATASIZE", returnTop(byte(vm.CALLDATASIZE)), word(big.NewInt(int64(len(input))))},
+		{"CALLDATALOAD", returnTop(byte(vm.PUSH1), 0x01, byte(vm.CALLDATALOAD)), input[1:33]},
+		{"CALLDATALOAD past end is zero padded", returnTop(byte(vm.PUSH1), 0x20, byte(vm.CALLDATALOAD)),
+			common.RightPadBytes(input[32:], 32)},
+		{"CALLDATALOAD huge offset", returnTop(byte(vm.PUSH8), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, byte(vm.CALLDATALOAD)),
+			make([]byte, 32)},
+		{"CALLDATACOPY", copyAndReturn(vm.CALLDATACOPY, 0x02, 0x04), input[2:6]},
+		{"CALLDATACOPY past end is zero padded", copyAndReturn(vm.CALLDATACOPY, 0x22, 0x05), []byte{0x22, 0x23, 0x24, 0x00, 0x00}},
+		{"CODESIZE", returnTop(byte(vm.CODESIZE)), word(big.NewInt(9))},
+		{"CODECOPY", copyAndReturn(vm.CODECOPY, 0x00, 0x03), []byte{byte(vm.PUSH1), 0x03, byte(vm.PUSH1)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := vm.Execute(vm.Contract{Bytecode: tt.code}, input)
+			if !result.Success {
+				t.Fatalf("Execute() failed: %v", result.Error)
+			}
+			if !bytes.Equal(result.ReturnData, tt.expected) {
+				t.Errorf("Execute() returned %x, want %x", result.ReturnData, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCalldataCopyGas(t *testing.T) {
+	// 3 PUSH1 (9) + CALLDATACOPY (3) + 2 words copied (6) + 2 words of
+	// memory (6)
+	code := []byte{byte(vm.PUSH1), 0x40, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.CALLDATACOPY)}
+	result := vm.Execute(vm.Contract{Bytecode: code}, nil)
+	if !result.Success {
+		t.Fatalf("Execute() failed: %v", result.Error)
+	}
+	if result.GasUsed != 24 {
+		t.Errorf("GasUsed = %d, want 24", result.GasUsed)
+	}
+}
+
+func TestContractDispatch(t *testing.T) {
+	// Running the creation code returns the runtime code
+	deployment := vm.Execute(compiler.Compile("").Contract, nil)
+	if !deployment.Success {
+		t.Fatalf("creation code failed: %v", deployment.Error)
+	}
+	contract := vm.Contract{Bytecode: deployment.ReturnData}
+
+	setValue := append(append([]byte{}, compiler.SetValueSelector...), word(big.NewInt(42))...)
+	if result := vm.Execute(contract, setValue); !result.Success {
+		t.Errorf("setValue(42) failed: %v", result.Error)
+	}
+
+	result := vm.Execute(contract, compiler.GetValueSelector)
+	if !result.Success {
+		t.Fatalf("getValue() failed: %v", result.Error)
+	}
+	if len(result.ReturnData) != 32 {
+		t.Errorf("getValue() returned %d bytes, want 32", len(result.ReturnData))
+	}
+
+	result = vm.Execute(contract, []byte{0xde, 0xad, 0xbe, 0xef})
+	if !result.Reverted {
+		t.Errorf("unknown selector did not revert: %+v", result)
+	}
+}
@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+func TestEnvironmentOpcodes(t *testing.T) {
+	ctx := vm.ExecutionContext{
+		Fork:          vm.Cancun,
+		Origin:        common.HexToAddress("0x1000000000000000000000000000000000000001"),
+		GasPrice:      uint256.NewInt(7),
+		Caller:        common.HexToAddress("0x2000000000000000000000000000000000000002"),
+		Address:       common.HexToAddress("0x3000000000000000000000000000000000000003"),
+		Value:         uint256.NewInt(1000),
+		GasLimit:      50000,
+		Coinbase:      common.HexToAddress("0x4000000000000000000000000000000000000004"),
+		BlockNumber:   17_000_000,
+		Timestamp:     1_700_000_000,
+		BlockGasLimit: 30_000_000,
+		ChainID:       uint256.NewInt(11155111),
+		BaseFee:       uint256.NewInt(25),
+		BlobBaseFee:   uint256.NewInt(3),
+		PrevRandao:    common.HexToHash("0xabcdef"),
+	}
+
+	tests := []struct {
+		op       vm.OpCode
+		expected []byte
+	}{
+		{vm.ADDRESS, common.LeftPadBytes(ctx.Address[:], 32)},
+		{vm.ORIGIN, common.LeftPadBytes(ctx.Origin[:], 32)},
+		{vm.CALLER, common.LeftPadBytes(ctx.Caller[:], 32)},
+		{vm.CALLVALUE, word(big.NewInt(1000))},
+		{vm.GASPRICE, word(big.NewInt(7))},
+		{vm.COINBASE, common.LeftPadBytes(ctx.Coinbase[:], 32)},
+		{vm.TIMESTAMP, word(big.NewInt(1_700_000_000))},
+		{vm.NUMBER, word(big.NewInt(17_000_000))},
+		{vm.PREVRANDAO, ctx.PrevRandao[:]},
+		{vm.GASLIMIT, word(big.NewInt(30_000_000))},
+		{vm.CHAINID, word(big.NewInt(11155111))},
+		{vm.SELFBALANCE, word(big.NewInt(123456))},
+		{vm.BASEFEE, word(big.NewInt(25))},
+		{vm.BLOBBASEFEE, word(big.NewInt(3))},
+		// 50000 minus the 2 gas GAS itself costs
+		{vm.GAS, word(big.NewInt(49998))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.op.String(), func(t *testing.T) {
+			machine := vm.NewVMWithContext(ctx)
+			machine.StateDB.AddBalance(ctx.Address, uint256.NewInt(123456))
+			top, err := machine.Execute([]byte{byte(tt.op)})
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			got := top.(*uint256.Int).Bytes32()
+			if !bytes.Equal(got[:], tt.expected) {
+				t.Errorf("Execute() left %x on the stack, want %x", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecutionContextGasLimit(t *testing.T) {
+	ctx := vm.DefaultContext()
+	ctx.GasLimit = 5
+
+	// PUSH1 1, PUSH1 2, ADD needs 9 gas
+	result := vm.ExecuteWithContext(ctx, vm.Contract{Bytecode: []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x02, byte(vm.ADD)}}, nil)
+	if result.Success {
+		t.Fatalf("ExecuteWithContext() succeeded with 5 gas, want out of gas")
+	}
+	if result.GasUsed != 5 {
+		t.Errorf("GasUsed = %d, want 5", result.GasUsed)
+	}
+}
@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"solidity-vm-go/internal/compiler"
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// factory deploys initCode (at most 32 bytes) with op, using salt 1 for
+// CREATE2, and returns the address it got
+func factory(op vm.OpCode, initCode []byte) []byte {
+	code := append([]byte{byte(vm.PUSH1) + byte(len(initCode)-1)}, initCode...)
+	code = append(code, byte(vm.PUSH1), 0x00, byte(vm.MSTORE))
+	if op == vm.CREATE2 {
+		code = append(code, byte(vm.PUSH1), 0x01)
+	}
+	return returnTop(append(code,
+		byte(vm.PUSH1), byte(len(initCode)), byte(vm.PUSH1), byte(32-len(initCode)), byte(vm.PUSH1), 0x00, byte(op))...)
+}
+
+// returnsZeros is init code deploying size zero bytes
+func returnsZeros(size uint16) []byte {
+	return []byte{byte(vm.PUSH2), byte(size >> 8), byte(size), byte(vm.PUSH1), 0x00, byte(vm.RETURN)}
+}
+
+func TestDeploy(t *testing.T) {
+	state := vm.NewMemoryStateDB()
+	evm := vm.NewEVM(vm.DefaultContext(), state)
+
+	address, result := evm.Deploy(sender, compiler.Compile("").Contract.Bytecode, vm.DefaultGasLimit, nil)
+	if !result.Success {
+		t.Fatalf("Deploy() failed: %v", result.Error)
+	}
+	if want := vm.CreateAddress(sender, 0); address != want {
+		t.Errorf("Deploy() address = %s, want %s", address, want)
+	}
+	if code := state.GetCode(address); len(code) == 0 || !bytes.Equal(code, result.ReturnData) {
+		t.Errorf("deployed code = %x, want the runtime code returned", code)
+	}
+	if state.GetNonce(sender) != 1 || state.GetNonce(address) != 1 {
+		t.Errorf("nonces = %d (sender), %d (contract), want 1 and 1", state.GetNonce(sender), state.GetNonce(address))
+	}
+	if got := state.GetState(address, common.BigToHash(common.Big3)); got != common.BigToHash(common.Big3) {
+		t.Errorf("constructor did not run: slot 3 = %s", got)
+	}
+}
+
+func TestCreateOpcodes(t *testing.T) {
+	// Deploys a contract whose code is a single STOP
+	initCode := []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.RETURN)}
+
+	tests := []struct {
+		op       vm.OpCode
+		expected common.Address
+	}{
+		{vm.CREATE, vm.CreateAddress(proxy, 0)},
+		{vm.CREATE2, vm.CreateAddress2(proxy, common.BigToHash(common.Big1), initCode)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.op.String(), func(t *testing.T) {
+			state := vm.NewMemoryStateDB()
+			state.SetCode(proxy, factory(tt.op, initCode))
+			evm := vm.NewEVM(vm.DefaultContext(), state)
+
+			result := evm.Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+			if !result.Success {
+				t.Fatalf("Call() failed: %v", result.Error)
+			}
+			if got := common.BytesToAddress(result.ReturnData); got != tt.expected {
+				t.Errorf("%s deployed at %s, want %s", tt.op, got, tt.expected)
+			}
+			if code := state.GetCode(tt.expected); !bytes.Equal(code, []byte{byte(vm.STOP)}) {
+				t.Errorf("deployed code = %x, want 00", code)
+			}
+			if nonce := state.GetNonce(proxy); nonce != 1 {
+				t.Errorf("factory nonce = %d, want 1", nonce)
+			}
+
+			// CREATE2 with the same salt and code collides with the first
+			// contract, CREATE uses the next nonce
+			result = evm.Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+			if !result.Success {
+				t.Fatalf("second Call() failed: %v", result.Error)
+			}
+			collided := bytes.Equal(result.ReturnData, make([]byte, 32))
+			if collided != (tt.op == vm.CREATE2) {
+				t.Errorf("second %s returned %x", tt.op, result.ReturnData)
+			}
+		})
+	}
+}
+
+func TestCreateCodeRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		fork     vm.Fork
+		initCode []byte
+		deployed bool
+	}{
+		{"code at the size limit", vm.Cancun, returnsZeros(24576), true},
+		{"code above the size limit", vm.Cancun, returnsZeros(24577), false},
+		{"no size limit before Spurious Dragon", vm.Homestead, returnsZeros(24577), true},
+		// PUSH1 0xef, PUSH1 0, MSTORE8, PUSH1 1, PUSH1 0, RETURN
+		{"code starting with 0xef", vm.London, []byte{0x60, 0xef, 0x60, 0x00, 0x53, 0x60, 0x01, 0x60, 0x00, 0xf3}, false},
+		{"0xef allowed before London", vm.Berlin, []byte{0x60, 0xef, 0x60, 0x00, 0x53, 0x60, 0x01, 0x60, 0x00, 0xf3}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := vm.DefaultContext()
+			ctx.Fork = tt.fork
+			state := vm.NewMemoryStateDB()
+			state.SetCode(proxy, factory(vm.CREATE, tt.initCode))
+
+			result := vm.NewEVM(ctx, state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+			if !result.Success {
+				t.Fatalf("Call() failed: %v", result.Error)
+			}
+			deployed := !bytes.Equal(result.ReturnData, make([]byte, 32))
+			if deployed != tt.deployed {
+				t.Errorf("CREATE returned %x, want deployed = %v", result.ReturnData, tt.deployed)
+			}
+		})
+	}
+}
+
+func TestInitCodeLimit(t *testing.T) {
+	evm := vm.NewEVM(vm.DefaultContext(), vm.NewMemoryStateDB())
+	_, result := evm.Deploy(sender, make([]byte, 49153), vm.DefaultGasLimit, nil)
+	if !errors.Is(result.Error, vm.ErrMaxInitCodeSizeExceeded) {
+		t.Errorf("Deploy() error = %v, want %v", result.Error, vm.ErrMaxInitCodeSizeExceeded)
+	}
+
+	// CREATE with 49153 bytes of init code fails the creating frame
+	code := []byte{byte(vm.PUSH2), 0xc0, 0x01, byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.CREATE)}
+	exec := vm.Execute(vm.Contract{Bytecode: code}, nil)
+	if !errors.Is(exec.Error, vm.ErrMaxInitCodeSizeExceeded) {
+		t.Errorf("Execute() error = %v, want %v", exec.Error, vm.ErrMaxInitCodeSizeExceeded)
+	}
+}
+
+func TestIntrinsicGas(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		isCreate bool
+		fork     vm.Fork
+		expected uint64
+	}{
+		{"empty call", nil, false, vm.Cancun, 21000},
+		{"call data", []byte{0, 1, 0, 2}, false, vm.Cancun, 21000 + 2*4 + 2*16},
+		{"call data before Istanbul", []byte{0, 1, 0, 2}, false, vm.Petersburg, 21000 + 2*4 + 2*68},
+		{"creation", nil, true, vm.Homestead, 53000},
+		{"creation in Frontier", nil, true, vm.Frontier, 21000},
+		{"init code words", make([]byte, 33), true, vm.Shanghai, 53000 + 33*4 + 2*2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gas, err := vm.IntrinsicGas(tt.data, tt.isCreate, tt.fork)
+			if err != nil {
+				t.Fatalf("IntrinsicGas() error = %v", err)
+			}
+			if gas != tt.expected {
+				t.Errorf("IntrinsicGas() = %d, want %d", gas, tt.expected)
+			}
+		})
+	}
+
+	result := vm.NewEVM(vm.DefaultContext(), vm.NewMemoryStateDB()).Call(sender, receiver, nil, 20999, nil)
+	if result.Error != vm.ErrIntrinsicGas {
+		t.Errorf("Call() with 20999 gas: error = %v, want %v", result.Error, vm.ErrIntrinsicGas)
+	}
+}
@@ -0,0 +1,204 @@
+package tests
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"solidity-vm-go/internal/debugger"
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Program counters in callProgram(vm.CALL, ...)
+const (
+	pcCall      = 32
+	pcAfterCall = 33
+)
+
+// storeAndReturn writes 1 to slot 0 and returns 42
+var storeAndReturn = append(append([]byte{}, storeOne...), returnTop(byte(vm.PUSH1), 0x2a)...)
+
+// startCall starts d on a transaction from sender to proxy, which calls
+// the callee, running calleeCode, with CALL
+func startCall(d *debugger.Debugger, calleeCode []byte) debugger.Stop {
+	state := vm.NewMemoryStateDB()
+	state.SetCode(proxy, callProgram(vm.CALL, callee, 0))
+	state.SetCode(callee, calleeCode)
+	evm := vm.NewEVM(vm.DefaultContext(), state)
+	return d.Start(func(tracer vm.Tracer) vm.ExecutionResult {
+		evm.Tracer = tracer
+		return evm.Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+	})
+}
+
+func TestDebuggerStepping(t *testing.T) {
+	d := debugger.New()
+	stop := startCall(d, storeAndReturn)
+	if stop.Reason != debugger.ReasonStep || stop.PC != 0 || stop.Op != vm.PUSH1 {
+		t.Fatalf("first stop = %+v, want PUSH1 at pc 0", stop)
+	}
+	for stop.PC != pcCall {
+		stop = d.Next()
+	}
+
+	// Stepping into the CALL stops at the first instruction of the callee
+	stop = d.Step()
+	if stop.Depth != 1 || stop.PC != 0 || d.Address() != callee {
+		t.Fatalf("step into CALL stopped at depth %d pc %d in %s", stop.Depth, stop.PC, d.Address())
+	}
+	if frames := d.Frames(); len(frames) != 2 || frames[1].Type != vm.CALL || frames[1].From != proxy {
+		t.Errorf("Frames() = %+v, want the transaction and the CALL", frames)
+	}
+
+	// Stepping out returns to the instruction after the CALL
+	stop = d.Out()
+	if stop.Depth != 0 || stop.PC != pcAfterCall {
+		t.Fatalf("step out stopped at depth %d pc %d, want depth 0 pc %d", stop.Depth, stop.PC, pcAfterCall)
+	}
+	if !bytes.Equal(d.ReturnData(), word(big.NewInt(42))) {
+		t.Errorf("ReturnData() = %x, want the callee's output", d.ReturnData())
+	}
+
+	stop = d.Continue()
+	if stop.Reason != debugger.ReasonFinished || !stop.Result.Success {
+		t.Fatalf("Continue() = %+v, want a successful end", stop)
+	}
+	if d.Running() {
+		t.Errorf("Running() after the end = true")
+	}
+}
+
+func TestDebuggerStepOver(t *testing.T) {
+	d := debugger.New()
+	stop := startCall(d, storeAndReturn)
+	for stop.Reason == debugger.ReasonStep {
+		if stop.Depth != 0 {
+			t.Fatalf("Next() stopped inside the callee at pc %d", stop.PC)
+		}
+		stop = d.Next()
+	}
+	if stop.Reason != debugger.ReasonFinished {
+		t.Fatalf("stop = %+v, want the end", stop)
+	}
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		breakpoint debugger.Breakpoint
+		depth      int
+		pc         uint64
+	}{
+		{"pc", debugger.Breakpoint{Kind: debugger.BreakPC, PC: pcAfterCall}, 0, pcAfterCall},
+		{"opcode", debugger.Breakpoint{Kind: debugger.BreakOpcode, Op: vm.MSTORE}, 1, 9},
+		{"storage slot", debugger.Breakpoint{Kind: debugger.BreakStorage}, 1, 4},
+		{"call depth", debugger.Breakpoint{Kind: debugger.BreakDepth, Depth: 1}, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := debugger.New()
+			b := d.AddBreakpoint(tt.breakpoint)
+			startCall(d, storeAndReturn)
+			stop := d.Continue()
+			if stop.Reason != debugger.ReasonBreakpoint || stop.Breakpoint.ID != b.ID {
+				t.Fatalf("Continue() = %+v, want breakpoint %d", stop, b.ID)
+			}
+			if stop.Depth != tt.depth || stop.PC != tt.pc {
+				t.Errorf("stopped at depth %d pc %d, want depth %d pc %d", stop.Depth, stop.PC, tt.depth, tt.pc)
+			}
+
+			if !d.RemoveBreakpoint(b.ID) || len(d.Breakpoints()) != 0 {
+				t.Errorf("RemoveBreakpoint(%d) left %v", b.ID, d.Breakpoints())
+			}
+			if stop := d.Continue(); stop.Reason != debugger.ReasonFinished {
+				t.Errorf("Continue() without breakpoints = %+v, want the end", stop)
+			}
+		})
+	}
+}
+
+func TestDebuggerInspection(t *testing.T) {
+	// Stop at the callee's RETURN, after slot 0 was written and 42 stored
+	// in memory
+	d := debugger.New()
+	d.AddBreakpoint(debugger.Breakpoint{Kind: debugger.BreakOpcode, Op: vm.RETURN})
+	startCall(d, storeAndReturn)
+	if stop := d.Continue(); stop.Depth != 1 {
+		t.Fatalf("stopped at depth %d, want the callee", stop.Depth)
+	}
+
+	stack := d.Stack()
+	if len(stack) != 2 || stack[1].Uint64() != 0 || stack[0].Uint64() != 32 {
+		t.Errorf("Stack() = %v, want [32 0]", stack)
+	}
+	if !bytes.Equal(d.Memory(), word(big.NewInt(42))) {
+		t.Errorf("Memory() = %x, want 42", d.Memory())
+	}
+	if got := d.Storage(common.Hash{}); got != common.BigToHash(big.NewInt(1)) {
+		t.Errorf("Storage(0) = %s, want 1", got)
+	}
+	d.Close()
+	if d.Stack() != nil {
+		t.Errorf("Stack() after Close() = %v, want nil", d.Stack())
+	}
+}
+
+func TestDebuggerFault(t *testing.T) {
+	d := debugger.New()
+	startCall(d, []byte{byte(vm.PUSH1), 0x01, byte(vm.JUMP)})
+	stop := d.Continue()
+	if stop.Reason != debugger.ReasonFault || stop.Op != vm.JUMP || stop.Depth != 1 {
+		t.Fatalf("Continue() = %+v, want a fault at the callee's JUMP", stop)
+	}
+	// The fault only fails the callee
+	if result := d.Close(); !result.Success {
+		t.Errorf("transaction failed: %v", result.Error)
+	}
+}
+
+func TestDebuggerPrompt(t *testing.T) {
+	commands := strings.Join([]string{
+		"break op sstore",
+		"break pc 0x21",
+		"breakpoints",
+		"continue",
+		"stack",
+		"where",
+		"",
+		"returndata",
+		"delete 2",
+		"memory 0x20 1",
+		"bogus",
+		"quit",
+	}, "\n")
+	var out bytes.Buffer
+	d := debugger.New()
+	if err := debugger.NewPrompt(d, strings.NewReader(commands), &out).Run(startCall(d, storeAndReturn)); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if d.Running() {
+		t.Errorf("execution still running after quit")
+	}
+
+	for _, expected := range []string{
+		"[0] 0x0000: PUSH1 0x40",
+		"breakpoint 1: op SSTORE",
+		"2: pc 0x21",
+		"breakpoint 1 (op SSTORE)\n[1] 0x0004: SSTORE",
+		" 0: 0x0\n 1: 0x1\n",
+		"#1 CALL " + proxy.Hex() + " -> " + callee.Hex(),
+		// The empty line repeats continue
+		"breakpoint 2 (pc 0x21)\n[0] 0x0021: PUSH1 0x40",
+		"0x" + common.Bytes2Hex(word(big.NewInt(42))) + "\n",
+		"0x0020: 00\n",
+		`unknown command "bogus"`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output does not contain %q:\n%s", expected, out.String())
+		}
+	}
+}
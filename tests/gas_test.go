@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestGasScheduleByFork(t *testing.T) {
+	// PUSH1 0, SLOAD, PUSH1 0, SLOAD: one cold and one repeated read
+	sloadTwice := []byte{byte(vm.PUSH1), 0x00, byte(vm.SLOAD), byte(vm.PUSH1), 0x00, byte(vm.SLOAD)}
+	// PUSH2 0x0100, PUSH1 2, EXP: a two-byte exponent
+	exp := []byte{byte(vm.PUSH2), 0x01, 0x00, byte(vm.PUSH1), 0x02, byte(vm.EXP)}
+	// PUSH1 0x80, PUSH1 0x40, MSTORE: the solc free memory pointer preamble
+	preamble := []byte{byte(vm.PUSH1), 0x80, byte(vm.PUSH1), 0x40, byte(vm.MSTORE)}
+	// PUSH2 0x0400, PUSH1 0, PUSH1 0, MCOPY: copy 32 words, growing memory to 1 KiB
+	mcopy := []byte{byte(vm.PUSH2), 0x04, 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.MCOPY)}
+
+	tests := []struct {
+		name     string
+		fork     vm.Fork
+		bytecode []byte
+		expected uint64
+	}{
+		{"arithmetic", vm.Frontier, []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x02, byte(vm.ADD), byte(vm.PUSH1), 0x03, byte(vm.MUL)}, 3 + 3 + 3 + 3 + 5},
+		{"SLOAD Frontier", vm.Frontier, sloadTwice, 3 + 50 + 3 + 50},
+		{"SLOAD TangerineWhistle", vm.TangerineWhistle, sloadTwice, 3 + 200 + 3 + 200},
+		{"SLOAD Istanbul", vm.Istanbul, sloadTwice, 3 + 800 + 3 + 800},
+		{"SLOAD Berlin cold then warm", vm.Berlin, sloadTwice, 3 + 2100 + 3 + 100},
+		{"EXP Frontier", vm.Frontier, exp, 3 + 3 + 10 + 2*10},
+		{"EXP SpuriousDragon", vm.SpuriousDragon, exp, 3 + 3 + 10 + 2*50},
+		{"memory expansion", vm.Cancun, preamble, 3 + 3 + 3 + 3*3},
+		{"MCOPY", vm.Cancun, mcopy, 3 + 3 + 3 + 3 + 32*3 + (32*3 + 32*32/512)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			machine := vm.NewVM()
+			machine.GasTable = vm.NewGasTable(tt.fork)
+			result := machine.Run(vm.Contract{Bytecode: tt.bytecode}, nil)
+			if !result.Success {
+				t.Fatalf("Run() error = %v", result.Error)
+			}
+			if result.GasUsed != tt.expected {
+				t.Errorf("GasUsed = %d, want %d", result.GasUsed, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOpcodeAvailabilityByFork(t *testing.T) {
+	tests := []struct {
+		name     string
+		fork     vm.Fork
+		bytecode []byte
+		valid    bool
+	}{
+		{"SHL before Constantinople", vm.Byzantium, []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x01, byte(vm.SHL)}, false},
+		{"SHL from Constantinople", vm.Constantinople, []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x01, byte(vm.SHL)}, true},
+		{"REVERT before Byzantium", vm.SpuriousDragon, []byte{byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.REVERT)}, false},
+		{"PUSH0 before Shanghai", vm.Paris, []byte{byte(vm.PUSH0)}, false},
+		{"PUSH0 from Shanghai", vm.Shanghai, []byte{byte(vm.PUSH0)}, true},
+		{"TLOAD before Cancun", vm.Shanghai, []byte{byte(vm.PUSH1), 0x00, byte(vm.TLOAD)}, false},
+		{"TLOAD from Cancun", vm.Cancun, []byte{byte(vm.PUSH1), 0x00, byte(vm.TLOAD)}, true},
+		{"INVALID", vm.Cancun, []byte{byte(vm.INVALID)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			machine := vm.NewVM()
+			machine.GasTable = vm.NewGasTable(tt.fork)
+			result := machine.Run(vm.Contract{Bytecode: tt.bytecode}, nil)
+			if tt.valid && !result.Success {
+				t.Errorf("Run() error = %v, want success", result.Error)
+			}
+			if !tt.valid && !errors.Is(result.Error, vm.ErrInvalidOpcode) {
+				t.Errorf("Run() error = %v, want %v", result.Error, vm.ErrInvalidOpcode)
+			}
+		})
+	}
+}
+
+func TestCustomGasTable(t *testing.T) {
+	table := vm.NewGasTable(vm.Cancun).Copy()
+	table.SetConstantGas(vm.ADD, 100)
+
+	machine := vm.NewVM()
+	machine.GasTable = table
+	result := machine.Run(vm.Contract{Bytecode: []byte{byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.ADD)}}, nil)
+	if !result.Success {
+		t.Fatalf("Run() error = %v", result.Error)
+	}
+	if result.GasUsed != 2+2+100 {
+		t.Errorf("GasUsed = %d, want %d", result.GasUsed, 2+2+100)
+	}
+	if got := vm.NewGasTable(vm.Cancun).ConstantGas(vm.ADD); got != 3 {
+		t.Errorf("default ADD cost changed to %d by editing a copy", got)
+	}
+}
+
+func TestSStoreGasAndRefunds(t *testing.T) {
+	one := common.BigToHash(big.NewInt(1))
+	// PUSH1 value, PUSH1 0, SSTORE
+	store := func(value byte) []byte {
+		return []byte{byte(vm.PUSH1), value, byte(vm.PUSH1), 0x00, byte(vm.SSTORE)}
+	}
+	setThenClear := append(store(1), store(0)...)
+
+	tests := []struct {
+		name       string
+		fork       vm.Fork
+		original   common.Hash
+		bytecode   []byte
+		gas        uint64
+		wantUsed   uint64
+		wantRefund uint64
+		wantErr    error
+	}{
+		{"Petersburg clear", vm.Petersburg, one, store(0), 100000, 6 + 5000, (6 + 5000) / 2, nil},
+		{"Constantinople noop", vm.Constantinople, one, store(1), 100000, 6 + 200, 0, nil},
+		{"Istanbul set then clear", vm.Istanbul, common.Hash{}, setThenClear, 100000, 12 + 20000 + 800, (12 + 20800) / 2, nil},
+		{"Istanbul sentry", vm.Istanbul, common.Hash{}, store(1), 6 + 2300, 0, 0, vm.ErrOutOfGas},
+		{"Berlin cold set", vm.Berlin, common.Hash{}, store(1), 100000, 6 + 2100 + 20000, 0, nil},
+		{"Berlin clear", vm.Berlin, one, store(0), 100000, 6 + 2100 + 2900, (6 + 5000) / 2, nil},
+		{"London clear", vm.London, one, store(0), 100000, 6 + 2100 + 2900, (6 + 5000) / 5, nil},
+		{"London set then clear", vm.London, common.Hash{}, setThenClear, 100000, 12 + 22100 + 100, (12 + 22200) / 5, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			machine := vm.NewVM()
+			machine.GasTable = vm.NewGasTable(tt.fork)
+			machine.Gas = tt.gas
+			machine.SetStorage(common.Hash{}, tt.original)
+			machine.StateDB.Commit()
+
+			result := machine.Run(vm.Contract{Bytecode: tt.bytecode}, nil)
+			if tt.wantErr != nil {
+				if !errors.Is(result.Error, tt.wantErr) {
+					t.Fatalf("Run() error = %v, want %v", result.Error, tt.wantErr)
+				}
+				return
+			}
+			if !result.Success {
+				t.Fatalf("Run() error = %v", result.Error)
+			}
+			if result.GasUsed != tt.wantUsed {
+				t.Errorf("GasUsed = %d, want %d", result.GasUsed, tt.wantUsed)
+			}
+			if result.GasRefund != tt.wantRefund {
+				t.Errorf("GasRefund = %d, want %d", result.GasRefund, tt.wantRefund)
+			}
+		})
+	}
+}
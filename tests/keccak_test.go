@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"solidity-vm-go/internal/vm"
+	"solidity-vm-go/pkg/utils"
+)
+
+// sha3Program stores value in the first memory word and returns the hash of
+// size bytes of memory from offset 0
+func sha3Program(value byte, size byte) []byte {
+	return returnTop(byte(vm.PUSH1), value, byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), size, byte(vm.PUSH1), 0x00, byte(vm.SHA3))
+}
+
+func TestSha3Opcode(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     []byte
+		expected []byte
+	}{
+		{"empty input", sha3Program(0x2a, 0), utils.Keccak256(nil)},
+		{"one word", sha3Program(0x2a, 32), utils.Keccak256(word(big.NewInt(42)))},
+		{"past the stored word", sha3Program(0x2a, 64), utils.Keccak256(word(big.NewInt(42)), make([]byte, 32))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := vm.Execute(vm.Contract{Bytecode: tt.code}, nil)
+			if !result.Success {
+				t.Fatalf("Execute() failed: %v", result.Error)
+			}
+			if !bytes.Equal(result.ReturnData, tt.expected) {
+				t.Errorf("SHA3 = %x, want %x", result.ReturnData, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSha3Gas(t *testing.T) {
+	// PUSH1 size, PUSH1 offset, SHA3 over memory that is already zero-sized
+	tests := []struct {
+		size     byte
+		expected uint64
+	}{
+		{0, 3 + 3 + 30},
+		// 30 + 6 per word, plus 3 per word of memory
+		{32, 3 + 3 + 30 + 6 + 3},
+		{33, 3 + 3 + 30 + 2*6 + 2*3},
+	}
+
+	for _, tt := range tests {
+		code := []byte{byte(vm.PUSH1), tt.size, byte(vm.PUSH1), 0x00, byte(vm.SHA3)}
+		result := vm.Execute(vm.Contract{Bytecode: code}, nil)
+		if !result.Success {
+			t.Fatalf("SHA3 of %d bytes failed: %v", tt.size, result.Error)
+		}
+		if result.GasUsed != tt.expected {
+			t.Errorf("SHA3 of %d bytes GasUsed = %d, want %d", tt.size, result.GasUsed, tt.expected)
+		}
+	}
+}
+
+func TestSelectorsAndTopics(t *testing.T) {
+	if got := hex.EncodeToString(utils.FunctionSelector("transfer(address,uint256)")); got != "a9059cbb" {
+		t.Errorf("FunctionSelector(transfer) = %s, want a9059cbb", got)
+	}
+	if got := hex.EncodeToString(utils.FunctionSelector("balanceOf(address)")); got != "70a08231" {
+		t.Errorf("FunctionSelector(balanceOf) = %s, want 70a08231", got)
+	}
+	topic := utils.EventTopic("Transfer(address,address,uint256)")
+	if got := hex.EncodeToString(topic[:]); got != "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef" {
+		t.Errorf("EventTopic(Transfer) = %s", got)
+	}
+}
+
+func TestMappingSlot(t *testing.T) {
+	// Solidity stores mapping[key] at keccak256(key . slot); compute it for
+	// key 7 in slot 1 the way compiled code does
+	code := returnTop(
+		byte(vm.PUSH1), 0x07, byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x40, byte(vm.PUSH1), 0x00, byte(vm.KECCAK256))
+	result := vm.Execute(vm.Contract{Bytecode: code}, nil)
+	if !result.Success {
+		t.Fatalf("Execute() failed: %v", result.Error)
+	}
+	expected := utils.Keccak256(word(big.NewInt(7)), word(big.NewInt(1)))
+	if !bytes.Equal(result.ReturnData, expected) {
+		t.Errorf("mapping slot = %x, want %x", result.ReturnData, expected)
+	}
+}
@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"solidity-vm-go/internal/compiler"
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// logProgram stores 0xabcd in the first memory word and logs that word with
+// n topics, numbered 1 to n
+func logProgram(n int) []byte {
+	code := []byte{byte(vm.PUSH2), 0xab, 0xcd, byte(vm.PUSH1), 0x00, byte(vm.MSTORE)}
+	for i := n; i >= 1; i-- {
+		code = append(code, byte(vm.PUSH1), byte(i))
+	}
+	return append(code, byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.LOG0)+byte(n))
+}
+
+func TestLogOpcodes(t *testing.T) {
+	for n := 0; n <= 4; n++ {
+		t.Run((vm.LOG0 + vm.OpCode(n)).String(), func(t *testing.T) {
+			state := vm.NewMemoryStateDB()
+			state.SetCode(receiver, logProgram(n))
+
+			result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, receiver, nil, vm.DefaultGasLimit, nil)
+			if !result.Success {
+				t.Fatalf("Call() failed: %v", result.Error)
+			}
+			if len(result.Logs) != 1 {
+				t.Fatalf("got %d logs, want 1", len(result.Logs))
+			}
+			log := result.Logs[0]
+			if log.Address != receiver {
+				t.Errorf("log address = %s, want %s", log.Address, receiver)
+			}
+			if !bytes.Equal(log.Data, word(big.NewInt(0xabcd))) {
+				t.Errorf("log data = %x, want 0xabcd", log.Data)
+			}
+			if len(log.Topics) != n {
+				t.Fatalf("got %d topics, want %d", len(log.Topics), n)
+			}
+			for i, topic := range log.Topics {
+				if want := common.BigToHash(big.NewInt(int64(i + 1))); topic != want {
+					t.Errorf("topic %d = %s, want %s", i, topic, want)
+				}
+			}
+			if !result.Bloom.Test(receiver[:]) {
+				t.Errorf("bloom does not contain the log address")
+			}
+			if len(state.Logs()) != 0 {
+				t.Errorf("logs survived the end of the transaction")
+			}
+		})
+	}
+}
+
+func TestLogGas(t *testing.T) {
+	// MSTORE setup (3+3+3 and 3 for memory), topic pushes, LOG pushes, then
+	// 375 per log and topic plus 8 per byte of data
+	for n := 0; n <= 4; n++ {
+		result := vm.Execute(vm.Contract{Bytecode: logProgram(n)}, nil)
+		if !result.Success {
+			t.Fatalf("LOG%d failed: %v", n, result.Error)
+		}
+		expected := uint64(3+3+3+3) + uint64(n)*3 + 3 + 3 + 375 + uint64(n)*375 + 32*8
+		if result.GasUsed != expected {
+			t.Errorf("LOG%d GasUsed = %d, want %d", n, result.GasUsed, expected)
+		}
+	}
+}
+
+func TestLogsDiscardedOnFailure(t *testing.T) {
+	reverting := append(logProgram(1), byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.REVERT))
+
+	t.Run("reverted transaction", func(t *testing.T) {
+		state := vm.NewMemoryStateDB()
+		state.SetCode(receiver, reverting)
+		result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, receiver, nil, vm.DefaultGasLimit, nil)
+		if !result.Reverted {
+			t.Fatalf("Call() did not revert: %v", result.Error)
+		}
+		if len(result.Logs) != 0 || result.Bloom != (vm.Bloom{}) {
+			t.Errorf("reverted call kept %d logs", len(result.Logs))
+		}
+	})
+
+	t.Run("reverted sub-call", func(t *testing.T) {
+		// The proxy logs, then calls a callee that logs and reverts
+		state := vm.NewMemoryStateDB()
+		state.SetCode(proxy, append(logProgram(2), callProgram(vm.CALL, callee, 0)...))
+		state.SetCode(callee, reverting)
+		result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+		if !result.Success {
+			t.Fatalf("Call() failed: %v", result.Error)
+		}
+		if len(result.Logs) != 1 || result.Logs[0].Address != proxy || len(result.Logs[0].Topics) != 2 {
+			t.Errorf("got logs %+v, want only the proxy's", result.Logs)
+		}
+	})
+
+	t.Run("static call", func(t *testing.T) {
+		state := vm.NewMemoryStateDB()
+		state.SetCode(proxy, callProgram(vm.STATICCALL, callee, 0))
+		state.SetCode(callee, logProgram(0))
+		result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+		if !result.Success {
+			t.Fatalf("Call() failed: %v", result.Error)
+		}
+		if success := result.ReturnData[64:]; !bytes.Equal(success, make([]byte, 32)) {
+			t.Errorf("STATICCALL of a logging callee succeeded")
+		}
+		if len(result.Logs) != 0 {
+			t.Errorf("got %d logs from a static call", len(result.Logs))
+		}
+	})
+}
+
+func TestLogsBloom(t *testing.T) {
+	topic := common.HexToHash("0xff")
+	bloom := vm.LogsBloom([]*vm.Log{{Address: receiver, Topics: []common.Hash{topic}}})
+	if !bloom.Test(receiver[:]) || !bloom.Test(topic[:]) {
+		t.Errorf("bloom is missing the address or topic")
+	}
+	if bloom.Test(other[:]) {
+		t.Errorf("bloom contains an address that was not logged")
+	}
+
+	var set int
+	for _, b := range bloom {
+		for ; b != 0; b &= b - 1 {
+			set++
+		}
+	}
+	if set == 0 || set > 6 {
+		t.Errorf("bloom has %d bits set, want 1 to 6", set)
+	}
+}
+
+func TestContractEmitsEvent(t *testing.T) {
+	evm := vm.NewEVM(vm.DefaultContext(), vm.NewMemoryStateDB())
+	contract, deployment := evm.Deploy(sender, compiler.Compile("").Contract.Bytecode, vm.DefaultGasLimit, nil)
+	if !deployment.Success {
+		t.Fatalf("Deploy() failed: %v", deployment.Error)
+	}
+
+	setValue := append(append([]byte{}, compiler.SetValueSelector...), word(big.NewInt(42))...)
+	result := evm.Call(sender, contract, setValue, vm.DefaultGasLimit, nil)
+	if !result.Success {
+		t.Fatalf("setValue(42) failed: %v", result.Error)
+	}
+	if len(result.Logs) != 1 {
+		t.Fatalf("got %d logs, want 1", len(result.Logs))
+	}
+	log := result.Logs[0]
+	if len(log.Topics) != 1 || log.Topics[0] != compiler.ValueChangedTopic {
+		t.Errorf("topics = %v, want the ValueChanged signature", log.Topics)
+	}
+	// ValueChanged(oldValue, newValue, changedBy); the constructor left 0
+	// in slot 0
+	expected := append(append(word(big.NewInt(0)), word(big.NewInt(42))...), common.LeftPadBytes(sender[:], 32)...)
+	if !bytes.Equal(log.Data, expected) {
+		t.Errorf("log data = %x, want %x", log.Data, expected)
+	}
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"solidity-vm-go/internal/vm"
+)
+
+func TestMemoryOpcodes(t *testing.T) {
+	huge := bytes.Repeat([]byte{0xff}, 32)
+
+	tests := []struct {
+		name     string
+		bytecode []byte
+		expected *big.Int
+	}{
+		{
+			name: "MSTORE then MLOAD",
+			// PUSH2 0xbeef, PUSH1 0x40, MSTORE, PUSH1 0x40, MLOAD
+			bytecode: []byte{byte(vm.PUSH2), 0xbe, 0xef, byte(vm.PUSH1), 0x40, byte(vm.MSTORE), byte(vm.PUSH1), 0x40, byte(vm.MLOAD)},
+			expected: big.NewInt(0xbeef),
+		},
+		{
+			name: "memory is zero initialized",
+			// PUSH2 0x1000, MLOAD
+			bytecode: []byte{byte(vm.PUSH2), 0x10, 0x00, byte(vm.MLOAD)},
+			expected: big.NewInt(0),
+		},
+		{
+			name: "MSTORE8 writes the low byte",
+			// PUSH2 0x1234, PUSH1 31, MSTORE8, PUSH1 0, MLOAD
+			bytecode: []byte{byte(vm.PUSH2), 0x12, 0x34, byte(vm.PUSH1), 0x1f, byte(vm.MSTORE8), byte(vm.PUSH1), 0x00, byte(vm.MLOAD)},
+			expected: big.NewInt(0x34),
+		},
+		{
+			name: "MSIZE grows in words",
+			// PUSH1 1, PUSH1 0x21, MSTORE8, MSIZE
+			bytecode: []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x21, byte(vm.MSTORE8), byte(vm.MSIZE)},
+			expected: big.NewInt(64),
+		},
+		{
+			name: "MLOAD expands memory",
+			// PUSH1 0x21, MLOAD, POP, MSIZE
+			bytecode: []byte{byte(vm.PUSH1), 0x21, byte(vm.MLOAD), byte(vm.POP), byte(vm.MSIZE)},
+			expected: big.NewInt(96),
+		},
+		{
+			name: "MCOPY with overlapping ranges",
+			// PUSH1 0xaa, PUSH1 0x1f, MSTORE8, PUSH1 32, PUSH1 0, PUSH1 1, MCOPY, PUSH1 1, MLOAD
+			bytecode: []byte{
+				byte(vm.PUSH1), 0xaa, byte(vm.PUSH1), 0x1f, byte(vm.MSTORE8),
+				byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x01, byte(vm.MCOPY),
+				byte(vm.PUSH1), 0x01, byte(vm.MLOAD),
+			},
+			expected: big.NewInt(0xaa),
+		},
+		{
+			name: "zero length MCOPY ignores offsets",
+			// PUSH1 0, PUSH32 huge, PUSH32 huge, MCOPY, MSIZE
+			bytecode: append([]byte{byte(vm.PUSH1), 0x00}, push32Then(huge, push32Then(huge, byte(vm.MCOPY), byte(vm.MSIZE))...)...),
+			expected: big.NewInt(0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top, err := stackTop(tt.bytecode)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if want := word(tt.expected); !bytes.Equal(top, want) {
+				t.Errorf("Execute() left %x on the stack, want %x", top, want)
+			}
+		})
+	}
+}
+
+func TestMemoryExpansionOutOfGas(t *testing.T) {
+	// MLOAD at a 4 GiB offset costs far more than the available gas
+	bytecode := []byte{byte(vm.PUSH5), 0x01, 0x00, 0x00, 0x00, 0x00, byte(vm.MLOAD)}
+	result := vm.Execute(vm.Contract{Bytecode: bytecode}, nil)
+	if result.Success || !errors.Is(result.Error, vm.ErrOutOfGas) {
+		t.Fatalf("Execute() error = %v, want %v", result.Error, vm.ErrOutOfGas)
+	}
+}
@@ -0,0 +1,235 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	"solidity-vm-go/internal/vm"
+	"solidity-vm-go/pkg/utils"
+)
+
+// word encodes a (possibly negative) integer as a two's-complement 256-bit word
+func word(x *big.Int) []byte {
+	if x.Sign() < 0 {
+		x = new(big.Int).Add(x, new(big.Int).Lsh(big.NewInt(1), 256))
+	}
+	return utils.PadLeft(x.Bytes(), 32)
+}
+
+// opProgram pushes args so that args[0] ends up on top of the stack, then
+// runs op
+func opProgram(op vm.OpCode, args ...*big.Int) []byte {
+	var code []byte
+	for i := len(args) - 1; i >= 0; i-- {
+		code = append(code, byte(vm.PUSH32))
+		code = append(code, word(args[i])...)
+	}
+	return append(code, byte(op), byte(vm.STOP))
+}
+
+func TestArithmeticAndComparisonOpcodes(t *testing.T) {
+	n := big.NewInt
+	maxUint := new(big.Int).Sub(new(big.Int).Lsh(n(1), 256), n(1))
+	minInt := new(big.Int).Neg(new(big.Int).Lsh(n(1), 255))
+
+	tests := []struct {
+		name     string
+		op       vm.OpCode
+		args     []*big.Int
+		expected *big.Int
+	}{
+		{"SDIV negative", vm.SDIV, []*big.Int{n(-8), n(2)}, n(-4)},
+		{"SDIV overflow", vm.SDIV, []*big.Int{minInt, n(-1)}, minInt},
+		{"SDIV by zero", vm.SDIV, []*big.Int{n(-8), n(0)}, n(0)},
+		{"MOD", vm.MOD, []*big.Int{n(10), n(3)}, n(1)},
+		{"MOD by zero", vm.MOD, []*big.Int{n(10), n(0)}, n(0)},
+		{"SMOD keeps dividend sign", vm.SMOD, []*big.Int{n(-8), n(3)}, n(-2)},
+		{"ADDMOD without overflow loss", vm.ADDMOD, []*big.Int{maxUint, n(2), n(2)}, n(1)},
+		{"MULMOD with zero modulus", vm.MULMOD, []*big.Int{n(3), n(4), n(0)}, n(0)},
+		{"EXP", vm.EXP, []*big.Int{n(2), n(255)}, new(big.Int).Lsh(n(1), 255)},
+		{"EXP wraps", vm.EXP, []*big.Int{n(2), n(256)}, n(0)},
+		{"SIGNEXTEND byte 0", vm.SIGNEXTEND, []*big.Int{n(0), n(0xff)}, n(-1)},
+		{"SIGNEXTEND positive", vm.SIGNEXTEND, []*big.Int{n(0), n(0x7f)}, n(0x7f)},
+		{"LT", vm.LT, []*big.Int{n(1), n(2)}, n(1)},
+		{"GT unsigned", vm.GT, []*big.Int{n(-1), n(2)}, n(1)},
+		{"SLT signed", vm.SLT, []*big.Int{n(-1), n(2)}, n(1)},
+		{"SGT signed", vm.SGT, []*big.Int{n(-1), n(2)}, n(0)},
+		{"EQ", vm.EQ, []*big.Int{n(7), n(7)}, n(1)},
+		{"ISZERO", vm.ISZERO, []*big.Int{n(0)}, n(1)},
+		{"AND", vm.AND, []*big.Int{n(0xf0), n(0x3c)}, n(0x30)},
+		{"OR", vm.OR, []*big.Int{n(0xf0), n(0x0f)}, n(0xff)},
+		{"XOR", vm.XOR, []*big.Int{n(0xff), n(0x0f)}, n(0xf0)},
+		{"NOT", vm.NOT, []*big.Int{n(0)}, maxUint},
+		{"BYTE", vm.BYTE, []*big.Int{n(31), n(0x1234)}, n(0x34)},
+		{"BYTE out of range", vm.BYTE, []*big.Int{n(32), n(0x1234)}, n(0)},
+		{"SHL", vm.SHL, []*big.Int{n(4), n(1)}, n(16)},
+		{"SHL beyond width", vm.SHL, []*big.Int{n(256), n(1)}, n(0)},
+		{"SHR", vm.SHR, []*big.Int{n(4), n(16)}, n(1)},
+		{"SAR negative", vm.SAR, []*big.Int{n(2), n(-16)}, n(-4)},
+		{"SAR saturates", vm.SAR, []*big.Int{n(300), n(-16)}, n(-1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top, err := stackTop(opProgram(tt.op, tt.args...))
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if want := word(tt.expected); !bytes.Equal(top, want) {
+				t.Errorf("Execute() left %x on the stack, want %x", top, want)
+			}
+		})
+	}
+}
+
+func TestStackManipulationOpcodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		bytecode []byte
+		expected []byte
+		wantErr  bool
+	}{
+		{
+			name:     "PUSH2",
+			bytecode: []byte{byte(vm.PUSH2), 0x12, 0x34, byte(vm.STOP)},
+			expected: word(big.NewInt(0x1234)),
+		},
+		{
+			name:     "PUSH0",
+			bytecode: []byte{byte(vm.PUSH1), 0x07, byte(vm.PUSH0), byte(vm.STOP)},
+			expected: word(big.NewInt(0)),
+		},
+		{
+			name:     "truncated push data is zero padded",
+			bytecode: []byte{byte(vm.PUSH3), 0x12},
+			expected: word(big.NewInt(0x120000)),
+		},
+		{
+			name:     "DUP2",
+			bytecode: []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x02, byte(vm.DUP2), byte(vm.STOP)},
+			expected: word(big.NewInt(1)),
+		},
+		{
+			name:     "SWAP1 then SUB",
+			bytecode: []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x03, byte(vm.SWAP1), byte(vm.SUB), byte(vm.STOP)},
+			expected: word(big.NewInt(-2)),
+		},
+		{
+			name:     "SWAP16 reaches the 17th item",
+			bytecode: append(bytes.Repeat([]byte{byte(vm.PUSH1), 0x01}, 16), byte(vm.PUSH1), 0x09, byte(vm.SWAP16), byte(vm.POP), byte(vm.DUP16), byte(vm.STOP)),
+			expected: word(big.NewInt(9)),
+		},
+		{
+			name:     "DUP3 underflow",
+			bytecode: []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x02, byte(vm.DUP3)},
+			wantErr:  true,
+		},
+		{
+			name:     "SWAP2 underflow",
+			bytecode: []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x02, byte(vm.SWAP2)},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top, err := stackTop(tt.bytecode)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Execute() succeeded, want stack underflow")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if !bytes.Equal(top, tt.expected) {
+				t.Errorf("Execute() left %x on the stack, want %x", top, tt.expected)
+			}
+		})
+	}
+}
+
+func TestJumpDestinationValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		bytecode []byte
+		expected []byte
+		wantErr  error
+	}{
+		{
+			name: "jump to JUMPDEST",
+			// PUSH1 4, JUMP, INVALID, JUMPDEST, PUSH1 7
+			bytecode: []byte{byte(vm.PUSH1), 0x04, byte(vm.JUMP), 0xfe, byte(vm.JUMPDEST), byte(vm.PUSH1), 0x07},
+			expected: word(big.NewInt(7)),
+		},
+		{
+			name: "jump into push data",
+			// PUSH1 4, JUMP, PUSH1 0x5b: offset 4 is a 0x5b byte inside push data
+			bytecode: []byte{byte(vm.PUSH1), 0x04, byte(vm.JUMP), byte(vm.PUSH1), byte(vm.JUMPDEST)},
+			wantErr:  vm.ErrInvalidJump,
+		},
+		{
+			name:     "jump to non-JUMPDEST",
+			bytecode: []byte{byte(vm.PUSH1), 0x03, byte(vm.JUMP), byte(vm.STOP)},
+			wantErr:  vm.ErrInvalidJump,
+		},
+		{
+			name:     "jump beyond 2^64",
+			bytecode: push32Then(word(new(big.Int).Lsh(big.NewInt(1), 64)), byte(vm.JUMP)),
+			wantErr:  vm.ErrInvalidJump,
+		},
+		{
+			name: "JUMPI not taken ignores destination",
+			// PUSH1 0, PUSH1 0xff, JUMPI, PUSH1 9
+			bytecode: []byte{byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0xff, byte(vm.JUMPI), byte(vm.PUSH1), 0x09},
+			expected: word(big.NewInt(9)),
+		},
+		{
+			name: "JUMPI taken",
+			// PUSH1 1, PUSH1 8, JUMPI, PUSH1 9, STOP, JUMPDEST, PUSH1 0x2a
+			bytecode: []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x08, byte(vm.JUMPI), byte(vm.PUSH1), 0x09, byte(vm.STOP), byte(vm.JUMPDEST), byte(vm.PUSH1), 0x2a},
+			expected: word(big.NewInt(0x2a)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top, err := stackTop(tt.bytecode)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Execute() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if !bytes.Equal(top, tt.expected) {
+				t.Errorf("Execute() left %x on the stack, want %x", top, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConcurrentExecutions(t *testing.T) {
+	// The same code runs in several VMs at once, each analyzing its jump
+	// destinations; run with -race to check they share no state
+	// PUSH1 1, PUSH1 8, JUMPI, PUSH1 9, STOP, JUMPDEST, PUSH1 0x2a
+	code := []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x08, byte(vm.JUMPI), byte(vm.PUSH1), 0x09, byte(vm.STOP), byte(vm.JUMPDEST), byte(vm.PUSH1), 0x2a}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			top, err := stackTop(code)
+			if err != nil || !bytes.Equal(top, word(big.NewInt(0x2a))) {
+				t.Errorf("Execute() = %x, %v, want 2a", top, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// precompile returns the address of the precompiled contract number n
+func precompile(n byte) common.Address {
+	return common.BytesToAddress([]byte{n})
+}
+
+// callPrecompile sends a transaction with input to the precompiled contract
+// at addr and returns the result with the gas used beyond intrinsic gas
+func callPrecompile(t *testing.T, fork vm.Fork, addr common.Address, input []byte, gas uint64) (vm.ExecutionResult, uint64) {
+	t.Helper()
+	ctx := vm.DefaultContext()
+	ctx.Fork = fork
+	result := vm.NewEVM(ctx, vm.NewMemoryStateDB()).Call(sender, addr, input, gas, nil)
+	intrinsicGas, err := vm.IntrinsicGas(input, false, fork)
+	if err != nil {
+		t.Fatalf("IntrinsicGas() failed: %v", err)
+	}
+	return result, result.GasUsed - intrinsicGas
+}
+
+func TestPrecompiledContracts(t *testing.T) {
+	// ecrecover input signed with a fixed key
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	hash := crypto.Keccak256([]byte("hello"))
+	sig, _ := crypto.Sign(hash, key)
+	ecrecoverInput := append(append(append(append([]byte{}, hash...), word(big.NewInt(int64(sig[64])+27))...), sig[:32]...), sig[32:64]...)
+
+	tests := []struct {
+		name     string
+		addr     byte
+		input    string
+		expected string
+		gas      uint64
+	}{
+		{"ecrecover", 0x01, common.Bytes2Hex(ecrecoverInput), common.Bytes2Hex(common.LeftPadBytes(crypto.PubkeyToAddress(key.PublicKey).Bytes(), 32)), 3000},
+		{"ecrecover of an invalid signature", 0x01, "a8b53bdf3306a35a7103ab5504a0c9b492295564b6202b1942a84ef300107281000000000000000000000000000000000000000000000000000000000000001b307835653165303366353363653138623737326363623030393366663731663366353366356337356237346463623331613835616138623838393262346538621122334455667788991011121314151617181920212223242526272829303132", "", 3000},
+		{"sha256", 0x02, "", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 60},
+		{"ripemd160", 0x03, "", "0000000000000000000000009c1185a5c5e9fc54612808977ee8f548b2258d31", 600},
+		{"identity", 0x04, "0102030405", "0102030405", 15 + 3},
+		{"modexp", 0x05, "00000000000000000000000000000000000000000000000000000000000000010000000000000000000000000000000000000000000000000000000000000020000000000000000000000000000000000000000000000000000000000000002003fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2efffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", "0000000000000000000000000000000000000000000000000000000000000001", 1360},
+		{"bn256 add", 0x06, "18b18acfb4c2c30276db5411368e7185b311dd124691610c5d3b74034e093dc9063c909c4720840cb5134cb9f59fa749755796819658d32efc0d288198f3726607c2b7f58a84bd6145f00c9c2bc0bb1a187f20ff2c92963a88019e7c6a014eed06614e20c147e940f2d70da3f74c9a17df361706a4485c742bd6788478fa17d7", "2243525c5efd4b9c3d3c45ac0ca3fe4dd85e830a4ce6b65fa1eeaee202839703301d1d33be6da8e509df21cc35964723180eed7532537db9ae5e7d48f195c915", 150},
+		{"bn256 scalar mul", 0x07, "2bd3e6d0f3b142924f5ca7b49ce5b9d54c4703d7ae5648e61d02268b1a0a9fb721611ce0a6af85915e2f1d70300909ce2e49dfad4a4619c8390cae66cefdb20400000000000000000000000000000000000000000000000011138ce750fa15c2", "070a8d6a982153cae4be29d434e8faef8a47b274a053f5a4ee2a6c9c13c31e5c031b8ce914eba3a9ffb989f9cdd5b0f01943074bf4f0f315690ec3cec6981afc", 6000},
+		{"bn256 pairing of nothing", 0x08, "", "0000000000000000000000000000000000000000000000000000000000000001", 45000},
+		{"blake2f", 0x09, "0000000c48c9bdf267e6096a3ba7ca8485ae67bb2bf894fe72f36e3cf1361d5f3af54fa5d182e6ad7f520e511f6c3e2b8c68059b6bbd41fbabd9831f79217e1319cde05b61626300000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000300000000000000000000000000000001", "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923", 12},
+		{"point evaluation", 0x0a, "01e798154708fe7789429634053cbf9f99b619f9f084048927333fce637f549b564c0a11a0f704f4fc3e8acfe0f8245f0ad1347b378fbf96e206da11a5d3630624d25032e67a7e6a4910df5834b8fe70e6bcfeeac0352434196bdf4b2485d5a18f59a8d2a1a625a17f3fea0fe5eb8c896db3764f3185481bc22f91b4aaffcca25f26936857bc3a7c2539ea8ec3a952b7873033e038326e87ed3e1276fd140253fa08e9fc25fb2d9a98527fc22a2c9612fbeafdad446cbc7bcdbdcd780af2c16a", "000000000000000000000000000000000000000000000000000000000000100073eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001", 50000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, gas := callPrecompile(t, vm.Cancun, precompile(tt.addr), common.FromHex(tt.input), vm.DefaultGasLimit)
+			if !result.Success {
+				t.Fatalf("Call() failed: %v", result.Error)
+			}
+			if !bytes.Equal(result.ReturnData, common.FromHex(tt.expected)) {
+				t.Errorf("output = %x, want %s", result.ReturnData, tt.expected)
+			}
+			if gas != tt.gas {
+				t.Errorf("gas = %d, want %d", gas, tt.gas)
+			}
+		})
+	}
+}
+
+func TestPrecompileFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		addr  byte
+		input []byte
+		gas   uint64
+	}{
+		{"out of gas", 0x02, nil, 21000 + 59},
+		{"point not on the curve", 0x06, word(big.NewInt(1)), vm.DefaultGasLimit},
+		{"pairing input of the wrong size", 0x08, make([]byte, 100), vm.DefaultGasLimit},
+		{"blake2f input of the wrong size", 0x09, make([]byte, 212), vm.DefaultGasLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, _ := callPrecompile(t, vm.Cancun, precompile(tt.addr), tt.input, tt.gas)
+			if result.Success || result.Reverted {
+				t.Fatalf("Call() succeeded or reverted, want an exceptional halt")
+			}
+			if result.GasUsed != tt.gas {
+				t.Errorf("GasUsed = %d, want all %d", result.GasUsed, tt.gas)
+			}
+		})
+	}
+}
+
+func TestPrecompilesByFork(t *testing.T) {
+	tests := []struct {
+		fork  vm.Fork
+		count int
+	}{
+		{vm.Frontier, 4},
+		{vm.Byzantium, 8},
+		{vm.Istanbul, 9},
+		{vm.Berlin, 9},
+		{vm.Cancun, 10},
+	}
+	for _, tt := range tests {
+		if got := len(vm.ActivePrecompiles(tt.fork)); got != tt.count {
+			t.Errorf("%s has %d precompiles, want %d", tt.fork, got, tt.count)
+		}
+	}
+
+	// Before Istanbul, 0x09 is an ordinary empty account
+	input := make([]byte, 213)
+	if result, _ := callPrecompile(t, vm.Byzantium, precompile(0x09), input, vm.DefaultGasLimit); !result.Success || len(result.ReturnData) != 0 {
+		t.Errorf("call to 0x09 before Istanbul returned %x, %v", result.ReturnData, result.Error)
+	}
+
+	// Modexp costs less since Berlin (EIP-2565): 3**5 % 7
+	modexp := append(append(append(word(big.NewInt(1)), word(big.NewInt(1))...), word(big.NewInt(1))...), 3, 5, 7)
+	for fork, expected := range map[vm.Fork]uint64{vm.Istanbul: 0, vm.Berlin: 200} {
+		result, gas := callPrecompile(t, fork, precompile(0x05), modexp, vm.DefaultGasLimit)
+		if !bytes.Equal(result.ReturnData, []byte{5}) {
+			t.Errorf("%s: modexp returned %x, want 05", fork, result.ReturnData)
+		}
+		if gas != expected {
+			t.Errorf("%s: modexp gas = %d, want %d", fork, gas, expected)
+		}
+	}
+}
+
+func TestPrecompileThroughCall(t *testing.T) {
+	// A contract calls sha256 with no input
+	state := vm.NewMemoryStateDB()
+	state.SetCode(proxy, callProgram(vm.STATICCALL, precompile(0x02), 0))
+
+	result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+	if !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+	expected := append(append(common.FromHex("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"), make([]byte, 32)...), word(big.NewInt(1))...)
+	if !bytes.Equal(result.ReturnData, expected) {
+		t.Errorf("Call() returned %x, want %x", result.ReturnData, expected)
+	}
+}
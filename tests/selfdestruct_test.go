@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// selfdestructTo is code that self-destructs in favour of beneficiary
+func selfdestructTo(beneficiary common.Address) []byte {
+	return append(append([]byte{byte(vm.PUSH20)}, beneficiary[:]...), byte(vm.SELFDESTRUCT))
+}
+
+// forkEVM creates an EVM running fork on state
+func forkEVM(fork vm.Fork, state vm.StateDB) *vm.EVM {
+	ctx := vm.DefaultContext()
+	ctx.Fork = fork
+	return vm.NewEVM(ctx, state)
+}
+
+func TestSelfdestruct(t *testing.T) {
+	tests := []struct {
+		name          string
+		fork          vm.Fork
+		beneficiary   common.Address
+		destroyed     bool
+		balance       uint64 // left at receiver
+		beneficiaryOf uint64 // received by other
+	}{
+		{"before Cancun", vm.Shanghai, other, true, 0, 100},
+		{"before Cancun to itself", vm.Shanghai, receiver, true, 0, 0},
+		// EIP-6780: a contract created by an earlier transaction survives
+		{"from Cancun", vm.Cancun, other, false, 0, 100},
+		{"from Cancun to itself", vm.Cancun, receiver, false, 100, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := vm.NewMemoryStateDB()
+			state.SetCode(receiver, selfdestructTo(tt.beneficiary))
+			state.AddBalance(receiver, uint256.NewInt(100))
+			state.Commit()
+
+			result := forkEVM(tt.fork, state).Call(sender, receiver, nil, vm.DefaultGasLimit, nil)
+			if !result.Success {
+				t.Fatalf("Call() failed: %v", result.Error)
+			}
+			if destroyed := !state.Exist(receiver); destroyed != tt.destroyed {
+				t.Errorf("account destroyed = %v, want %v", destroyed, tt.destroyed)
+			}
+			if got := state.GetBalance(receiver).Uint64(); got != tt.balance {
+				t.Errorf("receiver balance = %d, want %d", got, tt.balance)
+			}
+			if got := state.GetBalance(other).Uint64(); got != tt.beneficiaryOf {
+				t.Errorf("beneficiary balance = %d, want %d", got, tt.beneficiaryOf)
+			}
+		})
+	}
+}
+
+func TestSelfdestructInCreatingTransaction(t *testing.T) {
+	// Init code that self-destructs right away: from Cancun the contract
+	// is still destroyed, since the same transaction created it
+	state := vm.NewMemoryStateDB()
+	state.AddBalance(sender, uint256.NewInt(50))
+	address, result := forkEVM(vm.Cancun, state).Deploy(sender, selfdestructTo(other), vm.DefaultGasLimit, uint256.NewInt(50))
+	if !result.Success {
+		t.Fatalf("Deploy() failed: %v", result.Error)
+	}
+	if state.Exist(address) {
+		t.Errorf("contract destroyed by its init code still exists")
+	}
+	if got := state.GetBalance(other).Uint64(); got != 50 {
+		t.Errorf("beneficiary balance = %d, want 50", got)
+	}
+}
+
+func TestSelfdestructGas(t *testing.T) {
+	tests := []struct {
+		name     string
+		fork     vm.Fork
+		balance  uint64
+		expected uint64 // beyond intrinsic gas and the PUSH20
+		refund   uint64 // capped at half the gas used
+	}{
+		{"Frontier", vm.Frontier, 100, 0, 10501},
+		// EIP-150: the beneficiary does not exist
+		{"TangerineWhistle", vm.TangerineWhistle, 0, 5000 + 25000, 24000},
+		// EIP-161: a missing beneficiary only costs extra if wei is sent
+		{"SpuriousDragon without balance", vm.SpuriousDragon, 0, 5000, 13001},
+		{"SpuriousDragon with balance", vm.SpuriousDragon, 100, 5000 + 25000, 24000},
+		// EIP-2929: the beneficiary is cold
+		{"Berlin", vm.Berlin, 100, 5000 + 2600 + 25000, 24000},
+		// EIP-3529: no refund
+		{"London", vm.London, 100, 5000 + 2600 + 25000, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := vm.NewMemoryStateDB()
+			state.SetCode(receiver, selfdestructTo(other))
+			state.AddBalance(receiver, uint256.NewInt(tt.balance))
+			state.Commit()
+
+			result := forkEVM(tt.fork, state).Call(sender, receiver, nil, vm.DefaultGasLimit, nil)
+			if !result.Success {
+				t.Fatalf("Call() failed: %v", result.Error)
+			}
+			if gas := result.GasUsed - 21000 - 3; gas != tt.expected {
+				t.Errorf("SELFDESTRUCT gas = %d, want %d", gas, tt.expected)
+			}
+			if result.GasRefund != tt.refund {
+				t.Errorf("GasRefund = %d, want %d", result.GasRefund, tt.refund)
+			}
+		})
+	}
+}
+
+func TestSelfdestructReverted(t *testing.T) {
+	// The proxy calls the callee, which self-destructs, and then reverts
+	code := append([]byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH20)}, callee[:]...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.REVERT))
+
+	state := vm.NewMemoryStateDB()
+	state.SetCode(proxy, code)
+	state.SetCode(callee, selfdestructTo(other))
+	state.AddBalance(callee, uint256.NewInt(100))
+	state.Commit()
+
+	result := forkEVM(vm.Shanghai, state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+	if !result.Reverted {
+		t.Fatalf("Call() did not revert: %v", result.Error)
+	}
+	if !state.Exist(callee) || state.GetBalance(callee).Uint64() != 100 {
+		t.Errorf("reverted SELFDESTRUCT destroyed the callee or moved its balance")
+	}
+}
+
+func TestSelfdestructWriteProtection(t *testing.T) {
+	state := vm.NewMemoryStateDB()
+	state.SetCode(proxy, callProgram(vm.STATICCALL, callee, 0))
+	state.SetCode(callee, selfdestructTo(other))
+
+	result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+	if !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+	if success := result.ReturnData[64:]; !bytes.Equal(success, make([]byte, 32)) {
+		t.Errorf("SELFDESTRUCT succeeded in a static call")
+	}
+}
@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"solidity-vm-go/internal/compiler"
+	"solidity-vm-go/internal/debugger"
+	"solidity-vm-go/internal/sourcemap"
+	"solidity-vm-go/internal/vm"
+)
+
+// valueSource reads testdata/Value.sol, a contract with the functions the
+// compiler generates code for, setValue working through an internal
+// function
+func valueSource(t *testing.T) string {
+	t.Helper()
+	source, err := os.ReadFile(filepath.Join("testdata", "Value.sol"))
+	if err != nil {
+		t.Fatalf("reading the source fixture: %v", err)
+	}
+	return string(source)
+}
+
+// instructionPCs returns the program counters of the instructions of code
+// that are op
+func instructionPCs(code []byte, op vm.OpCode) []uint64 {
+	var pcs []uint64
+	for pc := uint64(0); pc < uint64(len(code)); pc++ {
+		current := vm.OpCode(code[pc])
+		if current == op {
+			pcs = append(pcs, pc)
+		}
+		if current.IsPush() {
+			pc += uint64(current - vm.PUSH1 + 1)
+		}
+	}
+	return pcs
+}
+
+func TestSourceMapEncoding(t *testing.T) {
+	// The example of the Solidity documentation
+	m, err := sourcemap.Parse("1:2:1;:9;2:1:2;;")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	expected := sourcemap.SourceMap{
+		{Start: 1, Length: 2, File: 1, Jump: sourcemap.JumpNone},
+		{Start: 1, Length: 9, File: 1, Jump: sourcemap.JumpNone},
+		{Start: 2, Length: 1, File: 2, Jump: sourcemap.JumpNone},
+		{Start: 2, Length: 1, File: 2, Jump: sourcemap.JumpNone},
+		{Start: 2, Length: 1, File: 2, Jump: sourcemap.JumpNone},
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Parse() = %v, want %v", m, expected)
+	}
+	if got := m.String(); got != "1:2:1:-;:9;2:1:2;;" {
+		t.Errorf("String() = %q", got)
+	}
+
+	// Jumps and the modifier depth field of newer solc versions
+	m, err = sourcemap.Parse("0:10:0:-:0;5:3::i;::::1;:::o")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	jumps := []sourcemap.Jump{sourcemap.JumpNone, sourcemap.JumpIn, sourcemap.JumpIn, sourcemap.JumpOut}
+	for i, jump := range jumps {
+		if m[i].Jump != jump {
+			t.Errorf("entry %d jump = %c, want %c", i, m[i].Jump, jump)
+		}
+	}
+	if again, _ := sourcemap.Parse(m.String()); !reflect.DeepEqual(again, m) {
+		t.Errorf("Parse(String()) = %v, want %v", again, m)
+	}
+
+	for _, invalid := range []string{"1:x:0", "1:2:0:y"} {
+		if _, err := sourcemap.Parse(invalid); err == nil {
+			t.Errorf("Parse(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestCompileSourceMap(t *testing.T) {
+	source := valueSource(t)
+	result := compiler.Compile(source)
+	sources := []sourcemap.Source{{Name: "Value.sol", Content: source}}
+	runtimeMap, err := sourcemap.Parse(result.RuntimeSourceMap)
+	if err != nil {
+		t.Fatalf("invalid runtime source map: %v", err)
+	}
+	runtime := sourcemap.NewMapper(result.RuntimeBytecode, runtimeMap, sources)
+
+	lineOf := func(text string) int {
+		return strings.Count(source[:strings.Index(source, text)], "\n") + 1
+	}
+	jumps := instructionPCs(result.RuntimeBytecode, vm.JUMP)
+	logs := instructionPCs(result.RuntimeBytecode, vm.LOG1)
+	tests := []struct {
+		name string
+		pc   uint64
+		line int
+		jump sourcemap.Jump
+	}{
+		{"dispatcher", 0, lineOf("contract Value"), sourcemap.JumpNone},
+		{"call of the internal function", jumps[0], lineOf("function setValue"), sourcemap.JumpIn},
+		{"emit", logs[0], lineOf("emit ValueChanged"), sourcemap.JumpNone},
+		{"return from the internal function", jumps[1], lineOf("function _setValue"), sourcemap.JumpOut},
+		{"getValue", uint64(len(result.RuntimeBytecode) - 1), lineOf("function getValue"), sourcemap.JumpNone},
+	}
+	for _, tt := range tests {
+		pos, ok := runtime.Lookup(tt.pc)
+		if !ok {
+			t.Errorf("%s: no position for pc %d", tt.name, tt.pc)
+			continue
+		}
+		if pos.File != "Value.sol" || pos.Line != tt.line || pos.Location.Jump != tt.jump {
+			t.Errorf("%s: position %s jump %c, want line %d jump %c", tt.name, pos, pos.Location.Jump, tt.line, tt.jump)
+		}
+	}
+	if pos, _ := runtime.Lookup(logs[0]); pos.Column != 9 || runtime.LineText(pos) != "        emit ValueChanged(oldValue, newValue, msg.sender);" {
+		t.Errorf("emit at column %d of %q", pos.Column, runtime.LineText(pos))
+	}
+	// Push data is not an instruction
+	if _, ok := runtime.Lookup(1); ok {
+		t.Errorf("Lookup() found a position inside push data")
+	}
+
+	// The creation code maps to the constructor, and its map stops where
+	// the runtime code appended to it starts
+	creationMap, err := sourcemap.Parse(result.SourceMap)
+	if err != nil {
+		t.Fatalf("invalid source map: %v", err)
+	}
+	creation := sourcemap.NewMapper(result.Contract.Bytecode, creationMap, sources)
+	if pos, ok := creation.Lookup(0); !ok || pos.Line != lineOf("constructor") {
+		t.Errorf("creation code at %s, want the constructor", pos)
+	}
+	if _, ok := creation.Lookup(uint64(len(result.Contract.Bytecode) - len(result.RuntimeBytecode))); ok {
+		t.Errorf("the runtime code appended to the creation code has a position")
+	}
+}
+
+func TestCompileSourceMapWithoutSource(t *testing.T) {
+	// With nothing to map to, every instruction maps to the whole input
+	result := compiler.Compile("")
+	m, err := sourcemap.Parse(result.RuntimeSourceMap)
+	if err != nil {
+		t.Fatalf("invalid runtime source map: %v", err)
+	}
+	for i, loc := range m {
+		if loc.Start != 0 || loc.Length != 0 || loc.File != 0 {
+			t.Fatalf("entry %d = %+v, want 0:0:0", i, loc)
+		}
+	}
+}
+
+func TestDebuggerSourcePosition(t *testing.T) {
+	source := valueSource(t)
+	result := compiler.Compile(source)
+	m, _ := sourcemap.Parse(result.RuntimeSourceMap)
+	d := debugger.New()
+	d.AddSource(sourcemap.NewMapper(result.RuntimeBytecode, m, []sourcemap.Source{{Name: "Value.sol", Content: source}}))
+	d.AddBreakpoint(debugger.Breakpoint{Kind: debugger.BreakOpcode, Op: vm.LOG1})
+
+	evm := vm.NewEVM(vm.DefaultContext(), vm.NewMemoryStateDB())
+	address, deployment := evm.Deploy(sender, result.Contract.Bytecode, vm.DefaultGasLimit, nil)
+	if !deployment.Success {
+		t.Fatalf("Deploy() failed: %v", deployment.Error)
+	}
+	d.Start(func(tracer vm.Tracer) vm.ExecutionResult {
+		evm.Tracer = tracer
+		input := append(append([]byte{}, compiler.SetValueSelector...), word(big.NewInt(42))...)
+		return evm.Call(sender, address, input, vm.DefaultGasLimit, nil)
+	})
+	defer d.Close()
+
+	if stop := d.Continue(); stop.Reason != debugger.ReasonBreakpoint {
+		t.Fatalf("Continue() = %+v, want the LOG1 breakpoint", stop)
+	}
+	pos, _, ok := d.Position()
+	if !ok {
+		t.Fatalf("Position() found no source position")
+	}
+	if expected := "Value.sol:24:9"; pos.String() != expected {
+		t.Errorf("Position() = %s, want %s", pos, expected)
+	}
+}
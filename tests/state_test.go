@@ -0,0 +1,267 @@
+package tests
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"solidity-vm-go/internal/compiler"
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+var (
+	sender   = common.HexToAddress("0x1000000000000000000000000000000000000001")
+	receiver = common.HexToAddress("0x2000000000000000000000000000000000000002")
+	other    = common.HexToAddress("0x3000000000000000000000000000000000000003")
+)
+
+func TestMemoryStateDB(t *testing.T) {
+	state := vm.NewMemoryStateDB()
+	if state.Exist(receiver) || !state.Empty(receiver) {
+		t.Fatalf("new state has an account at %s", receiver)
+	}
+
+	state.AddBalance(receiver, uint256.NewInt(100))
+	state.SubBalance(receiver, uint256.NewInt(30))
+	if got := state.GetBalance(receiver); !got.Eq(uint256.NewInt(70)) {
+		t.Errorf("GetBalance() = %s, want 70", got)
+	}
+	if !state.Exist(receiver) || state.Empty(receiver) {
+		t.Errorf("account with a balance is missing or empty")
+	}
+
+	code := []byte{byte(vm.STOP)}
+	state.SetCode(receiver, code)
+	if got := state.GetCodeHash(receiver); got != crypto.Keccak256Hash(code) {
+		t.Errorf("GetCodeHash() = %s, want hash of the code", got)
+	}
+	state.CreateAccount(receiver)
+	if state.GetCodeSize(receiver) != 0 || state.GetBalance(receiver).Uint64() != 70 {
+		t.Errorf("CreateAccount() kept code or dropped the balance")
+	}
+
+	key, value := common.Hash{1}, common.Hash{2}
+	state.SetState(receiver, key, value)
+	if got := state.GetCommittedState(receiver, key); got != (common.Hash{}) {
+		t.Errorf("GetCommittedState() = %s before Commit, want zero", got)
+	}
+	state.Commit()
+	if got := state.GetCommittedState(receiver, key); got != value {
+		t.Errorf("GetCommittedState() = %s after Commit, want %s", got, value)
+	}
+}
+
+func TestEVMCallPersistsState(t *testing.T) {
+	state := vm.NewMemoryStateDB()
+	evm := vm.NewEVM(vm.DefaultContext(), state)
+	receiver, deployment := evm.Deploy(sender, compiler.Compile("").Contract.Bytecode, vm.DefaultGasLimit, nil)
+	if !deployment.Success {
+		t.Fatalf("Deploy() failed: %v", deployment.Error)
+	}
+
+	setValue := append(append([]byte{}, compiler.SetValueSelector...), word(big.NewInt(42))...)
+	if result := evm.Call(sender, receiver, setValue, vm.DefaultGasLimit, nil); !result.Success {
+		t.Fatalf("setValue(42) failed: %v", result.Error)
+	}
+	result := evm.Call(sender, receiver, compiler.GetValueSelector, vm.DefaultGasLimit, nil)
+	if !result.Success {
+		t.Fatalf("getValue() failed: %v", result.Error)
+	}
+	if !bytes.Equal(result.ReturnData, word(big.NewInt(42))) {
+		t.Errorf("getValue() = %x, want 42", result.ReturnData)
+	}
+	if nonce := state.GetNonce(sender); nonce != 3 {
+		t.Errorf("sender nonce = %d after three transactions, want 3", nonce)
+	}
+}
+
+func TestEVMCallTransfersValue(t *testing.T) {
+	state := vm.NewMemoryStateDB()
+	state.AddBalance(sender, uint256.NewInt(1000))
+	evm := vm.NewEVM(vm.DefaultContext(), state)
+
+	if result := evm.Call(sender, receiver, nil, vm.DefaultGasLimit, uint256.NewInt(400)); !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+	if got := state.GetBalance(receiver); !got.Eq(uint256.NewInt(400)) {
+		t.Errorf("receiver balance = %s, want 400", got)
+	}
+	result := evm.Call(sender, receiver, nil, vm.DefaultGasLimit, uint256.NewInt(1000))
+	if result.Error != vm.ErrInsufficientBalance {
+		t.Errorf("Call() error = %v, want %v", result.Error, vm.ErrInsufficientBalance)
+	}
+}
+
+func TestAccountOpcodes(t *testing.T) {
+	code := []byte{byte(vm.PUSH1), 0x2a, byte(vm.STOP)}
+	state := vm.NewMemoryStateDB()
+	state.AddBalance(other, uint256.NewInt(12345))
+	state.SetCode(other, code)
+
+	// withAddress pushes other's address before op
+	withAddress := func(op vm.OpCode) []byte {
+		return returnTop(append(append([]byte{byte(vm.PUSH20)}, other[:]...), byte(op))...)
+	}
+	extCodeCopy := append(append([]byte{byte(vm.PUSH1), 0x03, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH20)}, other[:]...),
+		byte(vm.EXTCODECOPY), byte(vm.PUSH1), 0x03, byte(vm.PUSH1), 0x00, byte(vm.RETURN))
+
+	tests := []struct {
+		name     string
+		code     []byte
+		expected []byte
+	}{
+		{"BALANCE", withAddress(vm.BALANCE), word(big.NewInt(12345))},
+		{"EXTCODESIZE", withAddress(vm.EXTCODESIZE), word(big.NewInt(3))},
+		{"EXTCODEHASH", withAddress(vm.EXTCODEHASH), crypto.Keccak256(code)},
+		{"EXTCODECOPY", extCodeCopy, code},
+		{"EXTCODEHASH of a missing account", returnTop(byte(vm.PUSH1), 0x99, byte(vm.EXTCODEHASH)), make([]byte, 32)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state.SetCode(receiver, tt.code)
+			result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, receiver, nil, vm.DefaultGasLimit, nil)
+			if !result.Success {
+				t.Fatalf("Call() failed: %v", result.Error)
+			}
+			if !bytes.Equal(result.ReturnData, tt.expected) {
+				t.Errorf("Call() returned %x, want %x", result.ReturnData, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAccountAccessGas(t *testing.T) {
+	// PUSH20 other, BALANCE, PUSH20 other, BALANCE
+	balance := append(append([]byte{byte(vm.PUSH20)}, other[:]...), byte(vm.BALANCE))
+	code := append(append([]byte{}, balance...), balance...)
+
+	tests := []struct {
+		fork     vm.Fork
+		expected uint64
+	}{
+		{vm.Frontier, 2 * (3 + 20)},
+		{vm.TangerineWhistle, 2 * (3 + 400)},
+		{vm.Istanbul, 2 * (3 + 700)},
+		// The first access is cold, the second warm
+		{vm.Berlin, 3 + 2600 + 3 + 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fork.String(), func(t *testing.T) {
+			ctx := vm.DefaultContext()
+			ctx.Fork = tt.fork
+			result := vm.ExecuteWithContext(ctx, vm.Contract{Bytecode: code}, nil)
+			if !result.Success {
+				t.Fatalf("Execute() failed: %v", result.Error)
+			}
+			if result.GasUsed != tt.expected {
+				t.Errorf("GasUsed = %d, want %d", result.GasUsed, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSnapshotRevert(t *testing.T) {
+	state := vm.NewMemoryStateDB()
+	key := common.Hash{1}
+	state.AddBalance(receiver, uint256.NewInt(10))
+	state.SetState(receiver, key, common.Hash{1})
+
+	outer := state.Snapshot()
+	state.SetState(receiver, key, common.Hash{2})
+	state.AddRefund(100)
+	inner := state.Snapshot()
+	state.AddBalance(receiver, uint256.NewInt(5))
+	state.SetNonce(receiver, 7)
+	state.SetCode(other, []byte{byte(vm.STOP)})
+	state.AddSlotToAccessList(other, key)
+
+	state.RevertToSnapshot(inner)
+	if state.GetBalance(receiver).Uint64() != 10 || state.GetNonce(receiver) != 0 {
+		t.Errorf("inner revert kept the balance or nonce change")
+	}
+	if state.Exist(other) || state.AddressInAccessList(other) {
+		t.Errorf("inner revert kept the account created or warmed after the snapshot")
+	}
+	if state.GetState(receiver, key) != (common.Hash{2}) || state.GetRefund() != 100 {
+		t.Errorf("inner revert undid changes made before the snapshot")
+	}
+
+	state.RevertToSnapshot(outer)
+	if state.GetState(receiver, key) != (common.Hash{1}) || state.GetRefund() != 0 {
+		t.Errorf("outer revert kept the storage or refund change")
+	}
+}
+
+func TestFailedCallRevertsState(t *testing.T) {
+	// SSTORE 1 into slot 0, then end with the given code
+	storeThen := func(end ...byte) []byte {
+		return append([]byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SSTORE)}, end...)
+	}
+
+	tests := []struct {
+		name string
+		code []byte
+		gas  uint64
+	}{
+		{"REVERT", storeThen(byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.REVERT)), vm.DefaultGasLimit},
+		{"invalid opcode", storeThen(byte(vm.INVALID)), vm.DefaultGasLimit},
+		{"out of gas", storeThen(byte(vm.JUMPDEST), byte(vm.PUSH1), 0x05, byte(vm.JUMP)), 100000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := vm.NewMemoryStateDB()
+			state.AddBalance(sender, uint256.NewInt(1000))
+			state.SetCode(receiver, tt.code)
+			state.Commit()
+
+			result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, receiver, nil, tt.gas, uint256.NewInt(400))
+			if result.Success {
+				t.Fatalf("Call() succeeded, want failure")
+			}
+			if got := state.GetState(receiver, common.Hash{}); got != (common.Hash{}) {
+				t.Errorf("storage write survived the failure: slot 0 = %s", got)
+			}
+			if got := state.GetBalance(sender); got.Uint64() != 1000 {
+				t.Errorf("sender balance = %s, want the value transfer undone", got)
+			}
+			if nonce := state.GetNonce(sender); nonce != 1 {
+				t.Errorf("sender nonce = %d, want 1", nonce)
+			}
+		})
+	}
+}
+
+func TestRunCommitsState(t *testing.T) {
+	// Clear slot 0 for a refund and emit a log
+	clearAndLog := []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.LOG0), byte(vm.STOP),
+	}
+	m := vm.NewVM()
+	m.StateDB.SetState(m.Address, common.Hash{}, common.Hash{1})
+	m.StateDB.Commit()
+
+	first := m.Run(vm.Contract{Bytecode: clearAndLog}, nil)
+	if !first.Success || len(first.Logs) != 1 || first.GasRefund == 0 {
+		t.Fatalf("first Run() success %v logs %d refund %d, want a log and a refund", first.Success, len(first.Logs), first.GasRefund)
+	}
+	// The slot is already clear, so the second run earns no refund, and the
+	// logs of the first run are not reported again
+	second := m.Run(vm.Contract{Bytecode: clearAndLog}, nil)
+	if !second.Success || len(second.Logs) != 1 || second.GasRefund != 0 {
+		t.Errorf("second Run() success %v logs %d refund %d, want a log and no refund", second.Success, len(second.Logs), second.GasRefund)
+	}
+
+	// Slots warmed by a run are cold again in the next one
+	load := vm.Contract{Bytecode: []byte{byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.STOP)}}
+	if once, again := m.Run(load, nil), m.Run(load, nil); once.GasUsed != again.GasUsed {
+		t.Errorf("SLOAD used %d gas, then %d, want the same cold access", once.GasUsed, again.GasUsed)
+	}
+}
@@ -0,0 +1,26 @@
+pragma solidity ^0.8.0;
+
+// A contract keeping a value
+contract Value {
+    uint256 private value;
+
+    event ValueChanged(uint256 oldValue, uint256 newValue, address changedBy);
+
+    constructor() {
+        value = 0;
+    }
+
+    function setValue(uint256 newValue) public {
+        _setValue(newValue);
+    }
+
+    function getValue() public view returns (uint256) {
+        return value;
+    }
+
+    function _setValue(uint256 newValue) internal {
+        uint256 oldValue = value;
+        value = newValue;
+        emit ValueChanged(oldValue, newValue, msg.sender);
+    }
+}
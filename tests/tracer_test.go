@@ -0,0 +1,214 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// recordingTracer records the events of an execution as strings, and the
+// steps separately for checking gas
+type recordingTracer struct {
+	events []string
+	steps  []step
+}
+
+type step struct {
+	pc        uint64
+	op        vm.OpCode
+	gas, cost uint64
+	stack     int
+	depth     int
+}
+
+func (r *recordingTracer) OnTxStart(_ *vm.EVM, from, to common.Address, create bool, _ []byte, gas uint64, _ *uint256.Int) {
+	r.events = append(r.events, fmt.Sprintf("start %s create=%v", to.Hex()[:4], create))
+}
+
+func (r *recordingTracer) OnTxEnd(result vm.ExecutionResult) {
+	r.events = append(r.events, fmt.Sprintf("end success=%v", result.Success))
+}
+
+func (r *recordingTracer) OnEnter(depth int, typ vm.OpCode, from, to common.Address, _ []byte, _ uint64, _ *uint256.Int) {
+	r.events = append(r.events, fmt.Sprintf("enter %d %s %s->%s", depth, typ, from.Hex()[:4], to.Hex()[:4]))
+}
+
+func (r *recordingTracer) OnExit(depth int, _ []byte, _ uint64, err error) {
+	r.events = append(r.events, fmt.Sprintf("exit %d err=%v", depth, err != nil))
+}
+
+func (r *recordingTracer) OnOpcode(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.VM) {
+	r.steps = append(r.steps, step{pc, op, gas, cost, len(scope.Stack), scope.Depth})
+}
+
+func (r *recordingTracer) OnFault(pc uint64, op vm.OpCode, _, _ uint64, _ *vm.VM, err error) {
+	r.events = append(r.events, fmt.Sprintf("fault %d %s", pc, op))
+}
+
+func (r *recordingTracer) OnStorageChange(addr common.Address, key, prev, value common.Hash) {
+	r.events = append(r.events, fmt.Sprintf("sstore %s %x %x->%x", addr.Hex()[:4], key[31], prev[31], value[31]))
+}
+
+func (r *recordingTracer) OnLog(log *vm.Log) {
+	r.events = append(r.events, fmt.Sprintf("log %s %d topics", log.Address.Hex()[:4], len(log.Topics)))
+}
+
+func TestTracerSteps(t *testing.T) {
+	// PUSH1 2, PUSH1 3, ADD, PUSH1 0, MSTORE: the MSTORE also pays for memory
+	code := []byte{byte(vm.PUSH1), 0x02, byte(vm.PUSH1), 0x03, byte(vm.ADD), byte(vm.PUSH1), 0x00, byte(vm.MSTORE)}
+	tracer := &recordingTracer{}
+	ctx := vm.DefaultContext()
+	ctx.GasLimit = 1000
+	result := vm.ExecuteWithTracer(ctx, vm.Contract{Bytecode: code}, nil, tracer)
+	if !result.Success {
+		t.Fatalf("Execute() failed: %v", result.Error)
+	}
+
+	expected := []step{
+		{0, vm.PUSH1, 1000, 3, 0, 0},
+		{2, vm.PUSH1, 997, 3, 1, 0},
+		{4, vm.ADD, 994, 3, 2, 0},
+		{5, vm.PUSH1, 991, 3, 1, 0},
+		{7, vm.MSTORE, 988, 3 + 3, 2, 0},
+	}
+	if !reflect.DeepEqual(tracer.steps, expected) {
+		t.Errorf("steps = %v, want %v", tracer.steps, expected)
+	}
+	events := []string{"start 0x00 create=false", "enter 0 CALL 0x00->0x00", "exit 0 err=false", "end success=true"}
+	if !reflect.DeepEqual(tracer.events, events) {
+		t.Errorf("events = %q, want %q", tracer.events, events)
+	}
+}
+
+func TestTracerCallFrames(t *testing.T) {
+	// The callee writes slot 0 and emits a LOG0
+	logger := append(append([]byte{}, storeOne...), byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.LOG0))
+	state := vm.NewMemoryStateDB()
+	state.SetCode(proxy, callProgram(vm.DELEGATECALL, callee, 0))
+	state.SetCode(callee, logger)
+
+	tracer := &recordingTracer{}
+	evm := vm.NewEVM(vm.DefaultContext(), state)
+	evm.Tracer = tracer
+	if result := evm.Call(sender, proxy, nil, vm.DefaultGasLimit, nil); !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+
+	// The delegated frame runs, writes and logs as the proxy
+	expected := []string{
+		"start 0x40 create=false",
+		"enter 0 CALL 0x10->0x40",
+		"enter 1 DELEGATECALL 0x40->0x50",
+		"sstore 0x40 0 0->1",
+		"log 0x40 0 topics",
+		"exit 1 err=false",
+		"exit 0 err=false",
+		"end success=true",
+	}
+	if !reflect.DeepEqual(tracer.events, expected) {
+		t.Errorf("events = %q, want %q", tracer.events, expected)
+	}
+	var depths []int
+	for _, s := range tracer.steps {
+		if len(depths) == 0 || depths[len(depths)-1] != s.depth {
+			depths = append(depths, s.depth)
+		}
+	}
+	if !reflect.DeepEqual(depths, []int{0, 1, 0}) {
+		t.Errorf("step depths = %v, want [0 1 0]", depths)
+	}
+}
+
+func TestTracerCreate(t *testing.T) {
+	tracer := &recordingTracer{}
+	evm := vm.NewEVM(vm.DefaultContext(), vm.NewMemoryStateDB())
+	evm.Tracer = tracer
+	address, result := evm.Deploy(sender, []byte{byte(vm.STOP)}, vm.DefaultGasLimit, nil)
+	if !result.Success {
+		t.Fatalf("Deploy() failed: %v", result.Error)
+	}
+
+	to := address.Hex()[:4]
+	expected := []string{"start " + to + " create=true", "enter 0 CREATE 0x10->" + to, "exit 0 err=false", "end success=true"}
+	if !reflect.DeepEqual(tracer.events, expected) {
+		t.Errorf("events = %q, want %q", tracer.events, expected)
+	}
+}
+
+func TestTracerFaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  []byte
+		steps int // steps reported before the fault
+		fault string
+	}{
+		{"invalid opcode", []byte{byte(vm.PUSH1), 0x01, byte(vm.INVALID)}, 1, "fault 2 INVALID"},
+		// The stack is checked before the step is reported
+		{"stack underflow", []byte{byte(vm.PUSH1), 0x01, byte(vm.ADD)}, 1, "fault 2 ADD"},
+		// JUMP fails when it runs, after it has been reported
+		{"invalid jump", []byte{byte(vm.PUSH1), 0x01, byte(vm.JUMP)}, 2, "fault 2 JUMP"},
+		// A revert is not a fault
+		{"revert", []byte{byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.REVERT)}, 3, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := &recordingTracer{}
+			result := vm.ExecuteWithTracer(vm.DefaultContext(), vm.Contract{Bytecode: tt.code}, nil, tracer)
+			if result.Success {
+				t.Fatalf("Execute() succeeded")
+			}
+			if len(tracer.steps) != tt.steps {
+				t.Errorf("%d steps reported, want %d", len(tracer.steps), tt.steps)
+			}
+			var fault string
+			for _, event := range tracer.events {
+				if len(event) > 5 && event[:5] == "fault" {
+					fault = event
+				}
+			}
+			if fault != tt.fault {
+				t.Errorf("fault = %q, want %q", fault, tt.fault)
+			}
+			if tt.fault == "" && !errors.Is(result.Error, vm.ErrExecutionReverted) {
+				t.Errorf("Error = %v, want a revert", result.Error)
+			}
+		})
+	}
+}
+
+func TestTracerDetached(t *testing.T) {
+	// NoopTracer satisfies the interface, and a nil tracer traces nothing
+	var _ vm.Tracer = vm.NoopTracer{}
+	machine := vm.NewVM()
+	machine.SetTracer(vm.NoopTracer{})
+	machine.SetTracer(nil)
+	if result := machine.Run(vm.Contract{Bytecode: storeOne}, nil); !result.Success {
+		t.Fatalf("Run() failed: %v", result.Error)
+	}
+}
+
+func TestOpCodeString(t *testing.T) {
+	tests := map[vm.OpCode]string{
+		vm.ADD:          "ADD",
+		vm.SHA3:         "KECCAK256",
+		vm.PUSH0:        "PUSH0",
+		vm.PUSH32:       "PUSH32",
+		vm.DUP16:        "DUP16",
+		vm.SWAP1:        "SWAP1",
+		vm.LOG4:         "LOG4",
+		vm.SELFDESTRUCT: "SELFDESTRUCT",
+		vm.OpCode(0x0c): "opcode 0xc not defined",
+	}
+	for op, expected := range tests {
+		if got := op.String(); got != expected {
+			t.Errorf("OpCode(0x%02x).String() = %q, want %q", byte(op), got, expected)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"solidity-vm-go/internal/tracers"
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+// traceJSON runs code with 1000 gas under a JSONTracer and returns the
+// lines of the trace
+func traceJSON(t *testing.T, code []byte, cfg tracers.JSONConfig) []string {
+	t.Helper()
+	var out bytes.Buffer
+	tracer := tracers.NewJSONTracer(&out, cfg)
+	ctx := vm.DefaultContext()
+	ctx.GasLimit = 1000
+	vm.ExecuteWithTracer(ctx, vm.Contract{Bytecode: code}, nil, tracer)
+	if err := tracer.Err(); err != nil {
+		t.Fatalf("writing the trace failed: %v", err)
+	}
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+func TestJSONTracer(t *testing.T) {
+	// PUSH1 2, PUSH1 3, ADD, PUSH1 0, MSTORE, PUSH1 32, PUSH1 0, RETURN
+	code := []byte{
+		byte(vm.PUSH1), 0x02, byte(vm.PUSH1), 0x03, byte(vm.ADD), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	}
+	// The lines geth's `evm run --json` writes for the same code and gas
+	expected := []string{
+		`{"pc":0,"op":96,"gas":"0x3e8","gasCost":"0x3","memSize":0,"stack":[],"depth":1,"refund":0,"opName":"PUSH1"}`,
+		`{"pc":2,"op":96,"gas":"0x3e5","gasCost":"0x3","memSize":0,"stack":["0x2"],"depth":1,"refund":0,"opName":"PUSH1"}`,
+		`{"pc":4,"op":1,"gas":"0x3e2","gasCost":"0x3","memSize":0,"stack":["0x2","0x3"],"depth":1,"refund":0,"opName":"ADD"}`,
+		`{"pc":5,"op":96,"gas":"0x3df","gasCost":"0x3","memSize":0,"stack":["0x5"],"depth":1,"refund":0,"opName":"PUSH1"}`,
+		`{"pc":7,"op":82,"gas":"0x3dc","gasCost":"0x6","memSize":0,"stack":["0x5","0x0"],"depth":1,"refund":0,"opName":"MSTORE"}`,
+		`{"pc":8,"op":96,"gas":"0x3d6","gasCost":"0x3","memSize":32,"stack":[],"depth":1,"refund":0,"opName":"PUSH1"}`,
+		`{"pc":10,"op":96,"gas":"0x3d3","gasCost":"0x3","memSize":32,"stack":["0x20"],"depth":1,"refund":0,"opName":"PUSH1"}`,
+		`{"pc":12,"op":243,"gas":"0x3d0","gasCost":"0x0","memSize":32,"stack":["0x20","0x0"],"depth":1,"refund":0,"opName":"RETURN"}`,
+		`{"output":"0000000000000000000000000000000000000000000000000000000000000005","gasUsed":"0x18"}`,
+	}
+	lines := traceJSON(t, code, tracers.JSONConfig{})
+	if len(lines) != len(expected) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(expected), strings.Join(lines, "\n"))
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("line %d = %s\nwant %s", i, lines[i], expected[i])
+		}
+	}
+
+	// Memory and return data are optional, and so is the stack
+	lines = traceJSON(t, code, tracers.JSONConfig{EnableMemory: true, DisableStack: true})
+	if expected := `{"pc":12,"op":243,"gas":"0x3d0","gasCost":"0x0","memory":"0x0000000000000000000000000000000000000000000000000000000000000005","memSize":32,"stack":null,"depth":1,"refund":0,"opName":"RETURN"}`; lines[7] != expected {
+		t.Errorf("RETURN = %s\nwant %s", lines[7], expected)
+	}
+}
+
+func TestJSONTracerNestedCall(t *testing.T) {
+	// The proxy calls the callee, which stores and returns
+	state := vm.NewMemoryStateDB()
+	state.SetCode(proxy, callProgram(vm.CALL, callee, 0))
+	state.SetCode(callee, storeAndReturn)
+	var out bytes.Buffer
+	evm := vm.NewEVM(vm.DefaultContext(), state)
+	evm.Tracer = tracers.NewJSONTracer(&out, tracers.JSONConfig{})
+	if result := evm.Call(sender, proxy, nil, vm.DefaultGasLimit, nil); !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+
+	// Steps of both frames, then a single summary ending the trace
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	var summaries, nested int
+	for i, line := range lines {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("line %d is not JSON: %s", i, line)
+		}
+		if _, ok := fields["gasUsed"]; ok {
+			summaries++
+			if i != len(lines)-1 {
+				t.Errorf("summary on line %d of %d: %s", i, len(lines), line)
+			}
+		} else if fields["depth"] == float64(2) {
+			nested++
+		}
+	}
+	if summaries != 1 {
+		t.Errorf("trace has %d summaries, want 1", summaries)
+	}
+	if nested == 0 {
+		t.Errorf("trace has no steps of the called frame")
+	}
+}
+
+func TestJSONTracerFaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     []byte
+		expected []string
+	}{
+		{
+			// The jump is traced before it fails, and again with the error
+			// once it has popped its destination
+			"invalid jump",
+			[]byte{byte(vm.PUSH1), 0x05, byte(vm.JUMP)},
+			[]string{
+				`{"pc":0,"op":96,"gas":"0x3e8","gasCost":"0x3","memSize":0,"stack":[],"depth":1,"refund":0,"opName":"PUSH1"}`,
+				`{"pc":2,"op":86,"gas":"0x3e5","gasCost":"0x8","memSize":0,"stack":["0x5"],"depth":1,"refund":0,"opName":"JUMP"}`,
+				`{"pc":2,"op":86,"gas":"0x3e5","gasCost":"0x8","memSize":0,"stack":[],"depth":1,"refund":0,"opName":"JUMP","error":"invalid jump destination"}`,
+				`{"output":"","gasUsed":"0x3e8","error":"invalid jump destination"}`,
+			},
+		},
+		{
+			// The ADD fails before it is charged and traced
+			"stack underflow",
+			[]byte{byte(vm.ADD)},
+			[]string{
+				`{"pc":0,"op":1,"gas":"0x3e8","gasCost":"0x0","memSize":0,"stack":[],"depth":1,"refund":0,"opName":"ADD","error":"stack underflow"}`,
+				`{"output":"","gasUsed":"0x3e8","error":"stack underflow"}`,
+			},
+		},
+		{
+			"invalid opcode",
+			[]byte{0x0c},
+			[]string{
+				`{"pc":0,"op":12,"gas":"0x3e8","gasCost":"0x0","memSize":0,"stack":[],"depth":1,"refund":0,"opName":"opcode 0xc not defined","error":"invalid opcode: 0xc"}`,
+				`{"output":"","gasUsed":"0x3e8","error":"invalid opcode: 0xc"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		lines := traceJSON(t, tt.code, tracers.JSONConfig{})
+		if strings.Join(lines, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("%s: trace\n%s\nwant\n%s", tt.name, strings.Join(lines, "\n"), strings.Join(tt.expected, "\n"))
+		}
+	}
+}
+
+// callFrame is a frame of the JSON written by a CallTracer
+type callFrame struct {
+	Type         string      `json:"type"`
+	From         string      `json:"from"`
+	To           *string     `json:"to"`
+	Value        *string     `json:"value"`
+	Gas          string      `json:"gas"`
+	GasUsed      string      `json:"gasUsed"`
+	Input        string      `json:"input"`
+	Output       string      `json:"output"`
+	Error        string      `json:"error"`
+	RevertReason string      `json:"revertReason"`
+	Calls        []callFrame `json:"calls"`
+	Logs         []struct {
+		Address  string `json:"address"`
+		Position string `json:"position"`
+	} `json:"logs"`
+}
+
+// traceCalls sends a transaction from sender to proxy under a CallTracer
+// and returns the call tree it recorded
+func traceCalls(t *testing.T, state *vm.MemoryStateDB, cfg tracers.CallConfig) callFrame {
+	t.Helper()
+	tracer := tracers.NewCallTracer(cfg)
+	evm := vm.NewEVM(vm.DefaultContext(), state)
+	evm.Tracer = tracer
+	evm.Call(sender, proxy, []byte{0x01}, 100000, nil)
+	res, err := tracer.Result()
+	if err != nil {
+		t.Fatalf("Result() failed: %v", err)
+	}
+	var frame callFrame
+	if err := json.Unmarshal(res, &frame); err != nil {
+		t.Fatalf("invalid call tree %s: %v", res, err)
+	}
+	return frame
+}
+
+// revertWith is code that reverts with data as its output
+func revertWith(data []byte) []byte {
+	var code []byte
+	for offset := 0; offset < len(data); offset += 32 {
+		var chunk [32]byte
+		copy(chunk[:], data[offset:])
+		code = append(code, byte(vm.PUSH32))
+		code = append(code, chunk[:]...)
+		code = append(code, byte(vm.PUSH1), byte(offset), byte(vm.MSTORE))
+	}
+	return append(code, byte(vm.PUSH1), byte(len(data)), byte(vm.PUSH1), 0x00, byte(vm.REVERT))
+}
+
+// hexAddress formats addr the way geth's JSON does
+func hexAddress(addr common.Address) string {
+	return strings.ToLower(addr.Hex())
+}
+
+func TestCallTracer(t *testing.T) {
+	// Error("nope"), as Solidity encodes the reason of a failed require
+	reason := append(crypto.Keccak256([]byte("Error(string)"))[:4], common.LeftPadBytes([]byte{0x20}, 32)...)
+	reason = append(reason, common.LeftPadBytes([]byte{0x04}, 32)...)
+	reason = append(reason, common.RightPadBytes([]byte("nope"), 32)...)
+
+	// The proxy logs, then calls the callee, which logs and reverts
+	state := vm.NewMemoryStateDB()
+	state.SetCode(proxy, append(logProgram(1), callProgram(vm.CALL, callee, 0)...))
+	state.SetCode(callee, append(logProgram(0), revertWith(reason)...))
+
+	frame := traceCalls(t, state, tracers.CallConfig{WithLog: true})
+	if frame.Type != "CALL" || frame.From != hexAddress(sender) || *frame.To != hexAddress(proxy) {
+		t.Errorf("transaction frame %s %s -> %s", frame.Type, frame.From, *frame.To)
+	}
+	// The transaction's frame has the gas of the whole transaction
+	if frame.Gas != "0x186a0" || frame.Input != "0x01" || frame.Value == nil || *frame.Value != "0x0" || frame.Error != "" {
+		t.Errorf("transaction frame gas %s input %s error %q", frame.Gas, frame.Input, frame.Error)
+	}
+	if len(frame.Logs) != 1 || frame.Logs[0].Address != hexAddress(proxy) || frame.Logs[0].Position != "0x0" {
+		t.Errorf("transaction frame logs = %+v, want the proxy's log", frame.Logs)
+	}
+	if len(frame.Calls) != 1 {
+		t.Fatalf("transaction frame has %d calls, want 1", len(frame.Calls))
+	}
+
+	call := frame.Calls[0]
+	if call.Type != "CALL" || call.From != hexAddress(proxy) || *call.To != hexAddress(callee) {
+		t.Errorf("call %s %s -> %s", call.Type, call.From, *call.To)
+	}
+	if call.Error != "execution reverted" || call.RevertReason != "nope" || call.Output != "0x"+common.Bytes2Hex(reason) {
+		t.Errorf("call error %q reason %q output %s", call.Error, call.RevertReason, call.Output)
+	}
+	// The log of the reverted frame was dropped
+	if len(call.Logs) != 0 {
+		t.Errorf("reverted call has logs %+v", call.Logs)
+	}
+
+	// Only the transaction's frame, without logs
+	frame = traceCalls(t, state, tracers.CallConfig{OnlyTopCall: true})
+	if len(frame.Calls) != 0 || len(frame.Logs) != 0 {
+		t.Errorf("top call only has %d calls and %d logs", len(frame.Calls), len(frame.Logs))
+	}
+}
+
+func TestCallTracerGasRefund(t *testing.T) {
+	// The proxy clears a slot, which refunds gas
+	state := vm.NewMemoryStateDB()
+	state.SetCode(proxy, []byte{byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.SSTORE)})
+	state.SetState(proxy, common.Hash{}, common.BytesToHash([]byte{0x01}))
+
+	tracer := tracers.NewCallTracer(tracers.CallConfig{})
+	evm := vm.NewEVM(vm.DefaultContext(), state)
+	evm.Tracer = tracer
+	result := evm.Call(sender, proxy, nil, 100000, nil)
+	if !result.Success || result.GasRefund == 0 {
+		t.Fatalf("Call() success %v refund %d, want a refund", result.Success, result.GasRefund)
+	}
+	res, err := tracer.Result()
+	if err != nil {
+		t.Fatalf("Result() failed: %v", err)
+	}
+	var frame callFrame
+	if err := json.Unmarshal(res, &frame); err != nil {
+		t.Fatalf("invalid call tree %s: %v", res, err)
+	}
+	// The transaction's frame used the gas left once the refund is paid back
+	if want := hexutil.Uint64(result.GasUsed - result.GasRefund).String(); frame.GasUsed != want {
+		t.Errorf("transaction frame gas used %s, want %s", frame.GasUsed, want)
+	}
+}
+
+func TestCallTracerFrameTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     []byte
+		typ      string
+		to       string // empty for none
+		value    bool
+		errorMsg string
+	}{
+		{"call", callProgram(vm.CALL, callee, 0), "CALL", hexAddress(callee), true, ""},
+		{"static call", callProgram(vm.STATICCALL, callee, 0), "STATICCALL", hexAddress(callee), false, ""},
+		// Runs on behalf of the proxy, with the value it was sent
+		{"delegate call", callProgram(vm.DELEGATECALL, callee, 0), "DELEGATECALL", hexAddress(callee), true, ""},
+		{"create", factory(vm.CREATE, []byte{byte(vm.STOP)}), "CREATE", hexAddress(vm.CreateAddress(proxy, 0)), true, ""},
+		{"create2", factory(vm.CREATE2, []byte{byte(vm.STOP)}), "CREATE2", hexAddress(vm.CreateAddress2(proxy, common.BigToHash(common.Big1), []byte{byte(vm.STOP)})), true, ""},
+		// A failed creation has no address
+		{"failed create", factory(vm.CREATE, []byte{byte(vm.INVALID)}), "CREATE", "", true, "invalid opcode: 0xfe"},
+	}
+	for _, tt := range tests {
+		state := vm.NewMemoryStateDB()
+		state.SetCode(proxy, tt.code)
+		state.SetCode(callee, []byte{byte(vm.STOP)})
+		frame := traceCalls(t, state, tracers.CallConfig{})
+		if len(frame.Calls) != 1 {
+			t.Errorf("%s: %d calls, want 1", tt.name, len(frame.Calls))
+			continue
+		}
+		call := frame.Calls[0]
+		if call.Type != tt.typ || call.From != hexAddress(proxy) || call.Error != tt.errorMsg {
+			t.Errorf("%s: %s from %s error %q", tt.name, call.Type, call.From, call.Error)
+		}
+		if to := call.To; (to == nil) != (tt.to == "") || (to != nil && *to != tt.to) {
+			t.Errorf("%s: to = %v, want %q", tt.name, to, tt.to)
+		}
+		if (call.Value != nil) != tt.value {
+			t.Errorf("%s: value = %v", tt.name, call.Value)
+		}
+	}
+}
+
+// prestateAccount is an account of the JSON written by a PrestateTracer
+type prestateAccount struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Code    hexutil.Bytes               `json:"code"`
+	Nonce   uint64                      `json:"nonce"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// tracePrestate sends a transaction from sender to proxy under a
+// PrestateTracer and returns the JSON it recorded
+func tracePrestate(t *testing.T, state *vm.MemoryStateDB, cfg tracers.PrestateConfig) []byte {
+	t.Helper()
+	tracer := tracers.NewPrestateTracer(cfg)
+	evm := vm.NewEVM(vm.DefaultContext(), state)
+	evm.Tracer = tracer
+	if result := evm.Call(sender, proxy, nil, vm.DefaultGasLimit, nil); !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+	res, err := tracer.Result()
+	if err != nil {
+		t.Fatalf("Result() failed: %v", err)
+	}
+	return res
+}
+
+// prestateContracts sets up the proxy, which reads the balance of other
+// and calls the callee, which reads slot 1 and writes 1 to slot 0
+func prestateContracts() (state *vm.MemoryStateDB, other common.Address) {
+	other = common.HexToAddress("0x6000000000000000000000000000000000000006")
+	state = vm.NewMemoryStateDB()
+	proxyCode := append([]byte{byte(vm.PUSH20)}, other[:]...)
+	proxyCode = append(proxyCode, byte(vm.BALANCE), byte(vm.POP))
+	state.SetCode(proxy, append(proxyCode, callProgram(vm.CALL, callee, 0)...))
+	state.SetCode(callee, append([]byte{byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.POP)}, storeOne...))
+	state.SetState(callee, common.BigToHash(common.Big1), common.BigToHash(common.Big3))
+	state.AddBalance(other, uint256.NewInt(5))
+	state.SetNonce(sender, 4)
+	state.Commit()
+	return state, other
+}
+
+func TestPrestateTracer(t *testing.T) {
+	state, other := prestateContracts()
+	var pre map[common.Address]prestateAccount
+	if err := json.Unmarshal(tracePrestate(t, state, tracers.PrestateConfig{}), &pre); err != nil {
+		t.Fatalf("invalid prestate: %v", err)
+	}
+
+	// The sender, the proxy, the callee, the account whose balance was
+	// read and the coinbase, as they were before the transaction
+	if len(pre) != 5 {
+		t.Errorf("prestate has %d accounts, want 5", len(pre))
+	}
+	if acc := pre[sender]; acc.Nonce != 4 || acc.Balance == nil || acc.Balance.ToInt().Sign() != 0 {
+		t.Errorf("sender = %+v, want nonce 4", acc)
+	}
+	if acc := pre[other]; acc.Balance == nil || acc.Balance.ToInt().Int64() != 5 {
+		t.Errorf("balance read from %+v, want 5", acc)
+	}
+	if acc := pre[proxy]; !bytes.Equal(acc.Code, state.GetCode(proxy)) || len(acc.Storage) != 0 {
+		t.Errorf("proxy = %+v", acc)
+	}
+	// Both the slot read and the slot written, with their old values
+	expected := map[common.Hash]common.Hash{
+		common.BigToHash(common.Big0): {},
+		common.BigToHash(common.Big1): common.BigToHash(common.Big3),
+	}
+	if acc := pre[callee]; !reflect.DeepEqual(acc.Storage, expected) {
+		t.Errorf("callee storage = %v, want %v", acc.Storage, expected)
+	}
+	if _, ok := pre[vm.DefaultContext().Coinbase]; !ok {
+		t.Errorf("prestate is missing the coinbase")
+	}
+
+	// Without code or storage
+	pre = nil
+	json.Unmarshal(tracePrestate(t, state, tracers.PrestateConfig{DisableCode: true, DisableStorage: true}), &pre)
+	if acc := pre[callee]; acc.Code != nil || acc.Storage != nil {
+		t.Errorf("callee = %+v, want no code or storage", acc)
+	}
+}
+
+func TestPrestateTracerDiff(t *testing.T) {
+	state, _ := prestateContracts()
+	var diff struct {
+		Pre  map[common.Address]prestateAccount `json:"pre"`
+		Post map[common.Address]prestateAccount `json:"post"`
+	}
+	if err := json.Unmarshal(tracePrestate(t, state, tracers.PrestateConfig{DiffMode: true}), &diff); err != nil {
+		t.Fatalf("invalid diff: %v", err)
+	}
+
+	// Only the sender's nonce and the slot written changed
+	if len(diff.Pre) != 2 || len(diff.Post) != 2 {
+		t.Errorf("diff of %d accounts before and %d after, want 2", len(diff.Pre), len(diff.Post))
+	}
+	if diff.Pre[sender].Nonce != 4 || diff.Post[sender].Nonce != 5 || diff.Post[sender].Balance != nil {
+		t.Errorf("sender %+v -> %+v, want nonce 4 -> 5", diff.Pre[sender], diff.Post[sender])
+	}
+	// The slot was empty, so it is only in the post state; the slot read
+	// is in neither
+	if storage := diff.Pre[callee].Storage; len(storage) != 0 {
+		t.Errorf("callee storage before = %v, want none", storage)
+	}
+	expected := map[common.Hash]common.Hash{common.BigToHash(common.Big0): common.BigToHash(common.Big1)}
+	if storage := diff.Post[callee].Storage; !reflect.DeepEqual(storage, expected) {
+		t.Errorf("callee storage after = %v, want %v", storage, expected)
+	}
+
+	// A created contract has no state before and its code and nonce after
+	state = vm.NewMemoryStateDB()
+	initCode := []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.RETURN)}
+	state.SetCode(proxy, factory(vm.CREATE, initCode))
+	diff.Pre, diff.Post = nil, nil
+	if err := json.Unmarshal(tracePrestate(t, state, tracers.PrestateConfig{DiffMode: true}), &diff); err != nil {
+		t.Fatalf("invalid diff: %v", err)
+	}
+	created := vm.CreateAddress(proxy, 0)
+	if _, ok := diff.Pre[created]; ok {
+		t.Errorf("created contract has a prestate")
+	}
+	if acc := diff.Post[created]; acc.Nonce != 1 || !bytes.Equal(acc.Code, []byte{byte(vm.STOP)}) {
+		t.Errorf("created contract = %+v, want nonce 1 and code 00", acc)
+	}
+	if diff.Post[proxy].Nonce != 1 {
+		t.Errorf("factory nonce after = %d, want 1", diff.Post[proxy].Nonce)
+	}
+}
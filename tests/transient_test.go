@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"solidity-vm-go/internal/vm"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// tstoreOne is code that writes 1 to transient slot 0
+var tstoreOne = []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.TSTORE)}
+
+// tloadZero returns transient slot 0 as one word
+var tloadZero = returnTop(byte(vm.PUSH1), 0x00, byte(vm.TLOAD))
+
+func TestTransientStorage(t *testing.T) {
+	state := vm.NewMemoryStateDB()
+	state.SetCode(receiver, append(append([]byte{}, tstoreOne...), tloadZero...))
+	evm := vm.NewEVM(vm.DefaultContext(), state)
+
+	result := evm.Call(sender, receiver, nil, vm.DefaultGasLimit, nil)
+	if !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+	if !bytes.Equal(result.ReturnData, word(big.NewInt(1))) {
+		t.Errorf("TLOAD after TSTORE = %x, want 1", result.ReturnData)
+	}
+	if got := state.GetState(receiver, common.Hash{}); got != (common.Hash{}) {
+		t.Errorf("TSTORE wrote to storage: slot 0 = %s", got)
+	}
+
+	// The next transaction starts with empty transient storage
+	state.SetCode(receiver, tloadZero)
+	if result := evm.Call(sender, receiver, nil, vm.DefaultGasLimit, nil); !bytes.Equal(result.ReturnData, word(big.NewInt(0))) {
+		t.Errorf("TLOAD in the next transaction = %x, want 0", result.ReturnData)
+	}
+}
+
+func TestTransientStorageRevert(t *testing.T) {
+	// The proxy delegates to the callee, which shares its transient
+	// storage, and then returns transient slot 0
+	delegate := append(append([]byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH20)}, callee[:]...), byte(vm.GAS), byte(vm.DELEGATECALL), byte(vm.POP))
+	delegate = append(delegate, tloadZero...)
+
+	tests := []struct {
+		name     string
+		callee   []byte
+		expected int64
+	}{
+		{"kept", tstoreOne, 1},
+		{"reverted", append(append([]byte{}, tstoreOne...), byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.REVERT)), 0},
+		{"failed", append(append([]byte{}, tstoreOne...), byte(vm.INVALID)), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := vm.NewMemoryStateDB()
+			state.SetCode(proxy, delegate)
+			state.SetCode(callee, tt.callee)
+			result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+			if !result.Success {
+				t.Fatalf("Call() failed: %v", result.Error)
+			}
+			if !bytes.Equal(result.ReturnData, word(big.NewInt(tt.expected))) {
+				t.Errorf("transient slot 0 = %x, want %d", result.ReturnData, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTransientStorageWriteProtection(t *testing.T) {
+	state := vm.NewMemoryStateDB()
+	state.SetCode(proxy, callProgram(vm.STATICCALL, callee, 0))
+	state.SetCode(callee, tstoreOne)
+
+	result := vm.NewEVM(vm.DefaultContext(), state).Call(sender, proxy, nil, vm.DefaultGasLimit, nil)
+	if !result.Success {
+		t.Fatalf("Call() failed: %v", result.Error)
+	}
+	if success := result.ReturnData[64:]; !bytes.Equal(success, make([]byte, 32)) {
+		t.Errorf("TSTORE succeeded in a static call")
+	}
+}
+
+func TestTransientStorageGas(t *testing.T) {
+	// Two pushes, TSTORE, one push, TLOAD; transient storage has no cold
+	// accesses and no refunds
+	code := append(append([]byte{}, tstoreOne...), byte(vm.PUSH1), 0x00, byte(vm.TLOAD))
+	result := vm.Execute(vm.Contract{Bytecode: code}, nil)
+	if !result.Success {
+		t.Fatalf("Execute() failed: %v", result.Error)
+	}
+	if expected := uint64(3 + 3 + 100 + 3 + 100); result.GasUsed != expected {
+		t.Errorf("GasUsed = %d, want %d", result.GasUsed, expected)
+	}
+	if result.GasRefund != 0 {
+		t.Errorf("GasRefund = %d, want 0", result.GasRefund)
+	}
+}
@@ -1,12 +1,18 @@
 package tests
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math/big"
 	"testing"
 
 	"solidity-vm-go/internal/compiler"
 	"solidity-vm-go/internal/parser"
 	"solidity-vm-go/internal/vm"
+	"solidity-vm-go/pkg/utils"
+
+	"github.com/holiman/uint256"
 )
 
 func TestVMExecution(t *testing.T) {
@@ -21,9 +27,9 @@ func TestVMExecution(t *testing.T) {
 		}
 	`
 
-	// Parse the Solidity code
-	p := parser.NewParser()
-	ast, err := p.Parse(solidityCode)
+	// Parse the Solidity code into its contract definition, the AST the
+	// compiler takes
+	ast, err := parser.ParseSolidity(solidityCode)
 	if err != nil {
 		t.Fatalf("Failed to parse Solidity code: %v", err)
 	}
@@ -45,8 +51,174 @@ func TestVMExecution(t *testing.T) {
 	}
 
 	// Validate the result
-	expected := uint(3) // Expected result of add(1, 2)
-	if result != expected {
-		t.Errorf("Expected %d, got %d", expected, result)
+	expected := uint64(3) // Expected result of add(1, 2)
+	top, ok := result.(*uint256.Int)
+	if !ok || top.Uint64() != expected {
+		t.Errorf("Expected %d, got %v", expected, result)
+	}
+}
+
+func TestVMReuse(t *testing.T) {
+	m := vm.NewVM()
+	result, err := m.Execute([]byte{byte(vm.PUSH1), 0x02, byte(vm.PUSH1), 0x03, byte(vm.ADD), byte(vm.STOP)})
+	if top, ok := result.(*uint256.Int); err != nil || !ok || top.Uint64() != 5 {
+		t.Fatalf("first Execute() = %v, %v, want 5", result, err)
+	}
+
+	// The second program runs from its start on an empty stack
+	result, err = m.Execute([]byte{byte(vm.PUSH1), 0x07, byte(vm.STOP)})
+	if top, ok := result.(*uint256.Int); err != nil || !ok || top.Uint64() != 7 {
+		t.Fatalf("second Execute() = %v, %v, want 7", result, err)
+	}
+	if len(m.Stack) != 1 {
+		t.Errorf("stack has %d items after the second run, want 1", len(m.Stack))
+	}
+
+	// Memory and output are not carried over either
+	if res := m.Run(vm.Contract{Bytecode: storeAndHalt([]byte("hello"), vm.RETURN)}, nil); !res.Success {
+		t.Fatalf("Run() error = %v", res.Error)
+	}
+	res := m.Run(vm.Contract{Bytecode: []byte{byte(vm.MSIZE), byte(vm.STOP)}}, nil)
+	if !res.Success || len(res.ReturnData) != 0 {
+		t.Errorf("Run() = %+v, want success without data", res)
+	}
+	if top := m.Stack[len(m.Stack)-1]; !top.IsZero() {
+		t.Errorf("MSIZE = %d after a new run, want 0", top.Uint64())
+	}
+}
+
+func TestExecute256BitArithmetic(t *testing.T) {
+	maxWord := bytes.Repeat([]byte{0xff}, 32)
+	twoTo64 := new(big.Int).Lsh(big.NewInt(1), 64)
+
+	tests := []struct {
+		name     string
+		bytecode []byte
+		expected *big.Int
+	}{
+		{
+			name:     "add above 2^64",
+			bytecode: push32Then(twoTo64.Bytes(), byte(vm.PUSH1), 0x05, byte(vm.ADD), byte(vm.STOP)),
+			expected: new(big.Int).Add(twoTo64, big.NewInt(5)),
+		},
+		{
+			name:     "add wraps at 2^256",
+			bytecode: push32Then(maxWord, byte(vm.PUSH1), 0x01, byte(vm.ADD), byte(vm.STOP)),
+			expected: big.NewInt(0),
+		},
+		{
+			name:     "sub underflow wraps",
+			bytecode: []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SUB), byte(vm.STOP)},
+			expected: new(big.Int).SetBytes(maxWord),
+		},
+		{
+			name:     "mul of 2^64 by itself",
+			bytecode: push32Then(twoTo64.Bytes(), push32Then(twoTo64.Bytes(), byte(vm.MUL), byte(vm.STOP))...),
+			expected: new(big.Int).Lsh(big.NewInt(1), 128),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top, err := stackTop(tt.bytecode)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if got := new(big.Int).SetBytes(top); got.Cmp(tt.expected) != 0 {
+				t.Errorf("Execute() left %s on the stack, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+// push32Then builds PUSH32 <value> followed by the given code
+func push32Then(value []byte, code ...byte) []byte {
+	bytecode := append([]byte{byte(vm.PUSH32)}, utils.PadLeft(value, 32)...)
+	return append(bytecode, code...)
+}
+
+// stackTop runs code on a fresh VM and returns the 32-byte word left on top
+// of the stack
+func stackTop(code []byte) ([]byte, error) {
+	top, err := vm.NewVM().Execute(code)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := top.(*uint256.Int)
+	if !ok {
+		return nil, nil
+	}
+	word := value.Bytes32()
+	return word[:], nil
+}
+
+// storeAndHalt builds code that writes data to memory at offset 0 and then
+// ends with RETURN or REVERT over exactly that range
+func storeAndHalt(data []byte, halt vm.OpCode) []byte {
+	var code []byte
+	for offset := 0; offset < len(data); offset += 32 {
+		chunk := utils.PadRight(data[offset:min(offset+32, len(data))], 32)
+		code = append(code, push32Then(chunk, byte(vm.PUSH2), byte(offset>>8), byte(offset), byte(vm.MSTORE))...)
 	}
+	return append(code, byte(vm.PUSH2), byte(len(data)>>8), byte(len(data)), byte(vm.PUSH1), 0x00, byte(halt))
+}
+
+func TestReturnAndRevert(t *testing.T) {
+	// Error("nope") as emitted by require(false, "nope")
+	revertPayload := append([]byte{0x08, 0xc3, 0x79, 0xa0}, word(big.NewInt(32))...)
+	revertPayload = append(revertPayload, word(big.NewInt(4))...)
+	revertPayload = append(revertPayload, utils.PadRight([]byte("nope"), 32)...)
+
+	t.Run("RETURN copies a memory range", func(t *testing.T) {
+		data := []byte("hello, world")
+		result := vm.Execute(vm.Contract{Bytecode: storeAndHalt(data, vm.RETURN)}, nil)
+		if !result.Success {
+			t.Fatalf("Execute() error = %v", result.Error)
+		}
+		if !bytes.Equal(result.ReturnData, data) {
+			t.Errorf("ReturnData = %q, want %q", result.ReturnData, data)
+		}
+	})
+
+	t.Run("REVERT keeps its payload", func(t *testing.T) {
+		result := vm.Execute(vm.Contract{Bytecode: storeAndHalt(revertPayload, vm.REVERT)}, nil)
+		if result.Success || !result.Reverted {
+			t.Fatalf("Execute() Success = %v, Reverted = %v, want a revert", result.Success, result.Reverted)
+		}
+		if !errors.Is(result.Error, vm.ErrExecutionReverted) {
+			t.Errorf("Error = %v, want %v", result.Error, vm.ErrExecutionReverted)
+		}
+		if !bytes.Equal(result.ReturnData, revertPayload) {
+			t.Errorf("ReturnData = %x, want %x", result.ReturnData, revertPayload)
+		}
+		if reason, ok := result.RevertReason(); !ok || reason != "nope" {
+			t.Errorf("RevertReason() = %q, %v, want \"nope\", true", reason, ok)
+		}
+		if result.GasUsed >= vm.DefaultGasLimit {
+			t.Errorf("GasUsed = %d, a revert must not consume all gas", result.GasUsed)
+		}
+	})
+
+	t.Run("exceptional halt consumes all gas", func(t *testing.T) {
+		// RETURNDATACOPY of one byte from an empty buffer
+		bytecode := []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.RETURNDATACOPY)}
+		result := vm.Execute(vm.Contract{Bytecode: bytecode}, nil)
+		if result.Success || result.Reverted {
+			t.Fatalf("Execute() Success = %v, Reverted = %v, want an exceptional halt", result.Success, result.Reverted)
+		}
+		if !errors.Is(result.Error, vm.ErrReturnDataOutOfBounds) {
+			t.Errorf("Error = %v, want %v", result.Error, vm.ErrReturnDataOutOfBounds)
+		}
+		if result.GasUsed != vm.DefaultGasLimit {
+			t.Errorf("GasUsed = %d, want all %d", result.GasUsed, vm.DefaultGasLimit)
+		}
+	})
+
+	t.Run("STOP returns no data", func(t *testing.T) {
+		bytecode := []byte{byte(vm.PUSH1), 0x01, byte(vm.STOP)}
+		result := vm.Execute(vm.Contract{Bytecode: bytecode}, nil)
+		if !result.Success || len(result.ReturnData) != 0 {
+			t.Errorf("Execute() = %+v, want success without data", result)
+		}
+	})
 }